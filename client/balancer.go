@@ -0,0 +1,37 @@
+package client
+
+import (
+	"errors"
+	"math/rand"
+	"sync/atomic"
+)
+
+// Balancer 从候选的服务端地址中选出下一次调用使用的地址，实现必须可以被并发调用。
+type Balancer interface {
+	Next(servers []string) (string, error)
+}
+
+var errNoServers = errors.New("rpc xclient: no available servers")
+
+// RandomSelect 随机选择服务端
+type RandomSelect struct{}
+
+func (RandomSelect) Next(servers []string) (string, error) {
+	if len(servers) == 0 {
+		return "", errNoServers
+	}
+	return servers[rand.Intn(len(servers))], nil
+}
+
+// RoundRobinSelect 依次轮询服务端，零值从第一个服务端开始
+type RoundRobinSelect struct {
+	index uint64
+}
+
+func (r *RoundRobinSelect) Next(servers []string) (string, error) {
+	if len(servers) == 0 {
+		return "", errNoServers
+	}
+	i := atomic.AddUint64(&r.index, 1) - 1
+	return servers[i%uint64(len(servers))], nil
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// BatchRequest 是 BatchCall 中的一个调用
+type BatchRequest struct {
+	ServiceMethod string      // format "<service>.<method>"
+	Args          interface{} // arguments to the function
+	Reply         interface{} // reply from the function, must be a pointer
+}
+
+// BatchResult 是 BatchRequest 对应的结果，Error 为 nil 时 Reply 已经写入
+type BatchResult struct {
+	Error error
+}
+
+// BatchCall 先注册 reqs 中的所有调用，再在一次持有 sending 的期间连续写出它们的请求，
+// 之后按响应到达的顺序收集结果，使 N 个调用只需要一次往返。每个调用独立成功或失败，
+// 返回的结果与 reqs 一一对应。ctx 结束时仍未完成的调用被取消并通知服务端，其结果的 Error 包装了 ctx.Err()。
+// ctx 的 deadline 随每个请求发给服务端。BatchCall 不经过 Use 注册的拦截器。
+func (c *Client) BatchCall(ctx context.Context, reqs []BatchRequest) []BatchResult {
+	results := make([]BatchResult, len(reqs))
+	if len(reqs) == 0 {
+		return results
+	}
+	deadline, _ := ctx.Deadline()
+	done := make(chan *Call, len(reqs))
+	calls := make([]*Call, len(reqs))
+	index := make(map[*Call]int, len(reqs))
+	for i, req := range reqs {
+		calls[i] = &Call{
+			ServiceMethod: req.ServiceMethod,
+			Args:          req.Args,
+			Reply:         req.Reply,
+			Done:          done,
+			logger:        c.opt.Logger,
+			stats:         c.stats,
+			deadline:      deadline,
+		}
+		c.startSpan(ctx, calls[i])
+		index[calls[i]] = i
+	}
+
+	c.sending.Lock()
+	seqs := make([]uint64, len(calls))
+	for i, call := range calls {
+		seq, err := c.registerCall(call)
+		if err != nil {
+			call.Error = err
+			call.done()
+			continue
+		}
+		seqs[i] = seq
+	}
+	for i, call := range calls {
+		if seqs[i] != 0 {
+			c.sendRegistered(seqs[i], call)
+		}
+	}
+	c.sending.Unlock()
+
+	finished := make([]bool, len(calls))
+	ctxDone := ctx.Done()
+	for remaining := len(calls); remaining > 0; {
+		select {
+		case call := <-done:
+			i := index[call]
+			results[i].Error, finished[i] = call.Error, true
+			remaining--
+		case <-ctxDone:
+			err := fmt.Errorf("rpc client: call failed: %w", ctx.Err())
+			for i, call := range calls {
+				// removeCall 返回 nil 的调用已经被 receive 取走，结果仍会送到 done
+				if !finished[i] && c.removeCall(call.Seq) != nil {
+					c.sendCancel(call.Seq)
+					call.record(err)
+					results[i].Error, finished[i] = err, true
+					remaining--
+				}
+			}
+			ctxDone = nil
+		}
+	}
+	return results
+}
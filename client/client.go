@@ -2,13 +2,16 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"xxrpc/common"
@@ -23,11 +26,82 @@ type Call struct {
 	Reply         interface{} // reply from the function 函数的返回值
 	Error         error       // if error occurs, it will be set
 	Done          chan *Call  // Strobes when call is complete.
+
+	validator func(reply interface{}) error // checks the decoded reply, see WithReplyValidator
+	capture   func(sent, received []byte)   // receives the raw bytes of this call, see WithWireCapture
+	sent      []byte                        // bytes written for the request when capture is set
+	metadata  map[string]string             // sent in the request header, see CallWithMeta
+	deadline  time.Time                     // the ctx deadline of Call, sent in the request header
+	logger    common.Logger                 // the client's logger, see Option.Logger
+	stats     *callStats                    // the client's stats, nil for pings
+	finished  chan struct{}                 // closed by done, stops the ctx watcher of GoContext
+	span      common.Span                   // started by the client's Tracer, nil when tracing is off
+}
+
+// CallOption configures a single call made with Go or Call.
+type CallOption func(*Call)
+
+// WithReplyValidator 在成功解码 reply 后调用 f，f 返回的错误将作为该调用的错误
+func WithReplyValidator(f func(reply interface{}) error) CallOption {
+	return func(call *Call) {
+		call.validator = f
+	}
+}
+
+// ErrPartialReply 表示收到了响应的 header 但没有收到完整的 body，
+// 服务端可能已经处理了该请求，调用方需要据此判断是否可以安全重试。
+var ErrPartialReply = errors.New("rpc client: partial reply received")
+
+// readBodyError 包装读取响应 body 的错误 err。只有连接中断或消息超出限制使 body 没有读完时
+// 才是 ErrPartialReply，解码失败等错误只是包装 err。
+func readBodyError(err error) error {
+	var netErr net.Error
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr) ||
+		errors.Is(err, xxcode.ErrMessageTooLarge) {
+		return fmt.Errorf("%w: reading body: %w", ErrPartialReply, err)
+	}
+	return fmt.Errorf("rpc client: reading body: %w", err)
+}
+
+// ErrRejected 表示服务端拒绝了连接的握手，例如 MagicNumber 不匹配或认证失败，
+// 错误链中的 *ServerError 携带服务端给出的原因。之后该 Client 上的所有调用都返回这个错误。
+var ErrRejected = errors.New("rpc client: connection rejected by server")
+
+// ServerError 是服务端在响应中报告的错误，例如找不到方法或处理函数返回的错误，
+// 调用方可以通过 errors.As 将它与连接断开等本地错误区分开。
+// Code 为服务端提供的错误码，见 xxcode.CodeNotFound 等，0 表示没有分类。
+type ServerError struct {
+	Message string // 响应 header 中的错误信息
+	Code    int    // 响应 header 中的错误码
+}
+
+func (e *ServerError) Error() string {
+	return e.Message
 }
 
 // done 为了支持异步调用，当调用结束时，会调用 call.done() 通知调用方。
+// Done 已满时丢弃通知而不是阻塞，避免一个读取缓慢的调用方卡住 receive，
+// 调用方需要保证 Done 的容量足够容纳路由到它的所有调用。
 func (call *Call) done() {
-	call.Done <- call
+	call.record(call.Error)
+	if call.finished != nil {
+		close(call.finished)
+	}
+	select {
+	case call.Done <- call:
+	default:
+		common.DefaultLogger(call.logger).Println("rpc client: discarding Call reply due to insufficient Done chan capacity")
+	}
+}
+
+// record 记录调用的结果，调用方放弃等待的调用不会再经过 done，需要直接调用 record
+func (call *Call) record(err error) {
+	if call.stats != nil {
+		call.stats.finish(err)
+	}
+	if call.span != nil {
+		call.span.Finish(err)
+	}
 }
 
 // Client 客户端代表一个RPC客户端。
@@ -44,34 +118,78 @@ type Client struct {
 	pending  map[uint64]*Call //存储未处理完的请求，键是编号，值是 Call 实例。
 	closing  bool             // user has called Close,用户主动关闭的
 	shutdown bool             // server has told us to stop, 一般是有错误发生。
+	rejected error            // the server refused the handshake, reported instead of ErrShutdown
+	tls      bool             // the underlying connection is a *tls.Conn
+	codeType xxcode.Type      // codec of the connection, chosen by the server when negotiating Option.AcceptCodecs
+	ownCode  bool             // cc was created by the client, its buffers are released once receive exits
+
+	redial       func() (*redialed, error) // re-establishes the connection when Option.Reconnect is set
+	reconnecting bool                      // the connection is lost and redial is in progress
+
+	interceptors []ClientInterceptor // wrap Call, see Use
+	stats        *callStats          // counters reported by Stats
+}
+
+// ConnInfo describes the connection parameters the client agreed on with the server.
+type ConnInfo struct {
+	CodeType        xxcode.Type // codec used for headers and bodies
+	TLS             bool        // whether the connection is encrypted with TLS
+	Compression     string      // algorithm compressing large bodies, "" for none
+	ProtocolVersion int         // version sent in the handshake, see common.ProtocolVersion
+}
+
+// ConnInfo returns the effective parameters of the client's connection.
+func (c *Client) ConnInfo() ConnInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ConnInfo{
+		CodeType:        c.codeType,
+		TLS:             c.tls,
+		Compression:     c.opt.Compression,
+		ProtocolVersion: c.opt.ProtocolVersion,
+	}
 }
 
 var _ io.Closer = (*Client)(nil)
 
+// ErrShutdown 表示连接已被用户关闭
+var ErrShutdown = errors.New("connection is closed")
+
 // Close the connection
 func (c *Client) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.closing {
-		return errors.New("connection is closed")
+		return ErrShutdown
 	}
 	c.closing = true
 	return c.cc.Close()
 }
 
+// logger 返回 Option.Logger，没有设置时返回标准库默认的 logger
+func (c *Client) logger() common.Logger {
+	return common.DefaultLogger(c.opt.Logger)
+}
+
 // IsAvailable return true if the client does work
 func (c *Client) IsAvailable() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return !c.shutdown && !c.closing
+	return !c.shutdown && !c.closing && !c.reconnecting
 }
 
 // 将参数 call 添加到 client.pending 中，并更新 client.seq。
 func (c *Client) registerCall(call *Call) (uint64, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.rejected != nil {
+		return 0, c.rejected
+	}
 	if c.closing || c.shutdown {
-		return 0, errors.New("connection is closed")
+		return 0, ErrShutdown
+	}
+	if c.reconnecting {
+		return 0, ErrReconnecting
 	}
 
 	c.pending[c.seq] = call
@@ -89,15 +207,24 @@ func (c *Client) removeCall(seq uint64) *Call {
 	return call
 }
 
-// 服务端或客户端发生错误时调用，将 shutdown 设置为 true，且将错误信息通知所有 pending 状态的 call
+// 服务端或客户端发生错误时调用，将 shutdown 设置为 true，且将错误信息通知所有 pending 状态的 call。
+// 多次调用是安全的，每个 call 只会被通知一次；用户主动 Close 导致的终止统一报告 ErrShutdown。
 func (c *Client) terminateCalls(err error) {
-	// TODO:
 	c.sending.Lock()
 	defer c.sending.Unlock()
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.shutdown {
+		return
+	}
 	c.shutdown = true
-	for _, call := range c.pending {
+	if c.closing {
+		err = ErrShutdown
+	} else if errors.Is(err, ErrRejected) {
+		c.rejected = err
+	}
+	for seq, call := range c.pending {
+		delete(c.pending, seq)
 		call.Error = err
 		call.done()
 	}
@@ -108,61 +235,155 @@ func (c *Client) terminateCalls(err error) {
 // call 存在，但服务端处理出错，即 head.Error 不为空。
 // call 存在，服务端处理正常，那么需要从 body 中读取 Reply 的值。
 func (c *Client) receive() {
-	var err error
+	for {
+		err := c.receiveLoop()
+		// 开启重连时，重新拨号成功后继续接收；被服务端拒绝的握手重连也不会成功
+		if !errors.Is(err, ErrRejected) && c.reconnect(err) {
+			continue
+		}
+		// 发生错误，终止c.pending中待定的调用
+		c.terminateCalls(err)
+		c.releaseCode()
+		return
+	}
+}
+
+// releaseCode 在 receive 退出后归还 Client 创建的编解码器的缓冲。
+// terminateCalls 之后 send、Notify 和 sendCancel 都不再写入，持有 sending 等待正在进行的写入结束
+func (c *Client) releaseCode() {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	if c.ownCode {
+		xxcode.ReleaseCode(c.cc)
+	}
+}
+
+// receiveLoop 在当前连接上不断读取响应，直到发生错误
+func (c *Client) receiveLoop() (err error) {
 	for err == nil {
 		var h xxcode.Header
 		if err = c.cc.ReadHeader(&h); err != nil {
 			break
 		}
+		if h.ServiceMethod == xxcode.RejectServiceMethod {
+			return fmt.Errorf("%w: %w", ErrRejected, &ServerError{Message: h.Error, Code: h.ErrorCode})
+		}
 		// 从c.pending中依取出call
 		call := c.removeCall(h.SeqId)
 		switch {
 		case call == nil: // 写入失败或者调用已经被删除
 			err = c.cc.ReadBody(nil)
 		case h.Error != "":
-			call.Error = fmt.Errorf(h.Error)
+			call.Error = &ServerError{Message: h.Error, Code: h.ErrorCode}
+			err = c.cc.ReadBody(nil)
+			c.captured(call)
+			call.done()
+		case c.opt.CheckReplyType && h.ReplyHash != 0 && call.Reply != nil && reflect.TypeOf(call.Reply) != rawBodyType && h.ReplyHash != xxcode.TypeHash(reflect.TypeOf(call.Reply)):
+			call.Error = fmt.Errorf("rpc client: reply type mismatch: %T differs from the server's reply for %s", call.Reply, call.ServiceMethod)
 			err = c.cc.ReadBody(nil)
+			c.captured(call)
 			call.done()
 		default:
 			err = c.cc.ReadBody(call.Reply)
 			if err != nil {
-				call.Error = errors.New("reading body " + err.Error())
+				call.Error = readBodyError(err)
+			} else if call.validator != nil {
+				call.Error = call.validator(call.Reply)
 			}
+			c.captured(call)
 			call.done()
 		}
 	}
-	// 发生错误，终止c.pending中待定的调用
-	c.terminateCalls(err)
+	return err
 }
 
 // 创建 Client 实例时，首先需要完成一开始的协议交换，即发送 Option 信息给服务端。
 // 协商好消息的编解码方式之后，再创建一个子协程调用 receive() 接收响应。
 
 func NewClient(conn net.Conn, opt *common.Option) (*Client, error) {
+	if err := opt.Validate(); err != nil {
+		common.DefaultLogger(opt.Logger).Println("rpc client: options error:", err)
+		return nil, err
+	}
+	cc, typ, err := newCode(conn, opt)
+	if err != nil {
+		return nil, err
+	}
+	client := newClientCode(cc, typ, opt)
+	_, client.tls = conn.(*tls.Conn)
+	return client, nil
+}
+
+// newCode 向服务端发送 Option，返回协商好的编解码器及其类型
+func newCode(conn net.Conn, opt *common.Option) (xxcode.Code, xxcode.Type, error) {
 	// TODO:
-	f := xxcode.NewCodeFuncMap[opt.CodeType]
+	f := xxcode.CodeFunc(opt.CodeType)
 	if f == nil {
 		err := fmt.Errorf("invalid codec type %s", opt.CodeType)
-		log.Println("rpc client: codec error:", err)
-		return nil, err
+		common.DefaultLogger(opt.Logger).Println("rpc client: codec error:", err)
+		return nil, "", err
 	}
 	// send options with server
 	if err := json.NewEncoder(conn).Encode(opt); err != nil {
-		log.Println("rpc client: options error: ", err)
+		common.DefaultLogger(opt.Logger).Println("rpc client: options error: ", err)
 		_ = conn.Close()
+		return nil, "", err
+	}
+	typ, rw := opt.CodeType, io.ReadWriteCloser(conn)
+	if len(opt.AcceptCodecs) > 0 {
+		var err error
+		if typ, rw, err = readHandshakeReply(conn, opt); err != nil {
+			common.DefaultLogger(opt.Logger).Println("rpc client: handshake error:", err)
+			_ = conn.Close()
+			return nil, "", err
+		}
+		f = xxcode.CodeFunc(typ)
+	}
+	base := f(rw)
+	cc, err := xxcode.NewCompressCode(base, typ, opt.Compression)
+	if err != nil {
+		common.DefaultLogger(opt.Logger).Println("rpc client: codec error:", err)
+		_ = conn.Close()
+		xxcode.ReleaseCode(base)
+		return nil, "", err
+	}
+	xxcode.SetReadLimit(cc, opt.MaxResponseBytes)
+	xxcode.SetRawBytes(cc, opt.ProtocolVersion >= common.RawBytesVersion)
+	return cc, typ, nil
+}
+
+// NewClientWithCodec 在已经协商好的编解码器 cc 上创建 Client，不发送 Option 握手，
+// 服务端需要用 Server.ServeCodec 处理同样类型的编解码器。opt 可以为 nil，Reconnect 对这样的 Client 不起作用。
+func NewClientWithCodec(cc xxcode.Code, opt *common.Option) (*Client, error) {
+	opt, err := parseOptions(opt)
+	if err != nil {
 		return nil, err
 	}
-	return newClientCode(f(conn), opt), nil
+	xxcode.SetReadLimit(cc, opt.MaxResponseBytes)
+	xxcode.SetRawBytes(cc, opt.ProtocolVersion >= common.RawBytesVersion)
+	return startClient(cc, opt.CodeType, opt, false), nil
+}
+
+// newClientCode 在 newCode 创建的编解码器上创建 Client
+func newClientCode(cc xxcode.Code, typ xxcode.Type, opt *common.Option) *Client {
+	return startClient(cc, typ, opt, true)
 }
 
-func newClientCode(cc xxcode.Code, opt *common.Option) *Client {
+// startClient 创建 Client 并开始接收响应，ownCode 表示 cc 由 Client 创建，调用方传入的编解码器不归还缓冲
+func startClient(cc xxcode.Code, typ xxcode.Type, opt *common.Option, ownCode bool) *Client {
 	client := &Client{
-		seq:     1, // seq starts with 1, 0 means invalid call
-		cc:      cc,
-		opt:     opt,
-		pending: make(map[uint64]*Call),
+		seq:      1, // seq starts with 1, 0 means invalid call
+		cc:       cc,
+		codeType: typ,
+		ownCode:  ownCode,
+		opt:      opt,
+		pending:  make(map[uint64]*Call),
+		stats:    new(callStats),
 	}
 	go client.receive()
+	if opt.KeepAliveInterval > 0 {
+		go client.keepAlive()
+	}
 	return client
 }
 
@@ -174,12 +395,32 @@ func parseOptions(opts ...*common.Option) (*common.Option, error) {
 	if len(opts) != 1 {
 		return nil, errors.New("number of options is more than 1")
 	}
-	opt := opts[0]
-	opt.MagicNumber = common.DefaultOption.MagicNumber
+	// 复制一份再填充默认值，不修改调用方的 Option
+	opt := *opts[0]
+	if opt.MagicNumber == 0 {
+		opt.MagicNumber = common.DefaultOption.MagicNumber
+	}
 	if opt.CodeType == "" {
 		opt.CodeType = common.DefaultOption.CodeType
 	}
-	return opt, nil
+	if opt.ConnectTimeout == 0 {
+		opt.ConnectTimeout = common.DefaultOption.ConnectTimeout
+	}
+	if opt.HandleTimeout == 0 {
+		opt.HandleTimeout = common.DefaultOption.HandleTimeout
+	}
+	if opt.ProtocolVersion == 0 {
+		opt.ProtocolVersion = common.DefaultOption.ProtocolVersion
+	}
+	return &opt, nil
+}
+
+// connectTimeout 返回拨号和握手的超时时间，0 表示没有限制
+func connectTimeout(opt *common.Option) time.Duration {
+	if opt.ConnectTimeout < 0 {
+		return 0
+	}
+	return opt.ConnectTimeout
 }
 
 // 发送请求
@@ -195,14 +436,23 @@ func (c *Client) send(call *Call) {
 		call.done()
 		return
 	}
+	c.sendRegistered(seqId, call)
+}
 
+// sendRegistered 编码并发送已经注册的 call，调用方需要持有 sending
+func (c *Client) sendRegistered(seqId uint64, call *Call) {
 	// prepare request header
 	c.header.ServiceMethod = call.ServiceMethod
 	c.header.SeqId = seqId
 	c.header.Error = ""
+	c.header.Metadata = call.metadata
+	c.header.Deadline = 0
+	if !call.deadline.IsZero() {
+		c.header.Deadline = call.deadline.UnixNano()
+	}
 
 	// encode and send the request
-	if err := c.cc.Write(&c.header, call.Args); err != nil {
+	if err := c.write(call); err != nil {
 		call := c.removeCall(seqId)
 		// call may be nil, it usually means that Write partially failed,
 		// client has received the response and handled
@@ -210,12 +460,100 @@ func (c *Client) send(call *Call) {
 			call.Error = err
 			call.done()
 		}
+		return
+	}
+	if call.stats != nil {
+		atomic.AddUint64(&call.stats.sent, 1)
+	}
+}
+
+// WithWireCapture 将该调用在连接上实际发送和接收的 header+body 字节交给 f，用于调试单个调用。
+// 编解码器需要实现 xxcode.Capturer，否则该选项不起作用。
+func WithWireCapture(f func(sent, received []byte)) CallOption {
+	return func(call *Call) {
+		call.capture = f
+	}
+}
+
+// write 编码并发送 call 的请求，需要抓取字节时通过 xxcode.Capturer 记录
+func (c *Client) write(call *Call) (err error) {
+	capturer, ok := c.cc.(xxcode.Capturer)
+	if call.capture == nil || !ok {
+		return c.cc.Write(&c.header, call.Args)
+	}
+	capturer.CaptureReads()
+	call.sent, err = capturer.WriteCaptured(&c.header, call.Args)
+	return err
+}
+
+// captured 把 call 抓取到的字节交给 WithWireCapture 的函数，同时交还 write 开启的记录
+func (c *Client) captured(call *Call) {
+	capturer, ok := c.cc.(xxcode.Capturer)
+	if !ok || call.capture == nil {
+		return
 	}
+	// send 在写完请求之前一直持有 sending，加锁保证读取到完整的 call.sent
+	c.sending.Lock()
+	sent := call.sent
+	c.sending.Unlock()
+	call.capture(sent, capturer.Captured())
+}
+
+// sendCancel 发送取消帧，通知服务端取消 seq 对应请求的 context
+func (c *Client) sendCancel(seq uint64) {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	c.mu.Lock()
+	usable := !c.closing && !c.shutdown && !c.reconnecting
+	c.mu.Unlock()
+	if !usable {
+		return // 连接已经断开，服务端的请求随之取消，编解码器的缓冲也可能已经归还
+	}
+	h := xxcode.Header{ServiceMethod: xxcode.CancelServiceMethod, SeqId: seq}
+	if err := c.cc.Write(&h, struct{}{}); err != nil {
+		c.logger().Println("rpc client: send cancel error:", err)
+	}
+}
+
+// Ping 发送 ping 控制帧并等待服务端回复，用于确认连接可用，不经过拦截器
+func (c *Client) Ping(ctx context.Context) error {
+	call := c.Go(xxcode.PingServiceMethod, struct{}{}, nil, make(chan *Call, 1))
+	select {
+	case <-ctx.Done():
+		c.removeCall(call.Seq)
+		return errors.New("rpc client: ping failed: " + ctx.Err().Error())
+	case call := <-call.Done:
+		return call.Error
+	}
+}
+
+// Notify 发送单向请求，不注册 Call 也不等待响应，请求写入连接后即返回。
+// 服务端不会报告方法的错误，调用方无法知道请求是否被处理。
+func (c *Client) Notify(serviceMethod string, args interface{}) error {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	c.mu.Lock()
+	closing, shutdown, reconnecting := c.closing, c.shutdown, c.reconnecting
+	c.mu.Unlock()
+	if closing || shutdown {
+		return ErrShutdown
+	}
+	if reconnecting {
+		return ErrReconnecting
+	}
+	h := xxcode.Header{ServiceMethod: serviceMethod, SeqId: xxcode.OneWaySeqId}
+	return c.cc.Write(&h, args)
 }
 
 // Go 以异步方式调用函数，返回代表调用的Call结构。
 // Go 和 Call 是客户端暴露给用户的两个 RPC 服务调用接口，Go 是一个异步接口，返回 call 实例。
-func (c *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+// Go 不经过 Use 注册的拦截器。
+func (c *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call, opts ...CallOption) *Call {
+	return c.goContext(context.Background(), serviceMethod, args, reply, done, opts...)
+}
+
+// goContext 创建并发送 call，ctx 提供调用的 trace ID，见 startSpan
+func (c *Client) goContext(ctx context.Context, serviceMethod string, args, reply interface{}, done chan *Call, opts ...CallOption) *Call {
 	if done == nil {
 		done = make(chan *Call, 10)
 	} else if cap(done) == 0 {
@@ -226,24 +564,144 @@ func (c *Client) Go(serviceMethod string, args, reply interface{}, done chan *Ca
 		Args:          args,
 		Reply:         reply,
 		Done:          done,
+		logger:        c.opt.Logger,
+	}
+	if serviceMethod != xxcode.PingServiceMethod {
+		call.stats = c.stats
+	}
+	for _, opt := range opts {
+		opt(call)
+	}
+	if serviceMethod != xxcode.PingServiceMethod {
+		c.startSpan(ctx, call)
 	}
 
 	c.send(call)
 	return call
 }
 
+// startSpan 确定 call 的 trace ID 并放入请求的元数据：优先使用元数据中已有的，其次是 ctx 中的，
+// 设置了 Tracer 时生成一个新的。设置了 Tracer 时同时开始 call 的 span。
+func (c *Client) startSpan(ctx context.Context, call *Call) {
+	id := call.metadata[common.TraceIDKey]
+	if id == "" {
+		id = common.TraceID(ctx)
+	}
+	if id == "" && c.opt.Tracer != nil {
+		id = common.NewTraceID()
+	}
+	if id == "" {
+		return
+	}
+	if call.metadata[common.TraceIDKey] != id {
+		// 复制一份，不修改调用方传入的 map
+		md := make(map[string]string, len(call.metadata)+1)
+		for k, v := range call.metadata {
+			md[k] = v
+		}
+		md[common.TraceIDKey] = id
+		call.metadata = md
+	}
+	if c.opt.Tracer != nil {
+		_, call.span = c.opt.Tracer.StartSpan(ctx, call.ServiceMethod, id)
+	}
+}
+
+// GoContext 与 Go 相同，并将 ctx 关联到返回的 Call：ctx 结束时取消该调用并通知服务端，
+// Done 上收到的 Call 的 Error 包装了 ctx.Err()。ctx 的 deadline 与 Call 一样随请求发给服务端。
+// GoContext 同样不经过 Use 注册的拦截器。
+func (c *Client) GoContext(ctx context.Context, serviceMethod string, args, reply interface{}, done chan *Call, opts ...CallOption) *Call {
+	if ctx.Done() == nil {
+		return c.goContext(ctx, serviceMethod, args, reply, done, opts...)
+	}
+	deadline, _ := ctx.Deadline()
+	finished := make(chan struct{})
+	call := c.goContext(ctx, serviceMethod, args, reply, done, append(opts[:len(opts):len(opts)], func(call *Call) {
+		call.deadline = deadline
+		call.finished = finished
+	})...)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// 调用已经结束时 removeCall 返回 nil
+			if c.removeCall(call.Seq) != nil {
+				c.sendCancel(call.Seq)
+				call.Error = fmt.Errorf("rpc client: call failed: %w", ctx.Err())
+				call.done()
+			}
+		case <-finished:
+		}
+	}()
+	return call
+}
+
 // Call 调用命名的函数，等待它完成，并返回其错误状态。
 // Call 是对 Go 的封装，阻塞 call.Done，等待响应返回，是一个同步接口
-func (c *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := c.Go(serviceMethod, args, reply, make(chan *Call, 1))
+// 通过 Use 注册的拦截器会包裹整个调用过程。
+// ctx 带有 deadline 时随请求发给服务端，服务端取它与自身 HandleTimeout 中较早的一个作为处理期限。
+func (c *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	return c.intercept(ctx, serviceMethod, args, reply, func() error {
+		callOpts := opts
+		if deadline, ok := ctx.Deadline(); ok {
+			callOpts = append(opts[:len(opts):len(opts)], func(call *Call) {
+				call.deadline = deadline
+			})
+		}
+		call := c.goContext(ctx, serviceMethod, args, reply, make(chan *Call, 1), callOpts...)
 
-	select {
-	case <-ctx.Done():
-		c.removeCall(call.Seq)
-		return errors.New("rpc client: call failed: " + ctx.Err().Error())
-	case call := <-call.Done:
-		return call.Error
+		select {
+		case <-ctx.Done():
+			if c.removeCall(call.Seq) != nil {
+				c.sendCancel(call.Seq)
+				call.record(ctx.Err())
+			}
+			return errors.New("rpc client: call failed: " + ctx.Err().Error())
+		case call := <-call.Done:
+			return call.Error
+		}
+	})
+}
+
+// CallWithMeta 与 Call 相同，并在请求的 header 中携带元数据 md，
+// 服务端的处理函数通过 server.Metadata(ctx) 读取。
+func (c *Client) CallWithMeta(ctx context.Context, serviceMethod string, args, reply interface{}, md map[string]string, opts ...CallOption) error {
+	return c.Call(ctx, serviceMethod, args, reply, append(opts, func(call *Call) {
+		call.metadata = md
+	})...)
+}
+
+// rawBodyType 是 CallRaw 的 reply 类型，它不解码 body，因此不检查 CheckReplyType
+var rawBodyType = reflect.TypeOf((*xxcode.RawBody)(nil))
+
+// CallRaw 与 Call 相同，但不解码 reply，而是返回响应 body 在编解码器中的原始字节，
+// 用于不知道 reply 类型、只需要转发 body 的代理和网关，字节的格式见 xxcode.RawBody。
+// gob 的 body 不能单独解码，使用 gob 的连接返回 xxcode.ErrRawUnsupported，不发送请求。
+func (c *Client) CallRaw(ctx context.Context, serviceMethod string, args interface{}, opts ...CallOption) ([]byte, error) {
+	if c.ConnInfo().CodeType == xxcode.Type_Gob {
+		return nil, fmt.Errorf("rpc client: call raw: %w", xxcode.ErrRawUnsupported)
+	}
+	var raw xxcode.RawBody
+	if err := c.Call(ctx, serviceMethod, args, &raw, opts...); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// RegisterGobTypes 登记调用在接口类型的参数或 reply 中使用的具体类型，见 xxcode.RegisterGobTypes，
+// 与服务端的 Server.RegisterGobTypes 对应。
+func RegisterGobTypes(values ...interface{}) error {
+	return xxcode.RegisterGobTypes(values...)
+}
+
+// CallTimeout 与 Call 相同，但不需要调用方传入 context：超过 timeout 仍未完成时取消调用并返回超时错误。
+// timeout 与 ctx 的 deadline 一样随请求发给服务端，timeout <= 0 表示不限制。
+func (c *Client) CallTimeout(serviceMethod string, args, reply interface{}, timeout time.Duration, opts ...CallOption) error {
+	if timeout <= 0 {
+		return c.Call(context.Background(), serviceMethod, args, reply, opts...)
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.Call(ctx, serviceMethod, args, reply, opts...)
 }
 
 type clientResult struct {
@@ -254,35 +712,57 @@ type clientResult struct {
 type newClientFunc func(conn net.Conn, opt *common.Option) (client *Client, err error)
 
 func dialTimeout(f newClientFunc, network, address string, opts ...*common.Option) (client *Client, err error) {
+	return dialContext(context.Background(), f, network, address, opts...)
+}
+
+// dialContext 拨号并在连接上调用 f 创建 Client，拨号和握手各自受 ConnectTimeout 限制，ctx 结束时放弃两者
+func dialContext(ctx context.Context, f newClientFunc, network, address string, opts ...*common.Option) (client *Client, err error) {
 	opt, err := parseOptions(opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	// 将 net.Dial 替换为 net.DialTimeout，如果连接创建超时，将返回错误。
-	conn, err := net.DialTimeout(network, address, opt.ConnectTimeout)
+	// 使用带超时的 net.Dialer，如果连接创建超时或 ctx 结束，将返回错误。
+	d := net.Dialer{Timeout: connectTimeout(opt)}
+	conn, err := d.DialContext(ctx, network, address)
 	if err != nil {
 		return nil, err
 	}
+	return newClientContext(ctx, f, conn, opt)
+}
+
+// newClientTimeout 在 conn 上调用 f 创建 Client，超过 ConnectTimeout 时返回错误，失败时关闭 conn
+func newClientTimeout(f newClientFunc, conn net.Conn, opt *common.Option) (client *Client, err error) {
+	return newClientContext(context.Background(), f, conn, opt)
+}
+
+// newClientContext 与 newClientTimeout 相同，ctx 结束时同样放弃握手，返回包装了 ctx.Err() 的错误
+func newClientContext(ctx context.Context, f newClientFunc, conn net.Conn, opt *common.Option) (client *Client, err error) {
+	timeout := connectTimeout(opt)
 	// close the connection if client is nil
 	defer func() {
 		if err != nil {
 			_ = conn.Close()
 		}
 	}()
-	ch := make(chan clientResult)
+	// 放弃握手后关闭 conn 使 f 返回，ch 带缓冲保证它的结果总能写入
+	ch := make(chan clientResult, 1)
 	go func() {
 		client, err := f(conn, opt)
 		ch <- clientResult{client: client, err: err}
 	}()
-	if opt.ConnectTimeout == 0 {
-		result := <-ch
-		return result.client, result.err
+	var timedOut <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timedOut = timer.C
 	}
 	select {
-	// 如果 time.After() 信道先接收到消息，则说明 NewClient 执行超时，返回错误。
-	case <-time.After(opt.ConnectTimeout):
-		return nil, fmt.Errorf("rpc client: connect timeout: expect within %s", opt.ConnectTimeout)
+	// 如果 timedOut 信道先接收到消息，则说明 NewClient 执行超时，返回错误。
+	case <-timedOut:
+		return nil, fmt.Errorf("rpc client: connect timeout: expect within %s", timeout)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("rpc client: connect canceled: %w", ctx.Err())
 	case result := <-ch:
 		return result.client, result.err
 	}
@@ -290,5 +770,69 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*common.Optio
 
 // Dial connects to an RPC server at the specified network address
 func Dial(network, address string, opts ...*common.Option) (*Client, error) {
-	return dialTimeout(NewClient, network, address, opts...)
+	client, err := dialTimeout(NewClient, network, address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	client.enableReconnect(network, address, nil)
+	return client, nil
+}
+
+// DialContext 与 Dial 相同，但 ctx 结束时放弃拨号和 Option 握手，返回的错误包装了 ctx.Err()。
+// ConnectTimeout 仍然分别限制拨号和握手的时间。ctx 只作用于建立连接，不影响之后的调用和重连。
+func DialContext(ctx context.Context, network, address string, opts ...*common.Option) (*Client, error) {
+	client, err := dialContext(ctx, NewClient, network, address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	client.enableReconnect(network, address, nil)
+	return client, nil
+}
+
+// DialPipe 在内存连接 conn 上完成 Option 握手并创建 Client，conn 通常是 Server.ServePipe 返回的一端，
+// 可以不经过网络测试完整的调用过程。ConnectTimeout 限制握手的时间，Reconnect 对这样的 Client 不起作用。
+func DialPipe(conn net.Conn, opts ...*common.Option) (*Client, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return newClientTimeout(NewClient, conn, opt)
+}
+
+// DialTLS 与 Dial 相同，但在 Option 握手之前使用 cfg 在连接上完成 TLS 握手，
+// 之后的 Option 和所有消息都经过加密。ConnectTimeout 同时限制 TLS 握手的时间。
+// cfg 没有设置 ServerName 时使用 address 中的主机名。
+func DialTLS(network, address string, cfg *tls.Config, opts ...*common.Option) (*Client, error) {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" {
+		// 与 tls.Dial 相同，默认使用地址中的主机名校验证书
+		cfg = cfg.Clone()
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			cfg.ServerName = host
+		} else {
+			cfg.ServerName = address
+		}
+	}
+	connect := func(conn net.Conn, _ *common.Option) (net.Conn, error) {
+		tc := tls.Client(conn, cfg)
+		if err := tc.Handshake(); err != nil {
+			return nil, err
+		}
+		return tc, nil
+	}
+	client, err := dialTimeout(func(conn net.Conn, opt *common.Option) (*Client, error) {
+		tc, err := connect(conn, opt)
+		if err != nil {
+			return nil, err
+		}
+		return NewClient(tc, opt)
+	}, network, address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	client.enableReconnect(network, address, connect)
+	return client, nil
 }
@@ -8,29 +8,74 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"sync"
 
 	"xxrpc/common"
 )
 
+// NewHTTPClient 通过 HTTP CONNECT 将 conn 切换为 RPC 协议，再交给 NewClient 完成 Option 握手
 func NewHTTPClient(conn net.Conn, opt *common.Option) (*Client, error) {
-	_, _ = io.WriteString(conn, fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", common.DefaultRPCPath))
+	conn, err := connectHTTP(conn, opt)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, opt)
+}
+
+// connectHTTP 发送 CONNECT 请求，返回切换到 RPC 协议后的连接
+func connectHTTP(conn net.Conn, opt *common.Option) (net.Conn, error) {
+	if _, err := io.WriteString(conn, fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", common.DefaultRPCPath)); err != nil {
+		return nil, err
+	}
+	if opt.PipelineHandshake {
+		// 不等待 CONNECT 的响应，直到第一次读取时才校验
+		return &pipelinedConn{Conn: conn, br: bufio.NewReader(conn)}, nil
+	}
 
 	// Require successful HTTP response
 	// before switching to RPC protocol.
 	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
 	if err == nil && resp.Status == common.Connected {
-		return NewClient(conn, opt)
+		return conn, nil
 	}
 	if err == nil {
-		err = errors.New("unexpected HTTP response: " + resp.Status)
+		err = errors.New("rpc client: unexpected HTTP response: " + resp.Status)
 	}
 	return nil, err
 }
 
+// pipelinedConn 在第一次 Read 时读取并校验 CONNECT 的响应，之后的数据继续从同一个缓冲中读取
+type pipelinedConn struct {
+	net.Conn
+	br   *bufio.Reader
+	once sync.Once
+	err  error
+}
+
+func (c *pipelinedConn) Read(p []byte) (int, error) {
+	c.once.Do(func() {
+		resp, err := http.ReadResponse(c.br, &http.Request{Method: "CONNECT"})
+		if err == nil && resp.Status != common.Connected {
+			err = errors.New("rpc client: unexpected HTTP response: " + resp.Status)
+		}
+		c.err = err
+	})
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(p)
+}
+
 // DialHTTP connects to an HTTP RPC server at the specified network address
 // listening on the default HTTP RPC path.
+// ConnectTimeout bounds both the dial and the CONNECT handshake.
 func DialHTTP(network, address string, opts ...*common.Option) (*Client, error) {
-	return dialTimeout(NewHTTPClient, network, address, opts...)
+	client, err := dialTimeout(NewHTTPClient, network, address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	client.enableReconnect(network, address, connectHTTP)
+	return client, nil
 }
 
 // XDial calls different functions to connect to a RPC server
@@ -38,17 +83,16 @@ func DialHTTP(network, address string, opts ...*common.Option) (*Client, error)
 // rpcAddr is a general format (protocol@addr) to represent a rpc server
 // eg, http@10.0.0.1:7001, tcp@10.0.0.1:9999, unix@/tmp/geerpc.sock
 func XDial(rpcAddr string, opts ...*common.Option) (*Client, error) {
-	parts := strings.Split(rpcAddr, "@")
-	if len(parts) != 2 {
+	protocol, addr, ok := strings.Cut(rpcAddr, "@")
+	if !ok {
 		return nil, fmt.Errorf("rpc client err: wrong format '%s', expect protocol@addr", rpcAddr)
 	}
-	protocol, addr := parts[0], parts[1]
 	switch protocol {
 	case "http":
 		return DialHTTP("tcp", addr, opts...)
+	case "tcp", "tcp4", "tcp6", "unix":
+		return Dial(protocol, addr, opts...)
 	default:
-		// tcp, unix or other transport protocol
-		return DialHTTP(protocol, addr, opts...)
+		return nil, fmt.Errorf("rpc client err: unsupported protocol '%s' in '%s'", protocol, rpcAddr)
 	}
-	return nil, nil
 }
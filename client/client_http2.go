@@ -0,0 +1,101 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http2"
+
+	"xxrpc/common"
+	"xxrpc/xxcode"
+)
+
+// HTTP2Client 通过 cleartext HTTP/2 (h2c) 调用服务端的 Server.HTTP2Handler，
+// 每个调用是一个独立的 POST 流，所有调用复用同一个连接。
+type HTTP2Client struct {
+	url string
+	opt *common.Option
+	tr  *http2.Transport
+	hc  *http.Client
+}
+
+// DialHTTP2 返回一个向 url 发起调用的 HTTP2Client，url 是 HTTP2Handler 所在的路径，
+// 方法名会追加在 url 之后，例如 http://10.0.0.1:7001/_xxrpc_/call/Foo.Sum。
+// 连接在第一次调用时才建立，ConnectTimeout 限制建立连接的时间。
+func DialHTTP2(url string, opts ...*common.Option) (*HTTP2Client, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if opt.CodeType != xxcode.Type_Gob && opt.CodeType != xxcode.Type_Json {
+		return nil, fmt.Errorf("rpc client: invalid codec type %s for http2", opt.CodeType)
+	}
+	if !strings.HasPrefix(url, "http://") {
+		return nil, fmt.Errorf("rpc client err: wrong url '%s', expect http://host/path", url)
+	}
+	tr := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			d := net.Dialer{Timeout: connectTimeout(opt)}
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	return &HTTP2Client{
+		url: strings.TrimSuffix(url, "/") + "/",
+		opt: opt,
+		tr:  tr,
+		hc:  &http.Client{Transport: tr},
+	}, nil
+}
+
+// Call 调用命名的函数，等待它完成，并返回其错误状态。
+func (c *HTTP2Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	var body bytes.Buffer
+	var err error
+	if c.opt.CodeType == xxcode.Type_Json {
+		err = json.NewEncoder(&body).Encode(args)
+	} else {
+		err = gob.NewEncoder(&body).Encode(args)
+	}
+	if err != nil {
+		return fmt.Errorf("rpc client: encoding args: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+serviceMethod, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", string(c.opt.CodeType))
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return errors.New("rpc client: call failed: " + err.Error())
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return &ServerError{Message: strings.TrimSuffix(string(msg), "\n")}
+	}
+	if c.opt.CodeType == xxcode.Type_Json {
+		err = json.NewDecoder(resp.Body).Decode(reply)
+	} else {
+		err = gob.NewDecoder(resp.Body).Decode(reply)
+	}
+	if err != nil {
+		return readBodyError(err)
+	}
+	return nil
+}
+
+// Close 关闭所有空闲的 HTTP/2 连接
+func (c *HTTP2Client) Close() error {
+	c.tr.CloseIdleConnections()
+	return nil
+}
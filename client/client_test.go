@@ -1,15 +1,44 @@
 package client
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"math/rand"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
 	"xxrpc/common"
+	"xxrpc/registry"
 	"xxrpc/server"
+	"xxrpc/xxcode"
 )
 
 type Bar int
@@ -19,19 +48,226 @@ func (b Bar) Timeout(argv int, reply *int) error {
 	return nil
 }
 
+type Reply struct {
+	Name  string
+	Count int
+}
+
+type Baz int
+
+func (b Baz) Fail(argv int, reply *Reply) error {
+	return errors.New("baz failed")
+}
+
+func (b Baz) Echo(argv int, reply *Reply) error {
+	*reply = Reply{Name: "echo", Count: argv}
+	return nil
+}
+
+// Lookup 的方法以返回值的形式给出 reply
+type Lookup int
+
+func (l Lookup) Get(argv int) (Reply, error) {
+	return Reply{Name: "get", Count: argv}, nil
+}
+
+func (l Lookup) Find(ctx context.Context, argv int) (*Reply, error) {
+	if argv < 0 {
+		return nil, errors.New("not found")
+	}
+	return &Reply{Name: "find", Count: argv}, nil
+}
+
+type Nap int
+
+func (n Nap) Take(argv int, reply *int) error {
+	time.Sleep(time.Millisecond * 100)
+	return nil
+}
+
+type Bomb int
+
+func (b Bomb) Explode(argv int, reply *int) error {
+	panic("boom")
+}
+
+type Blob int
+
+func (b Blob) Hold(data []byte, reply *int) error {
+	time.Sleep(time.Millisecond * 300)
+	*reply = len(data)
+	return nil
+}
+
+func (b Blob) Reverse(data []byte, reply *[]byte) error {
+	out := make([]byte, len(data))
+	for i, c := range data {
+		out[len(data)-1-i] = c
+	}
+	*reply = out
+	return nil
+}
+
+func (b Blob) Copy(data []byte) ([]byte, error) {
+	return append([]byte(nil), data...), nil
+}
+
+// Hang 直到 release 被关闭才返回，用于模拟失控的处理函数
+type Hang chan struct{}
+
+func (h Hang) Wait(argv int, reply *int) error {
+	<-h
+	return nil
+}
+
+type Text int
+
+func (t Text) Upper(arg *wrapperspb.StringValue, reply *wrapperspb.StringValue) error {
+	if arg.GetValue() == "" {
+		return errors.New("empty text")
+	}
+	reply.Value = strings.ToUpper(arg.GetValue())
+	return nil
+}
+
+type Peer int
+
+// Addr 是接受 context 的方法，返回服务端看到的客户端地址
+func (p Peer) Addr(ctx context.Context, argv int, reply *string) error {
+	if server.PeerAddr(ctx) == nil {
+		return errors.New("no peer address")
+	}
+	*reply = server.PeerAddr(ctx).String()
+	return nil
+}
+
+// Gate 在进入时通知 entered，并阻塞到 release 可读
+type Gate struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (g *Gate) Wait(argv int, reply *int) error {
+	g.entered <- struct{}{}
+	<-g.release
+	*reply = argv
+	return nil
+}
+
+// Journal 记录收到的数字，处理耗时随机，用于检查处理顺序
+type Journal struct {
+	mu      sync.Mutex
+	entries []int
+}
+
+func (j *Journal) Append(n int, reply *int) error {
+	time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, n)
+	*reply = len(j.entries)
+	return nil
+}
+
+// Slow 一直运行到 ctx 被取消，返回后通知 returned
+type Slow chan time.Time
+
+func (s Slow) Run(ctx context.Context, argv int, reply *int) error {
+	defer func() { s <- time.Now() }()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Second * 5):
+		return nil
+	}
+}
+
 func startServer(addr chan string) {
 	var b Bar
 	_ = server.Register(&b)
 	// pick a free port
-	listener, _ := net.Listen("tcp", ":8888")
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		log.Fatal("network error:", err)
+	}
 	addr <- listener.Addr().String()
-	server.Accept(listener)
+	_ = http.Serve(listener, server.DefaultServer)
+}
+
+// startHTTPServer 在随机端口上启动一个独立的 Server，注册 rcvrs 并通过 HTTP 提供服务，返回监听地址。
+func startHTTPServer(t *testing.T, rcvrs ...interface{}) string {
+	t.Helper()
+	s := server.NewServer()
+	for _, rcvr := range rcvrs {
+		if err := s.Register(rcvr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return serveHTTP(t, s)
 }
 
-// 用于测试连接超时。NewClient 函数耗时 2s，ConnectionTimeout 分别设置为 1s 和 0 两种场景。
+// serveHTTP 在随机端口上通过 HTTP 提供 s 的服务，返回监听地址。
+func serveHTTP(t *testing.T, s *server.Server) string {
+	t.Helper()
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() { _ = http.Serve(l, s) }()
+	return l.Addr().String()
+}
+
+func TestParseOptions(t *testing.T) {
+	badMagic := &common.Option{MagicNumber: 0x123}
+	tests := []struct {
+		name string
+		opts []*common.Option
+		want common.Option
+	}{
+		{"nil", nil, *common.DefaultOption},
+		{"nil option", []*common.Option{nil}, *common.DefaultOption},
+		{"zero fills defaults", []*common.Option{{}}, *common.DefaultOption},
+		{"keeps magic number", []*common.Option{badMagic}, common.Option{
+			MagicNumber: 0x123, CodeType: xxcode.Type_Gob, ConnectTimeout: common.DefaultOption.ConnectTimeout,
+			ProtocolVersion: common.ProtocolVersion,
+		}},
+		{"keeps fields set", []*common.Option{{CodeType: xxcode.Type_Json, ConnectTimeout: time.Second, HandleTimeout: time.Second}}, common.Option{
+			MagicNumber: common.MagicNumber, CodeType: xxcode.Type_Json, ConnectTimeout: time.Second, HandleTimeout: time.Second,
+			ProtocolVersion: common.ProtocolVersion,
+		}},
+		{"no timeout", []*common.Option{{ConnectTimeout: common.NoTimeout, HandleTimeout: common.NoTimeout}}, common.Option{
+			MagicNumber: common.MagicNumber, CodeType: xxcode.Type_Gob, ConnectTimeout: common.NoTimeout, HandleTimeout: common.NoTimeout,
+			ProtocolVersion: common.ProtocolVersion,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOptions(tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Fatalf("expect %+v, got %+v", tt.want, *got)
+			}
+		})
+	}
+	if badMagic.ConnectTimeout != 0 {
+		t.Fatal("expect the caller's option to be left intact")
+	}
+	if _, err := parseOptions(&common.Option{}, &common.Option{}); err == nil {
+		t.Fatal("expect an error for more than one option")
+	}
+}
+
+// 用于测试连接超时。NewClient 函数耗时 2s，ConnectionTimeout 分别设置为 1s 和不限制两种场景。
 func TestClient_dialTimeout(t *testing.T) {
 	t.Parallel()
-	l, _ := net.Listen("tcp", ":8888")
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
 
 	f := func(conn net.Conn, opt *common.Option) (client *Client, err error) {
 		_ = conn.Close()
@@ -40,24 +276,72 @@ func TestClient_dialTimeout(t *testing.T) {
 	}
 	t.Run("timeout", func(t *testing.T) {
 		_, err := dialTimeout(f, "tcp", l.Addr().String(), &common.Option{ConnectTimeout: time.Second})
-		t.Log(err.Error())
+		t.Log(err)
 	})
 	t.Run("0", func(t *testing.T) {
-		_, err := dialTimeout(f, "tcp", l.Addr().String(), &common.Option{ConnectTimeout: 0})
-		t.Log(err.Error())
+		_, err := dialTimeout(f, "tcp", l.Addr().String(), &common.Option{ConnectTimeout: common.NoTimeout})
+		t.Log(err)
 	})
 }
 
+func TestDialContext(t *testing.T) {
+	// 从不 Accept 的 listener 只完成 TCP 握手，f 在服务端回应之前一直阻塞
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	unblocked := make(chan struct{})
+	f := func(conn net.Conn, opt *common.Option) (*Client, error) {
+		_, err := conn.Read(make([]byte, 1))
+		close(unblocked)
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*100, cancel)
+	start := time.Now()
+	_, err = dialContext(ctx, f, "tcp", l.Addr().String(), &common.Option{ConnectTimeout: common.NoTimeout})
+	if !errors.Is(err, context.Canceled) || time.Since(start) > time.Second {
+		t.Fatalf("expect the handshake to be canceled promptly, got %v after %s", err, time.Since(start))
+	}
+	select {
+	case <-unblocked:
+	case <-time.After(time.Second):
+		t.Fatal("expect the connection to be closed after cancellation")
+	}
+	if _, err = DialContext(ctx, "tcp", l.Addr().String()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect a canceled ctx to fail the dial, got %v", err)
+	}
+
+	s := server.NewServer()
+	_ = s.Register(Baz(0))
+	sl, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = sl.Close() }()
+	go s.Accept(sl)
+	client, err := DialContext(context.Background(), "tcp", sl.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	var reply Reply
+	if err = client.Call(context.Background(), "Baz.Echo", 2, &reply); err != nil || reply.Count != 2 {
+		t.Fatalf("expect a working client, got %+v (%v)", reply, err)
+	}
+}
+
 // 测试处理超时。Bar.Timeout 耗时 2s，场景一：客户端设置超时时间为 1s，服务端无限制；场景二，服务端设置超时时间为1s，客户端无限制。
 func TestClient_Call(t *testing.T) {
 	t.Parallel()
 	addrCh := make(chan string)
 	go startServer(addrCh)
 	addr := <-addrCh
-	time.Sleep(time.Second)
 	t.Run("client timeout", func(t *testing.T) {
 		client, _ := DialHTTP("tcp", addr)
-		ctx, _ := context.WithTimeout(context.Background(), time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
 		//ctx, _ := context.WithTimeout(context.Background(), time.Second*3)
 		var reply int
 		err := client.Call(ctx, "Bar.Timeout", 1, &reply)
@@ -69,25 +353,2899 @@ func TestClient_Call(t *testing.T) {
 		})
 		var reply int
 		err := client.Call(context.Background(), "Bar.Timeout", 1, &reply)
-		t.Log(err.Error())
+		if err == nil || !strings.Contains(err.Error(), "handle timeout") {
+			t.Fatalf("expect the server timeout error, got %v", err)
+		}
 	})
 }
 
-func TestXDial(t *testing.T) {
-	if runtime.GOOS == "linux" {
-		ch := make(chan struct{})
-		addr := "/tmp/geerpc.sock"
+func TestClient_CallTimeout(t *testing.T) {
+	t.Parallel()
+	var b Bar
+	client, err := DialHTTP("tcp", startHTTPServer(t, &b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	start := time.Now()
+	err = client.CallTimeout("Bar.Timeout", 1, &reply, time.Millisecond*200)
+	// 服务端收到同样的截止时间，可能先于客户端回复处理超时
+	var serverErr *ServerError
+	timedOut := err != nil && strings.Contains(err.Error(), "deadline exceeded") ||
+		errors.As(err, &serverErr) && serverErr.Code == xxcode.CodeTimeout
+	if !timedOut {
+		t.Fatalf("expect a timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expect the call to give up within the timeout, took %s", elapsed)
+	}
+	if n := numPending(client); n != 0 {
+		t.Fatalf("expect the timed out call to be removed, %d pending", n)
+	}
+}
+
+// 超时的请求在处理函数结束后不应遗留 goroutine
+func TestClient_HandleTimeoutNoLeak(t *testing.T) {
+	client, err := DialHTTP("tcp", startHTTPServer(t, Nap(0)), &common.Option{
+		HandleTimeout: time.Millisecond * 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	base := runtime.NumGoroutine()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
 		go func() {
-			_ = os.Remove(addr)
-			l, err := net.Listen("unix", addr)
-			if err != nil {
-				t.Fatal("failed to listen unix socket")
+			defer wg.Done()
+			var reply int
+			err := client.Call(context.Background(), "Nap.Take", 1, &reply)
+			if err == nil || !strings.Contains(err.Error(), "handle timeout") {
+				t.Errorf("expect the server timeout error, got %v", err)
 			}
-			ch <- struct{}{}
-			server.Accept(l)
 		}()
-		<-ch
-		_, err := XDial("unix@" + addr)
-		t.Log(err.Error())
+	}
+	wg.Wait()
+	deadline := time.Now().Add(time.Second * 2)
+	for runtime.NumGoroutine() > base && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := runtime.NumGoroutine(); n > base {
+		t.Fatalf("expect %d goroutines after the handlers finish, got %d", base, n)
+	}
+}
+
+func TestXDial(t *testing.T) {
+	var b Baz
+	_ = server.Register(&b)
+	call := func(rpcAddr string) {
+		client, err := XDial(rpcAddr)
+		if err != nil {
+			t.Fatalf("XDial(%s): %v", rpcAddr, err)
+		}
+		defer func() { _ = client.Close() }()
+		var reply Reply
+		if err = client.Call(context.Background(), "Baz.Echo", 1, &reply); err != nil || reply.Count != 1 {
+			t.Fatalf("call over %s failed: %+v %v", rpcAddr, reply, err)
+		}
+	}
+	if runtime.GOOS == "linux" {
+		addr := "/tmp/geerpc.sock"
+		_ = os.Remove(addr)
+		l, err := net.Listen("unix", addr)
+		if err != nil {
+			t.Fatal("failed to listen unix socket")
+		}
+		defer func() { _ = l.Close() }()
+		go server.Accept(l)
+		call("unix@" + addr)
+	}
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	call("tcp@" + l.Addr().String())
+	call("http@" + serveHTTP(t, server.DefaultServer))
+
+	// 关闭一个系统分配的端口，得到没有服务在监听的地址
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = closed.Close()
+	for _, rpcAddr := range []string{closed.Addr().String(), "quic@" + closed.Addr().String()} {
+		if _, err = XDial(rpcAddr); err == nil {
+			t.Fatalf("expect an error for %s", rpcAddr)
+		}
+	}
+}
+
+func TestClient_ConnInfo(t *testing.T) {
+	var b Bar
+	addr := startHTTPServer(t, &b)
+	client, err := DialHTTP("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	info := client.ConnInfo()
+	if info.CodeType != xxcode.Type_Gob || info.TLS {
+		t.Fatalf("unexpected conn info: %+v", info)
+	}
+}
+
+// 服务端返回错误时只应送达错误本身，且不能破坏后续请求的帧
+func TestClient_ErrorReply(t *testing.T) {
+	var b Baz
+	client, err := DialHTTP("tcp", startHTTPServer(t, &b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var reply Reply
+	err = client.Call(context.Background(), "Baz.Fail", 1, &reply)
+	if err == nil || err.Error() != "baz failed" {
+		t.Fatalf("expect handler error, got %v", err)
+	}
+	if reply != (Reply{}) {
+		t.Fatalf("reply should be untouched on error, got %+v", reply)
+	}
+	err = client.Call(context.Background(), "Baz.Missing", 1, &reply)
+	if err == nil || err.Error() != "rpc server: can't find method Missing" {
+		t.Fatalf("expect method not found, got %v", err)
+	}
+	if err = client.Call(context.Background(), "Baz.Echo", 3, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply != (Reply{Name: "echo", Count: 3}) {
+		t.Fatalf("unexpected reply %+v", reply)
+	}
+}
+
+func TestClient_MaxInflightBytes(t *testing.T) {
+	var b Blob
+	s := server.NewServer(&common.Option{MaxInflightBytes: 10000})
+	_ = s.Register(&b)
+	client, err := DialHTTP("tcp", serveHTTP(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	data := make([]byte, 4096)
+	calls := []*Call{
+		client.Go("Blob.Hold", data, new(int), nil),
+		client.Go("Blob.Hold", data, new(int), nil),
+	}
+	time.Sleep(time.Millisecond * 100)
+	var reply int
+	err = client.Call(context.Background(), "Blob.Hold", data, &reply)
+	if err == nil || !strings.Contains(err.Error(), "server busy") {
+		t.Fatalf("expect server busy error, got %v", err)
+	}
+	for _, call := range calls {
+		if call = <-call.Done; call.Error != nil {
+			t.Fatal(call.Error)
+		}
+	}
+	if err = client.Call(context.Background(), "Blob.Hold", data, &reply); err != nil || reply != len(data) {
+		t.Fatalf("expect the budget to be released, got %d (%v)", reply, err)
+	}
+}
+
+// 处理超时后处理函数仍在运行，它持有的参数继续占用预算
+func TestClient_MaxInflightBytesAfterTimeout(t *testing.T) {
+	var b Blob
+	s := server.NewServer(&common.Option{MaxInflightBytes: 6000})
+	_ = s.Register(&b)
+	client, err := DialHTTP("tcp", serveHTTP(t, s), &common.Option{HandleTimeout: time.Millisecond * 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	data := make([]byte, 4096)
+	var reply int
+	if err = client.Call(context.Background(), "Blob.Hold", data, &reply); err == nil || !strings.Contains(err.Error(), "handle timeout") {
+		t.Fatalf("expect a handle timeout, got %v", err)
+	}
+	if err = client.Call(context.Background(), "Blob.Hold", data, &reply); err == nil || !strings.Contains(err.Error(), "server busy") {
+		t.Fatalf("expect server busy while the timed out handler runs, got %v", err)
+	}
+	time.Sleep(time.Millisecond * 400)
+	if err = client.Call(context.Background(), "Blob.Hold", data, &reply); err == nil || strings.Contains(err.Error(), "server busy") {
+		t.Fatalf("expect the budget to be released once the handler returns, got %v", err)
+	}
+}
+
+func TestClient_MaxMessageBytes(t *testing.T) {
+	s := server.NewServer(&common.Option{MaxRequestBytes: 1024})
+	_ = s.Register(Blob(0))
+	_ = s.Register(Repeat(0))
+	for _, typ := range []xxcode.Type{xxcode.Type_Gob, xxcode.Type_Json, xxcode.Type_Framed} {
+		client, err := DialPipe(s.ServePipe(), &common.Option{CodeType: typ})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reply int
+		if err = client.Call(context.Background(), "Blob.Hold", make([]byte, 100), &reply); err != nil || reply != 100 {
+			t.Fatalf("%s: expect a request within the limit to succeed, got %d (%v)", typ, reply, err)
+		}
+		err = client.Call(context.Background(), "Blob.Hold", make([]byte, 4096), &reply)
+		if err == nil || !strings.Contains(err.Error(), "message too large") {
+			t.Fatalf("%s: expect message too large, got %v", typ, err)
+		}
+		_ = client.Close()
+
+		// 响应超过 MaxResponseBytes 时调用失败，连接随之断开
+		client, err = DialPipe(s.ServePipe(), &common.Option{CodeType: typ, MaxResponseBytes: 1024})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var strs []string
+		if err = client.Call(context.Background(), "Repeat.Strings", 2, &strs); err != nil || len(strs) != 2 {
+			t.Fatalf("%s: expect a reply within the limit, got %d strings (%v)", typ, len(strs), err)
+		}
+		err = client.Call(context.Background(), "Repeat.Strings", 1000, &strs)
+		if !errors.Is(err, ErrPartialReply) || !strings.Contains(err.Error(), "message too large") {
+			t.Fatalf("%s: expect a partial reply for a response over the limit, got %v", typ, err)
+		}
+		if err = client.Call(context.Background(), "Repeat.Strings", 2, &strs); err == nil {
+			t.Fatalf("%s: expect the connection to be closed", typ)
+		}
+		_ = client.Close()
+	}
+}
+
+// Tracing 返回处理函数 ctx 中的 trace ID
+type Tracing int
+
+func (tr Tracing) ID(ctx context.Context, argv int, reply *string) error {
+	*reply = common.TraceID(ctx)
+	return nil
+}
+
+type finishedSpan struct {
+	name, traceID string
+	err           error
+}
+
+// chanTracer 将结束的 span 发送到 finished
+type chanTracer struct {
+	finished chan finishedSpan
+}
+
+func (tr chanTracer) StartSpan(ctx context.Context, name, traceID string) (context.Context, common.Span) {
+	return ctx, &chanSpan{tr: tr, span: finishedSpan{name: name, traceID: traceID}}
+}
+
+type chanSpan struct {
+	tr   chanTracer
+	span finishedSpan
+}
+
+func (s *chanSpan) Finish(err error) {
+	s.span.err = err
+	s.tr.finished <- s.span
+}
+
+func TestClient_TraceID(t *testing.T) {
+	s := server.NewServer()
+	_ = s.Register(Tracing(0))
+	client, err := DialPipe(s.ServePipe())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id string
+	md := map[string]string{common.TraceIDKey: "from-metadata"}
+	if err = client.CallWithMeta(common.WithTraceID(context.Background(), "from-ctx"), "Tracing.ID", 1, &id, md); err != nil || id != "from-metadata" {
+		t.Fatalf("expect the metadata trace ID to reach the handler, got %q (%v)", id, err)
+	}
+	if err = client.Call(common.WithTraceID(context.Background(), "from-ctx"), "Tracing.ID", 1, &id); err != nil || id != "from-ctx" {
+		t.Fatalf("expect the ctx trace ID to reach the handler, got %q (%v)", id, err)
+	}
+	if err = client.Call(context.Background(), "Tracing.ID", 1, &id); err != nil || id != "" {
+		t.Fatalf("expect no trace ID without a tracer, got %q (%v)", id, err)
+	}
+	_ = client.Close()
+
+	clientSpans, serverSpans := chanTracer{make(chan finishedSpan, 10)}, chanTracer{make(chan finishedSpan, 10)}
+	s = server.NewServer(&common.Option{Tracer: serverSpans})
+	_ = s.Register(Tracing(0))
+	client, err = DialPipe(s.ServePipe(), &common.Option{Tracer: clientSpans})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	if err = client.Call(context.Background(), "Tracing.ID", 1, &id); err != nil || len(id) != 32 {
+		t.Fatalf("expect a generated trace ID, got %q (%v)", id, err)
+	}
+	for _, spans := range []chanTracer{clientSpans, serverSpans} {
+		if span := <-spans.finished; span != (finishedSpan{name: "Tracing.ID", traceID: id}) {
+			t.Fatalf("expect a span of Tracing.ID with trace ID %s, got %+v", id, span)
+		}
+	}
+	if err = client.Call(context.Background(), "Tracing.Missing", 1, &id); err == nil {
+		t.Fatal("expect an error for a missing method")
+	}
+	if span := <-clientSpans.finished; span.name != "Tracing.Missing" || span.err == nil {
+		t.Fatalf("expect the client span to finish with the call's error, got %+v", span)
+	}
+}
+
+func TestClient_WithReplyValidator(t *testing.T) {
+	var b Baz
+	client, err := DialHTTP("tcp", startHTTPServer(t, &b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	inRange := WithReplyValidator(func(reply interface{}) error {
+		if r := reply.(*Reply); r.Count > 10 {
+			return fmt.Errorf("count %d out of range", r.Count)
+		}
+		return nil
+	})
+	var reply Reply
+	if err = client.Call(context.Background(), "Baz.Echo", 5, &reply, inRange); err != nil {
+		t.Fatal(err)
+	}
+	err = client.Call(context.Background(), "Baz.Echo", 42, &reply, inRange)
+	if err == nil || err.Error() != "count 42 out of range" {
+		t.Fatalf("expect validation error, got %v", err)
+	}
+}
+
+func TestClient_ErrorRedactor(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	var b Baz
+	s := server.NewServer(&common.Option{
+		ErrorRedactor: func(err error) string { return "internal error" },
+	})
+	_ = s.Register(&b)
+	client, err := DialHTTP("tcp", serveHTTP(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var reply Reply
+	err = client.Call(context.Background(), "Baz.Fail", 1, &reply)
+	if err == nil || err.Error() != "internal error" {
+		t.Fatalf("expect redacted error, got %v", err)
+	}
+	if !strings.Contains(buf.String(), "Baz.Fail error: baz failed") {
+		t.Fatalf("full error not logged: %q", buf.String())
+	}
+}
+
+func TestClient_WithWireCapture(t *testing.T) {
+	var b Baz
+	client, err := DialHTTP("tcp", startHTTPServer(t, &b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var sent, received []byte
+	capture := WithWireCapture(func(s, r []byte) { sent, received = s, r })
+	var reply Reply
+	if err = client.Call(context.Background(), "Baz.Echo", 7, &reply, capture); err != nil {
+		t.Fatal(err)
+	}
+
+	var h xxcode.Header
+	var argv int
+	dec := gob.NewDecoder(bytes.NewReader(sent))
+	if err = dec.Decode(&h); err != nil || dec.Decode(&argv) != nil {
+		t.Fatalf("failed to decode sent bytes: %v", err)
+	}
+	if h.ServiceMethod != "Baz.Echo" || argv != 7 {
+		t.Fatalf("unexpected request %+v %d", h, argv)
+	}
+	var got Reply
+	dec = gob.NewDecoder(bytes.NewReader(received))
+	if err = dec.Decode(&h); err != nil || dec.Decode(&got) != nil {
+		t.Fatalf("failed to decode received bytes: %v", err)
+	}
+	if h.ServiceMethod != "Baz.Echo" || got != reply {
+		t.Fatalf("unexpected response %+v %+v", h, got)
+	}
+
+	// 之后的调用不应改写已经交出的字节
+	kept := append([]byte(nil), received...)
+	if err = client.Call(context.Background(), "Baz.Echo", 8, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(kept, received) {
+		t.Fatal("captured bytes changed by a later call")
+	}
+}
+
+func TestServer_OnRunaway(t *testing.T) {
+	h := make(Hang)
+	defer close(h)
+	reported := make(chan string, 1)
+	s := server.NewServer(&common.Option{
+		RunawayThreshold: time.Millisecond * 300,
+		OnRunaway: func(serviceMethod string, seq uint64, elapsed time.Duration) {
+			reported <- fmt.Sprintf("%s#%d", serviceMethod, seq)
+		},
+	})
+	_ = s.Register(h)
+	client, err := DialHTTP("tcp", serveHTTP(t, s), &common.Option{HandleTimeout: time.Millisecond * 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	if err = client.Call(context.Background(), "Hang.Wait", 1, &reply); err == nil {
+		t.Fatal("expect handle timeout error")
+	}
+	select {
+	case got := <-reported:
+		if got != "Hang.Wait#1" {
+			t.Fatalf("unexpected runaway report %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("runaway handler not reported")
+	}
+}
+
+// turnConn 统计客户端在发送前必须等待服务端数据的次数，即往返次数
+type turnConn struct {
+	net.Conn
+	mu       sync.Mutex
+	readData bool // 上一次写之后读到了数据
+	turns    int
+}
+
+func (c *turnConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	c.readData = c.readData || n > 0
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *turnConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	if c.readData {
+		c.turns++
+		c.readData = false
+	}
+	c.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+// slowListener 延迟每个连接的第一次写，模拟链路延迟
+type slowListener struct{ net.Listener }
+
+type slowConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (l slowListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &slowConn{Conn: conn}, nil
+}
+
+func (c *slowConn) Write(p []byte) (int, error) {
+	c.once.Do(func() { time.Sleep(time.Millisecond * 100) })
+	return c.Conn.Write(p)
+}
+
+func TestClient_PipelineHandshake(t *testing.T) {
+	var b Baz
+	s := server.NewServer()
+	_ = s.Register(&b)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go func() { _ = http.Serve(slowListener{l}, s) }()
+
+	turns := func(pipeline bool) int {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc := &turnConn{Conn: conn}
+		client, err := NewHTTPClient(tc, &common.Option{
+			MagicNumber:       common.MagicNumber,
+			CodeType:          xxcode.Type_Gob,
+			PipelineHandshake: pipeline,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = client.Close() }()
+		var reply Reply
+		if err = client.Call(context.Background(), "Baz.Echo", 1, &reply); err != nil || reply.Count != 1 {
+			t.Fatalf("call failed: %+v %v", reply, err)
+		}
+		tc.mu.Lock()
+		defer tc.mu.Unlock()
+		return tc.turns
+	}
+	plain, pipelined := turns(false), turns(true)
+	if pipelined != plain-1 {
+		t.Fatalf("expect one fewer round trip, got %d with pipelining and %d without", pipelined, plain)
+	}
+}
+
+func TestClient_CheckReplyType(t *testing.T) {
+	var b Baz
+	addr := startHTTPServer(t, &b)
+	client, err := DialHTTP("tcp", addr, &common.Option{CheckReplyType: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var reply Reply
+	if err = client.Call(context.Background(), "Baz.Echo", 2, &reply); err != nil {
+		t.Fatal(err)
+	}
+	// 字段名与服务端的 Reply 不一致，gob 会静默丢弃 Count
+	var skewed struct {
+		Name  string
+		Total int
+	}
+	err = client.Call(context.Background(), "Baz.Echo", 2, &skewed)
+	if err == nil || !strings.Contains(err.Error(), "reply type mismatch") {
+		t.Fatalf("expect reply type mismatch, got %v", err)
+	}
+	if err = client.Call(context.Background(), "Baz.Echo", 3, &reply); err != nil || reply.Count != 3 {
+		t.Fatalf("connection should stay usable, got %+v %v", reply, err)
+	}
+	// nil reply 丢弃响应，不参与类型检查
+	if err = client.Call(context.Background(), "Baz.Echo", 4, nil); err != nil {
+		t.Fatalf("expect a nil reply to be discarded, got %v", err)
+	}
+}
+
+func TestClient_JsonCode(t *testing.T) {
+	var b Baz
+	client, err := DialHTTP("tcp", startHTTPServer(t, &b), &common.Option{CodeType: xxcode.Type_Json})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var reply Reply
+	if err = client.Call(context.Background(), "Baz.Fail", 1, &reply); err == nil || err.Error() != "baz failed" {
+		t.Fatalf("expect handler error, got %v", err)
+	}
+	if err = client.Call(context.Background(), "Baz.Echo", 4, &reply); err != nil || reply != (Reply{Name: "echo", Count: 4}) {
+		t.Fatalf("unexpected reply %+v (%v)", reply, err)
+	}
+}
+
+func TestClient_ProtoCode(t *testing.T) {
+	var text Text
+	client, err := DialHTTP("tcp", startHTTPServer(t, &text), &common.Option{CodeType: xxcode.Type_Proto})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	reply := &wrapperspb.StringValue{}
+	if err = client.Call(context.Background(), "Text.Upper", wrapperspb.String(""), reply); err == nil || err.Error() != "empty text" {
+		t.Fatalf("expect handler error, got %v", err)
+	}
+	if err = client.Call(context.Background(), "Text.Upper", wrapperspb.String("xxrpc"), reply); err != nil || reply.GetValue() != "XXRPC" {
+		t.Fatalf("unexpected reply %q (%v)", reply.GetValue(), err)
+	}
+	var notProto int
+	if err = client.Call(context.Background(), "Text.Upper", 1, &notProto); err == nil || !strings.Contains(err.Error(), "does not implement proto.Message") {
+		t.Fatalf("expect codec error, got %v", err)
+	}
+}
+
+func TestClient_ContextMethod(t *testing.T) {
+	var p Peer
+	var b Baz
+	client, err := DialHTTP("tcp", startHTTPServer(t, &p, &b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var addr string
+	if err = client.Call(context.Background(), "Peer.Addr", 1, &addr); err != nil {
+		t.Fatal(err)
+	}
+	if addr == "" {
+		t.Fatal("expect the peer address")
+	}
+	var reply Reply
+	if err = client.Call(context.Background(), "Baz.Echo", 1, &reply); err != nil || reply.Count != 1 {
+		t.Fatalf("unexpected reply %+v (%v)", reply, err)
+	}
+}
+
+// 服务端写完响应 header 后断开连接
+func TestClient_PartialReply(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		var opt common.Option
+		dec := json.NewDecoder(conn)
+		if dec.Decode(&opt) != nil {
+			return
+		}
+		br := bufio.NewReader(io.MultiReader(dec.Buffered(), conn))
+		_, _ = br.ReadByte() // json.Encoder 写入的换行符
+		var h xxcode.Header
+		var argv int
+		gd := gob.NewDecoder(br)
+		if gd.Decode(&h) != nil || gd.Decode(&argv) != nil {
+			return
+		}
+		_ = gob.NewEncoder(conn).Encode(&h)
+	}()
+
+	client, err := Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	var reply Reply
+	err = client.Call(context.Background(), "Baz.Echo", 1, &reply)
+	if !errors.Is(err, ErrPartialReply) {
+		t.Fatalf("expect ErrPartialReply, got %v", err)
+	}
+}
+
+// 完整收到但无法解码的 body 不是 ErrPartialReply
+func TestClient_ReadBodyError(t *testing.T) {
+	var b Baz
+	client, err := DialHTTP("tcp", startHTTPServer(t, &b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	var reply string
+	err = client.Call(context.Background(), "Baz.Echo", 1, &reply)
+	if err == nil || errors.Is(err, ErrPartialReply) || !strings.Contains(err.Error(), "reading body") {
+		t.Fatalf("expect a decode error that isn't a partial reply, got %v", err)
+	}
+}
+
+func TestDialHTTP(t *testing.T) {
+	t.Run("not rpc", func(t *testing.T) {
+		l, err := net.Listen("tcp", ":0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = l.Close() }()
+		go func() { _ = http.Serve(l, http.NotFoundHandler()) }()
+		_, err = DialHTTP("tcp", l.Addr().String())
+		if err == nil || !strings.Contains(err.Error(), "unexpected HTTP response") {
+			t.Fatalf("expect unexpected HTTP response error, got %v", err)
+		}
+	})
+	t.Run("handshake timeout", func(t *testing.T) {
+		l, err := net.Listen("tcp", ":0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = l.Close() }()
+		// 接受连接但从不响应 CONNECT
+		_, err = DialHTTP("tcp", l.Addr().String(), &common.Option{ConnectTimeout: time.Millisecond * 200})
+		if err == nil || !strings.Contains(err.Error(), "connect timeout") {
+			t.Fatalf("expect connect timeout, got %v", err)
+		}
+	})
+}
+
+// 服务端首选 JSON、回退到 gob，同时兼容声明 JSON 但仍发送 gob 的旧客户端
+func TestServer_FallbackCodecs(t *testing.T) {
+	var b Baz
+	s := server.NewServer(&common.Option{FallbackCodecs: []xxcode.Type{xxcode.Type_Gob}})
+	_ = s.Register(&b)
+	addr := serveHTTP(t, s)
+	opt := &common.Option{MagicNumber: common.MagicNumber, CodeType: xxcode.Type_Json}
+
+	jsonClient, err := DialHTTP("tcp", addr, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = jsonClient.Close() }()
+
+	legacy, err := dialTimeout(func(conn net.Conn, opt *common.Option) (*Client, error) {
+		if _, err := io.WriteString(conn, fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", common.DefaultRPCPath)); err != nil {
+			return nil, err
+		}
+		if _, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"}); err != nil {
+			return nil, err
+		}
+		if err := json.NewEncoder(conn).Encode(opt); err != nil {
+			return nil, err
+		}
+		return newClientCode(xxcode.NewGobCode(conn), xxcode.Type_Gob, opt), nil
+	}, "tcp", addr, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = legacy.Close() }()
+
+	for _, client := range []*Client{jsonClient, legacy} {
+		for i := 1; i <= 3; i++ {
+			var reply Reply
+			if err = client.Call(context.Background(), "Baz.Echo", i, &reply); err != nil || reply.Count != i {
+				t.Fatalf("unexpected reply %+v (%v)", reply, err)
+			}
+		}
+	}
+}
+
+func TestDialHTTP2(t *testing.T) {
+	var b Baz
+	s := server.NewServer()
+	_ = s.Register(&b)
+	// h2c 连接以 "PRI * HTTP/2.0" 前言开始，之后的流不再经过外层 handler
+	protos := make(chan int, 10)
+	h := s.HTTP2Handler()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protos <- r.ProtoMajor
+		h.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	for _, codeType := range []xxcode.Type{xxcode.Type_Gob, xxcode.Type_Json} {
+		client, err := DialHTTP2(ts.URL+common.DefaultUnaryPath, &common.Option{CodeType: codeType})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reply Reply
+		if err = client.Call(context.Background(), "Baz.Echo", 5, &reply); err != nil || reply.Count != 5 {
+			t.Fatalf("unexpected reply %+v (%v)", reply, err)
+		}
+		if err = client.Call(context.Background(), "Baz.Fail", 5, &reply); err == nil || err.Error() != "baz failed" {
+			t.Fatalf("expect handler error, got %v", err)
+		}
+		_ = client.Close()
+		if p := <-protos; p != 2 || len(protos) != 0 {
+			t.Fatalf("expect both calls on one HTTP/2 connection, got HTTP/%d and %d more requests", p, len(protos))
+		}
+	}
+}
+
+func TestDialHTTP2_MaxConcurrentStreams(t *testing.T) {
+	g := &Gate{entered: make(chan struct{}), release: make(chan struct{})}
+	s := server.NewServer(&common.Option{MaxConcurrentStreams: 2})
+	_ = s.Register(g)
+	ts := httptest.NewServer(s.HTTP2Handler())
+	defer ts.Close()
+	client, err := DialHTTP2(ts.URL + common.DefaultUnaryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	done := make(chan error, 3)
+	wait := func(i int) {
+		var reply int
+		done <- client.Call(context.Background(), "Gate.Wait", i, &reply)
+	}
+	go wait(1)
+	go wait(2)
+	<-g.entered
+	<-g.entered
+	var reply int
+	if err = client.Call(context.Background(), "Gate.Wait", 3, &reply); err == nil || err.Error() != "rpc server: too many streams" {
+		t.Fatalf("expect too many streams, got %v", err)
+	}
+
+	// 结束一个流后腾出一个位置
+	g.release <- struct{}{}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	go wait(4)
+	<-g.entered
+	close(g.release)
+	for i := 0; i < 2; i++ {
+		if err = <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestClient_terminateCalls(t *testing.T) {
+	h := make(Hang)
+	defer close(h)
+	client, err := DialHTTP("tcp", startHTTPServer(t, h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan *Call, 10)
+	for i := 0; i < 5; i++ {
+		client.Go("Hang.Wait", i, new(int), done)
+	}
+	// 让 receive 读取失败，再手动终止一次，验证每个 call 只收到一次错误
+	_ = client.cc.Close()
+	for i := 0; i < 5; i++ {
+		select {
+		case call := <-done:
+			if call.Error == nil {
+				t.Fatal("expect an error")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("outstanding call not terminated")
+		}
+	}
+	client.terminateCalls(errors.New("terminated twice"))
+	select {
+	case call := <-done:
+		t.Fatalf("call %d delivered twice: %v", call.Seq, call.Error)
+	case <-time.After(time.Millisecond * 100):
+	}
+	if client.IsAvailable() || numPending(client) != 0 {
+		t.Fatal("client should be shut down with no pending calls")
+	}
+}
+
+func numPending(c *Client) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+func TestClient_OrderedProcessing(t *testing.T) {
+	j := &Journal{}
+	client, err := DialHTTP("tcp", startHTTPServer(t, j), &common.Option{OrderedProcessing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	done := make(chan *Call, 20)
+	for i := 0; i < 20; i++ {
+		client.Go("Journal.Append", i, new(int), done)
+	}
+	for i := 0; i < 20; i++ {
+		if call := <-done; call.Error != nil {
+			t.Fatal(call.Error)
+		}
+	}
+	for i, n := range j.entries {
+		if n != i {
+			t.Fatalf("requests applied out of order: %v", j.entries)
+		}
+	}
+}
+
+func TestClient_CancelPropagates(t *testing.T) {
+	s := make(Slow, 1)
+	client, err := DialHTTP("tcp", startHTTPServer(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	var reply int
+	start := time.Now()
+	if err = client.Call(ctx, "Slow.Run", 1, &reply); err == nil {
+		t.Fatal("expect a context error")
+	}
+	select {
+	case returned := <-s:
+		if returned.Sub(start) > time.Second {
+			t.Fatalf("handler returned after %s", returned.Sub(start))
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("remote handler was not cancelled")
+	}
+	// 取消帧不影响同一连接上的后续调用
+	if err = client.Call(context.Background(), "Slow.Missing", 1, &reply); err == nil || !strings.Contains(err.Error(), "can't find method") {
+		t.Fatalf("expect method not found, got %v", err)
+	}
+}
+
+func TestClient_GoContext(t *testing.T) {
+	s := make(Slow, 1)
+	client, err := DialHTTP("tcp", startHTTPServer(t, s, Baz(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	call := client.GoContext(ctx, "Slow.Run", 1, new(int), nil)
+	time.AfterFunc(time.Millisecond*50, cancel)
+	select {
+	case call := <-call.Done:
+		if !errors.Is(call.Error, context.Canceled) {
+			t.Fatalf("expect context.Canceled, got %v", call.Error)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the call to be cancelled")
+	}
+	select {
+	case <-s:
+	case <-time.After(time.Second * 2):
+		t.Fatal("remote handler was not cancelled")
+	}
+	if n := client.NumPending(); n != 0 {
+		t.Fatalf("expect no pending calls, got %d", n)
+	}
+
+	// 调用结束后再取消 ctx 不会再次通知 Done
+	ctx, cancel = context.WithCancel(context.Background())
+	var reply Reply
+	call = client.GoContext(ctx, "Baz.Echo", 3, &reply, nil)
+	if call = <-call.Done; call.Error != nil || reply.Count != 3 {
+		t.Fatalf("expect the call to succeed, got %+v (%v)", reply, call.Error)
+	}
+	cancel()
+	select {
+	case call := <-call.Done:
+		t.Fatalf("expect a single notification, got another with %v", call.Error)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func TestClient_BatchCall(t *testing.T) {
+	s := server.NewServer()
+	_ = s.Register(Baz(0))
+	_ = s.Register(Nap(0))
+	client, err := DialPipe(s.ServePipe())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	replies := make([]Reply, 4)
+	results := client.BatchCall(context.Background(), []BatchRequest{
+		{"Baz.Echo", 1, &replies[0]},
+		{"Baz.Fail", 2, &replies[1]},
+		{"Baz.Missing", 3, &replies[2]},
+		{"Baz.Echo", 4, &replies[3]},
+	})
+	if results[0].Error != nil || replies[0].Count != 1 || results[3].Error != nil || replies[3].Count != 4 {
+		t.Fatalf("expect the echo calls to succeed, got %+v %+v", results, replies)
+	}
+	if results[1].Error == nil || results[1].Error.Error() != "baz failed" {
+		t.Fatalf("expect the handler's error, got %v", results[1].Error)
+	}
+	if results[2].Error == nil || !strings.Contains(results[2].Error.Error(), "can't find method") {
+		t.Fatalf("expect method not found, got %v", results[2].Error)
+	}
+
+	// ctx 结束时只有未完成的调用被取消
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*30, cancel)
+	var reply Reply
+	results = client.BatchCall(ctx, []BatchRequest{
+		{"Baz.Echo", 5, &reply},
+		{"Nap.Take", 6, new(int)},
+	})
+	if results[0].Error != nil || reply.Count != 5 {
+		t.Fatalf("expect the fast call to succeed, got %+v (%v)", reply, results[0].Error)
+	}
+	if !errors.Is(results[1].Error, context.Canceled) {
+		t.Fatalf("expect the slow call to be cancelled, got %v", results[1].Error)
+	}
+	if n := client.NumPending(); n != 0 {
+		t.Fatalf("expect no pending calls, got %d", n)
+	}
+}
+
+func TestClient_Use(t *testing.T) {
+	client, err := DialHTTP("tcp", startHTTPServer(t, Baz(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var order []string
+	denied := errors.New("denied")
+	client.Use(
+		func(ctx context.Context, method string, args, reply interface{}, invoker func() error) error {
+			order = append(order, "outer:"+method)
+			err := invoker()
+			order = append(order, "outer done")
+			return err
+		},
+		func(ctx context.Context, method string, args, reply interface{}, invoker func() error) error {
+			order = append(order, "inner:"+method)
+			if method == "Baz.Missing" {
+				return denied
+			}
+			return invoker()
+		},
+	)
+
+	var reply Reply
+	if err = client.Call(context.Background(), "Baz.Echo", 3, &reply); err != nil || reply.Count != 3 {
+		t.Fatalf("expect echo 3, got %+v, %v", reply, err)
+	}
+	// 被拦截的调用不会发送请求，否则会得到 can't find method 错误
+	if err = client.Call(context.Background(), "Baz.Missing", 3, &reply); err != denied {
+		t.Fatalf("expect short-circuit error, got %v", err)
+	}
+	want := []string{"outer:Baz.Echo", "inner:Baz.Echo", "outer done", "outer:Baz.Missing", "inner:Baz.Missing", "outer done"}
+	if !reflect.DeepEqual(order, want) {
+		t.Fatalf("expect order %v, got %v", want, order)
+	}
+}
+
+func TestClient_UseModifyArgs(t *testing.T) {
+	client, err := DialHTTP("tcp", startHTTPServer(t, Baz(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var order []string
+	client.Use(func(ctx context.Context, method string, args, reply interface{}, invoker func() error) error {
+		order = append(order, "before")
+		*args.(*int) *= 2
+		err := invoker()
+		order = append(order, fmt.Sprintf("after %d", reply.(*Reply).Count))
+		return err
+	})
+
+	n := 3
+	var reply Reply
+	if err = client.Call(context.Background(), "Baz.Echo", &n, &reply); err != nil || reply.Count != 6 {
+		t.Fatalf("expect the modified args to be sent, got %+v, %v", reply, err)
+	}
+	if want := []string{"before", "after 6"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("expect order %v, got %v", want, order)
+	}
+
+	// Go 不经过拦截器
+	call := <-client.Go("Baz.Echo", 3, &reply, nil).Done
+	if call.Error != nil || reply.Count != 3 || len(order) != 2 {
+		t.Fatalf("expect Go to bypass interceptors, got %+v, %v, %v", reply, call.Error, order)
+	}
+}
+
+func TestClient_RecoverPanics(t *testing.T) {
+	client, err := DialHTTP("tcp", startHTTPServer(t, Bomb(0), Baz(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var n int
+	err = client.Call(context.Background(), "Bomb.Explode", 1, &n)
+	if err == nil || !strings.Contains(err.Error(), "Bomb.Explode panic: boom") {
+		t.Fatalf("expect the panic as an error, got %v", err)
+	}
+	// 连接仍然可用
+	var reply Reply
+	if err = client.Call(context.Background(), "Baz.Echo", 2, &reply); err != nil || reply.Count != 2 {
+		t.Fatalf("expect echo 2 after the panic, got %+v, %v", reply, err)
+	}
+}
+
+func TestPool(t *testing.T) {
+	rpcAddr := "http@" + startHTTPServer(t, Baz(0))
+	base := runtime.NumGoroutine()
+	pool := NewPool(1)
+
+	c1, err := pool.Get(rpcAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := pool.Get(rpcAddr)
+	if err != nil || c2 == c1 {
+		t.Fatalf("expect a second connection, got %v", err)
+	}
+	pool.Put(rpcAddr, c1)
+	pool.Put(rpcAddr, c2) // 超过 maxIdle，被关闭
+	if c2.IsAvailable() {
+		t.Fatal("expect the client beyond maxIdle to be closed")
+	}
+	if c, _ := pool.Get(rpcAddr); c != c1 {
+		t.Fatal("expect the idle client to be reused")
+	}
+	var reply Reply
+	if err = c1.Call(context.Background(), "Baz.Echo", 1, &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	// 已断开的空闲 Client 被丢弃并重新拨号
+	pool.Put(rpcAddr, c1)
+	_ = c1.Close()
+	c3, err := pool.Get(rpcAddr)
+	if err != nil || c3 == c1 {
+		t.Fatalf("expect a fresh connection, got %v", err)
+	}
+	if err = c3.Call(context.Background(), "Baz.Echo", 1, &reply); err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(rpcAddr, c3)
+
+	_ = pool.Close()
+	if c3.IsAvailable() {
+		t.Fatal("expect Close to close idle clients")
+	}
+	if _, err = pool.Get(rpcAddr); err != ErrShutdown {
+		t.Fatalf("expect ErrShutdown after Close, got %v", err)
+	}
+	deadline := time.Now().Add(time.Second * 2)
+	for runtime.NumGoroutine() > base && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := runtime.NumGoroutine(); n > base {
+		t.Fatalf("expect %d goroutines after Close, got %d", base, n)
+	}
+}
+
+func TestClient_Ping(t *testing.T) {
+	addr := startHTTPServer(t, Baz(0))
+	for _, codeType := range []xxcode.Type{xxcode.Type_Gob, xxcode.Type_Json, xxcode.Type_Proto} {
+		client, err := DialHTTP("tcp", addr, &common.Option{CodeType: codeType})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = client.Ping(context.Background()); err != nil {
+			t.Fatalf("ping over %s: %v", codeType, err)
+		}
+		_ = client.Close()
+	}
+}
+
+// blackholeServer 接受连接并读取所有数据，但从不回复，模拟已经失效的对端
+func blackholeServer(t *testing.T) string {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _, _ = io.Copy(io.Discard, conn) }()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// 大量小消息连续写入时，读端的缓冲会一次读入多条消息，请求紧跟在 Option 之后也不能丢失
+func TestClient_ManySmallCalls(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	s := server.NewServer()
+	_ = s.Register(Baz(0))
+	go s.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	done := make(chan *Call, 500)
+	for i := 0; i < 500; i++ {
+		client.Go("Baz.Echo", i, new(Reply), done)
+	}
+	for i := 0; i < 500; i++ {
+		call := <-done
+		if call.Error != nil || call.Reply.(*Reply).Count != call.Args.(int) {
+			t.Fatalf("expect echo %v, got %+v, %v", call.Args, call.Reply, call.Error)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		var reply Reply
+		if err = client.Call(context.Background(), "Baz.Echo", i, &reply); err != nil || reply.Count != i {
+			t.Fatalf("expect echo %d, got %+v, %v", i, reply, err)
+		}
+	}
+}
+
+// Repeat 返回 argv 个相同的字符串，用于产生较大的响应
+type Repeat int
+
+func (r Repeat) Strings(argv int, reply *[]string) error {
+	for i := 0; i < argv; i++ {
+		*reply = append(*reply, "repeated payload")
+	}
+	return nil
+}
+
+// Shapes 返回 slice、map 和指针形式的 reply
+type Shapes int
+
+type Item struct {
+	Name string
+	Tags []string
+}
+
+func (s Shapes) Strings(n int, reply *[]string) error {
+	for i := 0; i < n; i++ {
+		*reply = append(*reply, strconv.Itoa(i))
+	}
+	return nil
+}
+
+func (s Shapes) Counts(n int, reply *map[string]int) error {
+	for i := 0; i < n; i++ {
+		(*reply)[strconv.Itoa(i)] = i
+	}
+	return nil
+}
+
+func (s Shapes) Items(n int, reply *[]*Item) error {
+	for i := 0; i < n; i++ {
+		*reply = append(*reply, &Item{Name: strconv.Itoa(i), Tags: []string{"t"}})
+	}
+	return nil
+}
+
+func (s Shapes) Item(n int, reply **Item) error {
+	(*reply).Name = strconv.Itoa(n)
+	return nil
+}
+
+func TestClient_CompositeReplies(t *testing.T) {
+	addr := startHTTPServer(t, Shapes(0))
+	for _, typ := range []xxcode.Type{xxcode.Type_Gob, xxcode.Type_Json} {
+		client, err := DialHTTP("tcp", addr, &common.Option{CodeType: typ})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var strs []string
+		if err = client.Call(context.Background(), "Shapes.Strings", 2, &strs); err != nil || !reflect.DeepEqual(strs, []string{"0", "1"}) {
+			t.Fatalf("%s: expect a populated slice, got %v (%v)", typ, strs, err)
+		}
+		var counts map[string]int
+		if err = client.Call(context.Background(), "Shapes.Counts", 2, &counts); err != nil || !reflect.DeepEqual(counts, map[string]int{"0": 0, "1": 1}) {
+			t.Fatalf("%s: expect a populated map, got %v (%v)", typ, counts, err)
+		}
+		var items []*Item
+		if err = client.Call(context.Background(), "Shapes.Items", 2, &items); err != nil ||
+			!reflect.DeepEqual(items, []*Item{{Name: "0", Tags: []string{"t"}}, {Name: "1", Tags: []string{"t"}}}) {
+			t.Fatalf("%s: expect populated items, got %v (%v)", typ, items, err)
+		}
+		var item *Item
+		if err = client.Call(context.Background(), "Shapes.Item", 3, &item); err != nil || item == nil || item.Name != "3" {
+			t.Fatalf("%s: expect a populated item, got %v (%v)", typ, item, err)
+		}
+		_ = client.Close()
+	}
+}
+
+func TestClient_BytesBody(t *testing.T) {
+	addr := startHTTPServer(t, Blob(0))
+	data := make([]byte, 1<<20)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	for _, opt := range []*common.Option{
+		{},
+		{ProtocolVersion: 1}, // 旧版本的对端，[]byte 仍然经过 gob 编码
+		{Compression: xxcode.CompressGzip},
+		{CodeType: xxcode.Type_Json},
+	} {
+		client, err := DialHTTP("tcp", addr, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reversed []byte
+		if err = client.Call(context.Background(), "Blob.Reverse", data, &reversed); err != nil || len(reversed) != len(data) || reversed[0] != data[len(data)-1] {
+			t.Fatalf("%+v: expect the reversed bytes, got %d bytes (%v)", opt, len(reversed), err)
+		}
+		var copied []byte
+		if err = client.Call(context.Background(), "Blob.Copy", data, &copied); err != nil || !bytes.Equal(copied, data) {
+			t.Fatalf("%+v: expect the bytes back, got %d bytes (%v)", opt, len(copied), err)
+		}
+		// 服务端丢弃找不到方法的请求体后，连接仍然对齐到下一个请求
+		if err = client.Call(context.Background(), "Blob.Missing", data, &copied); err == nil {
+			t.Fatalf("%+v: expect an error for a missing method", opt)
+		}
+		var empty []byte
+		if err = client.Call(context.Background(), "Blob.Copy", []byte{}, &empty); err != nil || len(empty) != 0 {
+			t.Fatalf("%+v: expect an empty reply, got %v (%v)", opt, empty, err)
+		}
+		_ = client.Close()
+	}
+}
+
+// Figure 是通过接口类型传递的参数和 reply
+type Figure interface{ Area() int }
+
+type Square struct{ Side int }
+
+func (s Square) Area() int { return s.Side * s.Side }
+
+type Figures int
+
+func (f Figures) Square(side int, reply *Figure) error {
+	*reply = Square{Side: side}
+	return nil
+}
+
+func (f Figures) Area(fig Figure, reply *int) error {
+	*reply = fig.Area()
+	return nil
+}
+
+func TestClient_InterfaceReply(t *testing.T) {
+	s := server.NewServer()
+	_ = s.Register(Figures(0))
+	if err := s.RegisterGobTypes(Square{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterGobTypes(Square{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterGobTypes(nil); err == nil {
+		t.Fatal("expect an error registering nil")
+	}
+	client, err := DialPipe(s.ServePipe())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var fig Figure
+	if err = client.Call(context.Background(), "Figures.Square", 3, &fig); err != nil || fig != (Square{Side: 3}) {
+		t.Fatalf("expect the concrete type to round-trip, got %#v (%v)", fig, err)
+	}
+	var area int
+	if err = client.Call(context.Background(), "Figures.Area", &fig, &area); err != nil || area != 9 {
+		t.Fatalf("expect an interface argument to round-trip, got %d (%v)", area, err)
+	}
+}
+
+func TestClient_CallRaw(t *testing.T) {
+	addr := startHTTPServer(t, Baz(0), Repeat(0), Text(0))
+	for _, typ := range []xxcode.Type{xxcode.Type_Json, xxcode.Type_Framed} {
+		client, err := DialHTTP("tcp", addr, &common.Option{CodeType: typ, CheckReplyType: true, Compression: xxcode.CompressGzip})
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := client.CallRaw(context.Background(), "Baz.Echo", 3)
+		var reply Reply
+		if err != nil || json.Unmarshal(raw, &reply) != nil || reply != (Reply{Name: "echo", Count: 3}) {
+			t.Fatalf("%s: expect raw JSON of the reply, got %q (%v)", typ, raw, err)
+		}
+		// 超过压缩阈值的 reply 解压后返回
+		raw, err = client.CallRaw(context.Background(), "Repeat.Strings", 5000)
+		var strs []string
+		if err != nil || json.Unmarshal(raw, &strs) != nil || len(strs) != 5000 {
+			t.Fatalf("%s: expect the decompressed raw reply, got %d strings (%v)", typ, len(strs), err)
+		}
+		if _, err = client.CallRaw(context.Background(), "Baz.Fail", 1); err == nil || err.Error() != "baz failed" {
+			t.Fatalf("%s: expect the handler's error, got %v", typ, err)
+		}
+		_ = client.Close()
+	}
+
+	client, err := DialHTTP("tcp", addr, &common.Option{CodeType: xxcode.Type_Proto})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := client.CallRaw(context.Background(), "Text.Upper", wrapperspb.String("abc"))
+	var reply wrapperspb.StringValue
+	if err != nil || proto.Unmarshal(raw, &reply) != nil || reply.GetValue() != "ABC" {
+		t.Fatalf("expect the raw protobuf reply, got %q (%v)", raw, err)
+	}
+	_ = client.Close()
+
+	client, err = DialHTTP("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	if _, err = client.CallRaw(context.Background(), "Baz.Echo", 3); !errors.Is(err, xxcode.ErrRawUnsupported) {
+		t.Fatalf("expect gob to be unsupported, got %v", err)
+	}
+}
+
+func TestClient_Compression(t *testing.T) {
+	addr := startHTTPServer(t, Repeat(0))
+	for _, codeType := range []xxcode.Type{xxcode.Type_Gob, xxcode.Type_Json} {
+		client, err := DialHTTP("tcp", addr, &common.Option{CodeType: codeType, Compression: xxcode.CompressGzip})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info := client.ConnInfo(); info.Compression != xxcode.CompressGzip {
+			t.Fatalf("expect gzip in ConnInfo, got %+v", info)
+		}
+		for _, n := range []int{1, 5000} {
+			var reply []string
+			if err = client.Call(context.Background(), "Repeat.Strings", n, &reply); err != nil || len(reply) != n {
+				t.Fatalf("%s: expect %d strings, got %d, %v", codeType, n, len(reply), err)
+			}
+		}
+		_ = client.Close()
+	}
+	if _, err := DialHTTP("tcp", addr, &common.Option{Compression: "snappy"}); err == nil {
+		t.Fatal("expect an error for an unsupported compression")
+	}
+}
+
+func TestClient_FramedGarbageBody(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	s := server.NewServer()
+	_ = s.Register(Baz(0))
+	go s.Accept(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := &common.Option{MagicNumber: common.MagicNumber, CodeType: xxcode.Type_Framed}
+	_ = json.NewEncoder(conn).Encode(opt)
+	cc := xxcode.NewFramedCode(conn)
+	defer func() { _ = cc.Close() }()
+
+	// 手工写入一个 body 不是合法 JSON 的请求
+	frame := func(b []byte) []byte {
+		return append(binary.BigEndian.AppendUint32(nil, uint32(len(b))), b...)
+	}
+	head, _ := json.Marshal(&xxcode.Header{ServiceMethod: "Baz.Echo", SeqId: 1})
+	if _, err = conn.Write(append(frame(head), frame([]byte("{garbage"))...)); err != nil {
+		t.Fatal(err)
+	}
+	if err = cc.Write(&xxcode.Header{ServiceMethod: "Baz.Echo", SeqId: 2}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	var h xxcode.Header
+	if err = cc.ReadHeader(&h); err != nil || h.SeqId != 1 || h.Error == "" {
+		t.Fatalf("expect an error for the garbage body, got %+v (%v)", h, err)
+	}
+	_ = cc.ReadBody(nil)
+	var reply Reply
+	if err = cc.ReadHeader(&h); err != nil || h.SeqId != 2 || h.Error != "" {
+		t.Fatalf("expect the next call to succeed, got %+v (%v)", h, err)
+	}
+	if err = cc.ReadBody(&reply); err != nil || reply.Count != 2 {
+		t.Fatalf("expect echo 2, got %+v (%v)", reply, err)
+	}
+
+	// 普通客户端同样可以使用
+	client, err := Dial("tcp", l.Addr().String(), &common.Option{CodeType: xxcode.Type_Framed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	if err = client.Call(context.Background(), "Baz.Echo", 3, &reply); err != nil || reply.Count != 3 {
+		t.Fatalf("expect echo 3, got %+v, %v", reply, err)
+	}
+}
+
+func TestClient_CheckMethod(t *testing.T) {
+	client, err := DialHTTP("tcp", startHTTPServer(t, Baz(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	if err = client.CheckMethod("Baz.Echo", 1, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+	n := 1
+	if err = client.CheckMethod("Baz.Echo", &n, new(Reply)); err != nil {
+		t.Fatalf("expect a pointer arg to match, got %v", err)
+	}
+	// 在真正调用之前发现 reply 类型不一致
+	if err = client.CheckMethod("Baz.Echo", 1, new(int)); err == nil || !strings.Contains(err.Error(), "reply type mismatch") {
+		t.Fatalf("expect a reply type mismatch, got %v", err)
+	}
+	if err = client.CheckMethod("Baz.Echo", "1", &Reply{}); err == nil || !strings.Contains(err.Error(), "arg type mismatch") {
+		t.Fatalf("expect an arg type mismatch, got %v", err)
+	}
+	if err = client.CheckMethod("Baz.Missing", 1, &Reply{}); err == nil || !strings.Contains(err.Error(), "can't find method") {
+		t.Fatalf("expect can't find method, got %v", err)
+	}
+}
+
+// nopLogger 丢弃所有日志，并记录是否被调用
+type nopLogger struct {
+	calls int32
+}
+
+func (l *nopLogger) Printf(format string, v ...interface{}) { atomic.AddInt32(&l.calls, 1) }
+func (l *nopLogger) Println(v ...interface{})               { atomic.AddInt32(&l.calls, 1) }
+
+func TestClient_Logger(t *testing.T) {
+	var std bytes.Buffer
+	log.SetOutput(&std)
+	defer log.SetOutput(os.Stderr)
+
+	logger := &nopLogger{}
+	s := server.NewServer(&common.Option{Logger: logger})
+	_ = s.Register(Baz(0))
+	client, err := DialHTTP("tcp", serveHTTP(t, s), &common.Option{Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply Reply
+	if err = client.Call(context.Background(), "Baz.Echo", 1, &reply); err != nil {
+		t.Fatal(err)
+	}
+	// 请求体无法解码时服务端记录日志
+	if err = client.Call(context.Background(), "Baz.Echo", "1", &reply); err == nil {
+		t.Fatal("expect a decoding error")
+	}
+	_ = client.Close()
+	// 之前的测试留下的 goroutine 可能仍在输出日志，只检查这次调用相关的内容
+	if out := std.String(); strings.Contains(out, "Baz") || strings.Contains(out, "read body err") {
+		t.Fatalf("expect nothing on the standard logger, got %q", out)
+	}
+	if atomic.LoadInt32(&logger.calls) == 0 {
+		t.Fatal("expect the logs to reach the injected logger")
+	}
+}
+
+func TestClient_ServerError(t *testing.T) {
+	client, err := DialHTTP("tcp", startHTTPServer(t, Baz(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply Reply
+	var serverErr *ServerError
+	for _, method := range []string{"Baz.Fail", "Baz.Missing", "Missing.Echo"} {
+		err = client.Call(context.Background(), method, 1, &reply)
+		if !errors.As(err, &serverErr) || serverErr.Message != err.Error() {
+			t.Fatalf("%s: expect a ServerError, got %T %v", method, err, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	if err = client.Call(ctx, "Baz.Echo", 1, &reply); err == nil || errors.As(err, &serverErr) {
+		t.Fatalf("expect a local timeout, got %T %v", err, err)
+	}
+	_ = client.Close()
+	if err = client.Call(context.Background(), "Baz.Echo", 1, &reply); !errors.Is(err, ErrShutdown) || errors.As(err, &serverErr) {
+		t.Fatalf("expect a local shutdown error, got %T %v", err, err)
+	}
+}
+
+// codeError 是处理函数返回的带错误码的错误
+type codeError int
+
+func (e codeError) Error() string { return fmt.Sprintf("custom error %d", int(e)) }
+func (e codeError) Code() int     { return int(e) }
+
+// Coded 返回带错误码的错误
+type Coded int
+
+func (c Coded) Fail(argv int, reply *int) error {
+	return fmt.Errorf("wrapped: %w", codeError(argv))
+}
+
+func TestClient_ErrorCode(t *testing.T) {
+	s := server.NewServer()
+	_ = s.Register(Coded(0))
+	_ = s.Register(Bomb(0))
+	_ = s.Register(Nap(0))
+	_ = s.Register(Baz(0))
+	client, err := DialHTTP("tcp", serveHTTP(t, s), &common.Option{HandleTimeout: time.Millisecond * 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	tests := []struct {
+		method string
+		want   int
+	}{
+		{"Coded.Fail", 42},
+		{"Baz.Missing", xxcode.CodeNotFound},
+		{"Missing.Fail", xxcode.CodeNotFound},
+		{"Bomb.Explode", xxcode.CodeInternal},
+		{"Nap.Take", xxcode.CodeTimeout},
+		{"Baz.Fail", 0},
+	}
+	for _, tt := range tests {
+		var serverErr *ServerError
+		err = client.Call(context.Background(), tt.method, 42, new(int))
+		if !errors.As(err, &serverErr) || serverErr.Code != tt.want {
+			t.Fatalf("%s: expect code %d, got %v", tt.method, tt.want, err)
+		}
+	}
+}
+
+type Budget int
+
+// Remaining 返回处理函数 ctx 剩余的毫秒数，没有 deadline 时返回 -1
+func (b Budget) Remaining(ctx context.Context, argv int, reply *int64) error {
+	*reply = -1
+	if deadline, ok := ctx.Deadline(); ok {
+		*reply = time.Until(deadline).Milliseconds()
+	}
+	return nil
+}
+
+func TestClient_Deadline(t *testing.T) {
+	s := server.NewServer()
+	_ = s.Register(Budget(0))
+	client, err := DialHTTP("tcp", serveHTTP(t, s), &common.Option{HandleTimeout: time.Second * 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	tests := []struct {
+		name    string
+		timeout time.Duration // 0 表示 ctx 没有 deadline
+		want    time.Duration // 服务端处理函数看到的剩余时间
+	}{
+		{"longer than HandleTimeout", time.Second * 5, time.Second * 2},
+		{"shorter than HandleTimeout", time.Millisecond * 500, time.Millisecond * 500},
+		{"no deadline", 0, time.Second * 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			var remaining int64
+			if err := client.Call(ctx, "Budget.Remaining", 0, &remaining); err != nil {
+				t.Fatal(err)
+			}
+			got := time.Duration(remaining) * time.Millisecond
+			if got > tt.want || got < tt.want-time.Millisecond*200 {
+				t.Fatalf("expect about %s remaining, got %s", tt.want, got)
+			}
+		})
+	}
+
+	// 已经过期的截止时间不会交给处理函数
+	call := &Call{ServiceMethod: "Budget.Remaining", Args: 0, Reply: new(int64), Done: make(chan *Call, 1),
+		deadline: time.Now().Add(-time.Second)}
+	client.send(call)
+	var serverErr *ServerError
+	if err := (<-call.Done).Error; !errors.As(err, &serverErr) || serverErr.Code != xxcode.CodeTimeout {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+}
+
+func TestClient_ReturnedReply(t *testing.T) {
+	addr := startHTTPServer(t, Lookup(0), Baz(0))
+	for _, typ := range []xxcode.Type{xxcode.Type_Gob, xxcode.Type_Json} {
+		client, err := DialHTTP("tcp", addr, &common.Option{CodeType: typ})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests := []struct {
+			method string
+			want   Reply
+		}{
+			{"Lookup.Get", Reply{Name: "get", Count: 3}},
+			{"Lookup.Find", Reply{Name: "find", Count: 3}},
+			{"Baz.Echo", Reply{Name: "echo", Count: 3}}, // 原有的签名不受影响
+		}
+		for _, tt := range tests {
+			var reply Reply
+			if err := client.Call(context.Background(), tt.method, 3, &reply); err != nil || reply != tt.want {
+				t.Fatalf("%s %s: expect %+v, got %+v (%v)", typ, tt.method, tt.want, reply, err)
+			}
+		}
+		var reply Reply
+		if err := client.Call(context.Background(), "Lookup.Find", -1, &reply); err == nil || err.Error() != "not found" {
+			t.Fatalf("%s: expect the returned error, got %v", typ, err)
+		}
+		_ = client.Close()
+	}
+}
+
+// 整个调用过程都在内存中完成，不经过网络
+func TestDialPipe(t *testing.T) {
+	s := server.NewServer()
+	_ = s.Register(Baz(0))
+	for _, typ := range []xxcode.Type{xxcode.Type_Gob, xxcode.Type_Json, xxcode.Type_Framed} {
+		client, err := DialPipe(s.ServePipe(), &common.Option{CodeType: typ})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var reply Reply
+				if err := client.Call(context.Background(), "Baz.Echo", i, &reply); err != nil || reply.Count != i {
+					t.Errorf("%s: expect %d, got %+v (%v)", typ, i, reply, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if err := client.Call(context.Background(), "Baz.Fail", 1, new(Reply)); err == nil || err.Error() != "baz failed" {
+			t.Fatalf("%s: expect the handler's error, got %v", typ, err)
+		}
+		_ = client.Close()
+	}
+}
+
+func TestNewClientWithCodec(t *testing.T) {
+	s := server.NewServer()
+	_ = s.Register(Baz(0))
+	c1, c2 := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.ServeCodec(xxcode.NewGobCode(c2), nil)
+		close(done)
+	}()
+
+	client, err := NewClientWithCodec(xxcode.NewGobCode(c1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply Reply
+	if err := client.Call(context.Background(), "Baz.Echo", 7, &reply); err != nil || reply != (Reply{Name: "echo", Count: 7}) {
+		t.Fatalf("expect a call without handshake to succeed, got %+v (%v)", reply, err)
+	}
+	_ = client.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expect ServeCodec to return once the client closes")
+	}
+}
+
+// countedCode 记录 Release 的次数
+type countedCode struct {
+	xxcode.Code
+	released *int32
+}
+
+func (c *countedCode) Release() {
+	atomic.AddInt32(c.released, 1)
+	xxcode.ReleaseCode(c.Code)
+}
+
+func TestClient_ReleaseCode(t *testing.T) {
+	const typeCounted xxcode.Type = "application/x-test-counted-gob"
+	var created, released int32
+	_ = xxcode.RegisterCodec(typeCounted, func(conn io.ReadWriteCloser) xxcode.Code {
+		atomic.AddInt32(&created, 1)
+		return &countedCode{Code: xxcode.NewGobCode(conn), released: &released}
+	})
+	s := server.NewServer()
+	_ = s.Register(Baz(0))
+	client, err := DialPipe(s.ServePipe(), &common.Option{CodeType: typeCounted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply Reply
+	if err = client.Call(context.Background(), "Baz.Echo", 1, &reply); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.Close()
+	// 客户端和服务端各自在读写结束后归还自己创建的编解码器
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&released) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect both codecs to be released, %d of %d", atomic.LoadInt32(&released), atomic.LoadInt32(&created))
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// 传给 NewClientWithCodec 的编解码器留给调用方
+	var own int32
+	c1, c2 := net.Pipe()
+	go s.ServeCodec(xxcode.NewGobCode(c2), nil)
+	client, err = NewClientWithCodec(&countedCode{Code: xxcode.NewGobCode(c1), released: &own}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = client.Close()
+	time.Sleep(time.Millisecond * 50)
+	if n := atomic.LoadInt32(&own); n != 0 {
+		t.Fatalf("expect the caller's codec to be kept, released %d times", n)
+	}
+}
+
+func TestClient_Stats(t *testing.T) {
+	s := server.NewServer()
+	_ = s.Register(Nap(0))
+	_ = s.Register(Baz(0))
+	client, err := DialPipe(s.ServePipe())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	done := make(chan *Call, 5)
+	for i := 0; i < 5; i++ {
+		client.Go("Nap.Take", i, new(int), done)
+	}
+	if n := client.NumPending(); n != 5 {
+		t.Fatalf("expect 5 pending calls, got %d", n)
+	}
+	for i := 0; i < 5; i++ {
+		if call := <-done; call.Error != nil {
+			t.Fatal(call.Error)
+		}
+	}
+	_ = client.Call(context.Background(), "Baz.Fail", 1, new(Reply))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_ = client.Call(ctx, "Nap.Take", 1, new(int))
+	_ = client.Ping(context.Background())
+
+	want := Stats{Sent: 7, Completed: 5, Errored: 2}
+	if got := client.Stats(); got != want {
+		t.Fatalf("expect %+v, got %+v", want, got)
+	}
+}
+
+func TestClient_KeepAlive(t *testing.T) {
+	opt := &common.Option{KeepAliveInterval: time.Millisecond * 50}
+	client, err := Dial("tcp", blackholeServer(t), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	call := client.Go("Baz.Echo", 1, new(Reply), nil)
+	select {
+	case <-call.Done:
+		if call.Error != ErrKeepAliveTimeout {
+			t.Fatalf("expect keepalive timeout, got %v", call.Error)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the dead connection to be detected")
+	}
+	if client.IsAvailable() {
+		t.Fatal("expect the client to be unavailable")
+	}
+
+	// 正常的服务端回复 ping，连接保持可用
+	s := server.NewServer()
+	_ = s.Register(Baz(0))
+	alive, err := DialPipe(s.ServePipe(), &common.Option{KeepAliveInterval: time.Millisecond * 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = alive.Close() }()
+	time.Sleep(time.Millisecond * 100)
+	var reply Reply
+	if err = alive.Call(context.Background(), "Baz.Echo", 2, &reply); err != nil || reply.Count != 2 {
+		t.Fatalf("expect the connection to stay usable, got %+v, %v", reply, err)
+	}
+}
+
+func TestPool_Warmup(t *testing.T) {
+	s := server.NewServer()
+	_ = s.Register(Baz(0))
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	// 每个连接的 CONNECT 响应延迟 100ms，拨号的代价明显大于一次调用
+	go func() { _ = http.Serve(slowListener{l}, s) }()
+	rpcAddr := "http@" + l.Addr().String()
+
+	pool := NewPool(2)
+	defer func() { _ = pool.Close() }()
+	if err = pool.Warmup(context.Background(), rpcAddr, 3); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		client, err := pool.Get(rpcAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reply Reply
+		if err = client.Call(context.Background(), "Baz.Echo", i, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Millisecond*50 {
+			t.Fatalf("expect a warmed connection, first call took %s", elapsed)
+		}
+		defer pool.Put(rpcAddr, client)
+	}
+}
+
+// freezeListener 记录接受的连接，freeze 之后这些连接不再写出任何数据，模拟被静默丢弃的空闲连接
+type freezeListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns []*freezeConn
+}
+
+type freezeConn struct {
+	net.Conn
+	frozen int32
+}
+
+func (l *freezeListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	fc := &freezeConn{Conn: conn}
+	l.mu.Lock()
+	l.conns = append(l.conns, fc)
+	l.mu.Unlock()
+	return fc, nil
+}
+
+func (l *freezeListener) freeze() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, conn := range l.conns {
+		atomic.StoreInt32(&conn.frozen, 1)
+	}
+}
+
+func (c *freezeConn) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&c.frozen) == 1 {
+		return len(p), nil
+	}
+	return c.Conn.Write(p)
+}
+
+func TestPool_Reaper(t *testing.T) {
+	s := server.NewServer()
+	_ = s.Register(Baz(0))
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	fl := &freezeListener{Listener: l}
+	go func() { _ = http.Serve(fl, s) }()
+	rpcAddr := "http@" + l.Addr().String()
+
+	pool := NewPool(1)
+	defer func() { _ = pool.Close() }()
+	pool.SetReaper(time.Millisecond*50, 0)
+	c1, err := pool.Get(rpcAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply Reply
+	if err = c1.Call(context.Background(), "Baz.Echo", 1, &reply); err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(rpcAddr, c1)
+
+	// 服务端不再响应这个空闲连接，它仍然没有断开，只有 Ping 能发现
+	fl.freeze()
+	waitUnavailable := func(c *Client) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); c.IsAvailable(); time.Sleep(time.Millisecond * 10) {
+			if time.Now().After(deadline) {
+				t.Fatal("expect the reaper to evict the idle client")
+			}
+		}
+	}
+	waitUnavailable(c1)
+	c2, err := pool.Get(rpcAddr)
+	if err != nil || c2 == c1 {
+		t.Fatalf("expect the pool to dial a fresh connection, got %v", err)
+	}
+	if err = c2.Call(context.Background(), "Baz.Echo", 2, &reply); err != nil || reply.Count != 2 {
+		t.Fatalf("expect the fresh connection to work, got %+v, %v", reply, err)
+	}
+
+	// 空闲超过 idleTimeout 的 Client 即使可用也会被关闭
+	pool.Put(rpcAddr, c2)
+	pool.SetReaper(time.Millisecond*20, time.Millisecond*10)
+	waitUnavailable(c2)
+}
+
+// trackListener 记录接受的连接，kill 关闭监听和所有连接，模拟服务端重启
+type trackListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *trackListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.conns = append(l.conns, conn)
+		l.mu.Unlock()
+	}
+	return conn, err
+}
+
+func (l *trackListener) kill() {
+	_ = l.Close()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, conn := range l.conns {
+		_ = conn.Close()
+	}
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	s := server.NewServer()
+	_ = s.Register(Baz(0))
+	serve := func(addr string) *trackListener {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tl := &trackListener{Listener: l}
+		go func() {
+			for {
+				conn, err := tl.Accept()
+				if err != nil {
+					return
+				}
+				go s.ServeConn(conn)
+			}
+		}()
+		return tl
+	}
+	l := serve(":0")
+	addr := l.Addr().String()
+
+	client, err := Dial("tcp", addr, &common.Option{
+		Reconnect:           true,
+		MaxReconnectBackoff: time.Millisecond * 50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	var reply Reply
+	if err = client.Call(context.Background(), "Baz.Echo", 1, &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	l.kill()
+	for client.IsAvailable() {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err = client.Call(context.Background(), "Baz.Echo", 2, &reply); err != ErrReconnecting {
+		t.Fatalf("expect ErrReconnecting while the server is down, got %v", err)
+	}
+
+	l = serve(addr)
+	defer l.kill()
+	deadline := time.Now().Add(time.Second * 3)
+	for !client.IsAvailable() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err = client.Call(context.Background(), "Baz.Echo", 3, &reply); err != nil || reply.Count != 3 {
+		t.Fatalf("expect a call after reconnecting, got %+v, %v", reply, err)
+	}
+}
+
+// 共用的 Done 已满且无人读取时，其他调用仍能完成
+func TestClient_DoneChanFull(t *testing.T) {
+	client, err := DialHTTP("tcp", startHTTPServer(t, Baz(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	done := make(chan *Call, 1)
+	var r1, r2 Reply
+	client.Go("Baz.Echo", 1, &r1, done)
+	client.Go("Baz.Echo", 2, &r2, done)
+	// 等待两个响应都被 receive 取走，第二个的通知已经无处可放
+	for numPending(client) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var reply Reply
+	if err = client.Call(ctx, "Baz.Echo", 3, &reply); err != nil || reply.Count != 3 {
+		t.Fatalf("expect echo 3 despite the full Done chan, got %+v, %v", reply, err)
+	}
+	if call := <-done; call.Error != nil {
+		t.Fatal(call.Error)
+	}
+}
+
+// Inbox 收到通知后写入 got
+type Inbox chan int
+
+func (i Inbox) Put(n int, reply *int) error {
+	i <- n
+	return errors.New("ignored")
+}
+
+// Meta 返回请求携带的元数据
+type Meta int
+
+func (m Meta) Echo(ctx context.Context, argv int, reply *map[string]string) error {
+	*reply = server.Metadata(ctx)
+	return nil
+}
+
+// Tags 修改自己的参数，返回修改前看到的元素个数
+type Tags int
+
+type TagArgs struct {
+	Names []string
+	Seen  map[string]bool
+}
+
+func (t Tags) Add(args *TagArgs, reply *int) error {
+	*reply = len(args.Names) + len(args.Seen)
+	args.Names = append(args.Names, "mutated")
+	if args.Seen == nil {
+		args.Seen = make(map[string]bool)
+	}
+	args.Seen["mutated"] = true
+	return nil
+}
+
+// Flaky 前两次调用超过服务端的处理超时，之后正常返回
+type Flaky struct{ calls int32 }
+
+func (f *Flaky) Try(argv int, reply *int) error {
+	if atomic.AddInt32(&f.calls, 1) <= 2 {
+		time.Sleep(time.Millisecond * 200)
+	}
+	*reply = argv
+	return nil
+}
+
+func TestClient_CallRetry(t *testing.T) {
+	var f Flaky
+	client, err := DialHTTP("tcp", startHTTPServer(t, &f, Baz(0)), &common.Option{
+		HandleTimeout: time.Millisecond * 50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond * 10}
+	var reply int
+	if err = client.CallRetry(context.Background(), "Flaky.Try", 7, &reply, policy); err != nil || reply != 7 {
+		t.Fatalf("expect success on the third attempt, got %d, %v", reply, err)
+	}
+	if n := atomic.LoadInt32(&f.calls); n != 3 {
+		t.Fatalf("expect 3 attempts, got %d", n)
+	}
+
+	// 处理函数返回的错误不重试
+	var r Reply
+	if err = client.CallRetry(context.Background(), "Baz.Fail", 1, &r, policy); err == nil || err.Error() != "baz failed" {
+		t.Fatalf("expect the handler error, got %v", err)
+	}
+
+	// 用尽次数后返回最后一次的错误
+	atomic.StoreInt32(&f.calls, 0)
+	policy.MaxAttempts = 2
+	if err = client.CallRetry(context.Background(), "Flaky.Try", 7, &reply, policy); err == nil || !strings.Contains(err.Error(), "handle timeout") {
+		t.Fatalf("expect the timeout error, got %v", err)
+	}
+}
+
+func TestClient_Notify(t *testing.T) {
+	inbox := make(Inbox, 1)
+	addr := startHTTPServer(t, inbox, Baz(0))
+	client, err := DialHTTP("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	if err = client.Notify("Inbox.Put", 5); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case n := <-inbox:
+		if n != 5 {
+			t.Fatalf("expect 5, got %d", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the notified method was not invoked")
+	}
+	if n := numPending(client); n != 0 {
+		t.Fatalf("expect no pending calls, got %d", n)
+	}
+
+	// 直接读取连接：单向请求即使失败也没有响应，第一个响应属于之后的普通请求
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	cc, err := connectHTTP(conn, common.DefaultOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _, err := newCode(cc, common.DefaultOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = code.Write(&xxcode.Header{ServiceMethod: "Inbox.Put", SeqId: xxcode.OneWaySeqId}, 6)
+	_ = code.Write(&xxcode.Header{ServiceMethod: "Baz.Missing", SeqId: xxcode.OneWaySeqId}, 7)
+	<-inbox
+	_ = code.Write(&xxcode.Header{ServiceMethod: "Baz.Echo", SeqId: 1}, 8)
+	var h xxcode.Header
+	if err = code.ReadHeader(&h); err != nil || h.SeqId != 1 || h.Error != "" {
+		t.Fatalf("expect the response to seq 1 first, got %+v (%v)", h, err)
+	}
+}
+
+// 处理函数修改参数后，之后的请求仍然得到干净的参数
+func TestClient_ArgvIsolation(t *testing.T) {
+	client, err := DialHTTP("tcp", startHTTPServer(t, Tags(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	args := &TagArgs{Names: []string{"a"}}
+	for i := 0; i < 3; i++ {
+		var reply int
+		if err = client.Call(context.Background(), "Tags.Add", args, &reply); err != nil || reply != 1 {
+			t.Fatalf("call %d: expect a clean arg with 1 element, got %d, %v", i, reply, err)
+		}
+	}
+}
+
+func TestClient_CallWithMeta(t *testing.T) {
+	addr := startHTTPServer(t, Meta(0))
+	md := map[string]string{"trace-id": "abc", "tenant": "t1", "token": ""}
+	for _, codeType := range []xxcode.Type{xxcode.Type_Gob, xxcode.Type_Json} {
+		client, err := DialHTTP("tcp", addr, &common.Option{CodeType: codeType})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]string
+		if err = client.CallWithMeta(context.Background(), "Meta.Echo", 1, &got, md); err != nil || !reflect.DeepEqual(got, md) {
+			t.Fatalf("%s: expect metadata %v, got %v (%v)", codeType, md, got, err)
+		}
+		// 之后不带元数据的调用不会沿用上一次的元数据
+		got = nil
+		if err = client.Call(context.Background(), "Meta.Echo", 1, &got); err != nil || len(got) != 0 {
+			t.Fatalf("%s: expect no metadata, got %v (%v)", codeType, got, err)
+		}
+		_ = client.Close()
+	}
+}
+
+// Busy 记录同时运行的调用数的最大值
+type Busy struct{ running, peak int32 }
+
+func (b *Busy) Work(argv int, reply *int) error {
+	n := atomic.AddInt32(&b.running, 1)
+	defer atomic.AddInt32(&b.running, -1)
+	for {
+		peak := atomic.LoadInt32(&b.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(&b.peak, peak, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond * 20)
+	return nil
+}
+
+// Hits 统计收到的调用次数
+type Hits struct{ n int32 }
+
+func (h *Hits) Hit(argv int, reply *int) error {
+	*reply = int(atomic.AddInt32(&h.n, 1))
+	return nil
+}
+
+func TestXClient_Call(t *testing.T) {
+	var h1, h2 Hits
+	servers := []string{"http@" + startHTTPServer(t, &h1), "http@" + startHTTPServer(t, &h2)}
+	xc := NewXClient(servers, &RoundRobinSelect{}, nil)
+	defer func() { _ = xc.Close() }()
+
+	for i := 0; i < 10; i++ {
+		var reply int
+		if err := xc.Call(context.Background(), "Hits.Hit", i, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n1, n2 := atomic.LoadInt32(&h1.n), atomic.LoadInt32(&h2.n); n1 != 5 || n2 != 5 {
+		t.Fatalf("expect 5 calls on each server, got %d and %d", n1, n2)
+	}
+
+	// 缓存的 Client 断开后重新拨号
+	for _, client := range xc.clients {
+		_ = client.Close()
+	}
+	var reply int
+	if err := xc.Call(context.Background(), "Hits.Hit", 1, &reply); err != nil {
+		t.Fatalf("expect a re-dial after the cached client closed, got %v", err)
+	}
+
+	// 无法连接的服务端被跳过
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := "http@" + l.Addr().String()
+	_ = l.Close()
+	xc2 := NewXClient([]string{down, servers[0]}, &RoundRobinSelect{}, nil)
+	defer func() { _ = xc2.Close() }()
+	for i := 0; i < 2; i++ {
+		if err = xc2.Call(context.Background(), "Hits.Hit", 1, &reply); err != nil {
+			t.Fatalf("expect the down server to be skipped, got %v", err)
+		}
+	}
+}
+
+func TestXClient_DialWithoutLock(t *testing.T) {
+	var h Hits
+	healthy := "http@" + startHTTPServer(t, &h)
+	// 不回应 CONNECT 的服务端使拨号一直等到 ConnectTimeout
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	hung := "http@" + l.Addr().String()
+	xc := NewXClient([]string{hung, healthy}, &RoundRobinSelect{}, &common.Option{ConnectTimeout: time.Second * 2})
+	defer func() { _ = xc.Close() }()
+
+	go func() { _, _ = xc.dial(hung) }()
+	time.Sleep(time.Millisecond * 100)
+	start := time.Now()
+	if _, err = xc.dial(healthy); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("dialing the healthy server waited for the hung one: %v", elapsed)
+	}
+}
+
+// Cast 按 mode 返回结果、返回错误或一直等到 ctx 被取消
+type Cast struct {
+	mode     string
+	canceled chan struct{}
+}
+
+func (c *Cast) Do(ctx context.Context, argv int, reply *Reply) error {
+	switch c.mode {
+	case "fail":
+		return errors.New("cast failed")
+	case "slow":
+		select {
+		case <-ctx.Done():
+			close(c.canceled)
+			return ctx.Err()
+		case <-time.After(time.Second * 5):
+		}
+	}
+	*reply = Reply{Name: "cast", Count: argv}
+	return nil
+}
+
+func TestXClient_Broadcast(t *testing.T) {
+	ok1, ok2 := &Cast{mode: "ok"}, &Cast{mode: "ok"}
+	servers := []string{"http@" + startHTTPServer(t, ok1), "http@" + startHTTPServer(t, ok2)}
+	xc := NewXClient(servers, nil, nil)
+	defer func() { _ = xc.Close() }()
+	var reply Reply
+	if err := xc.Broadcast(context.Background(), "Cast.Do", 3, &reply); err != nil || reply.Count != 3 {
+		t.Fatalf("expect a reply from any server, got %+v, %v", reply, err)
+	}
+
+	slow := &Cast{mode: "slow", canceled: make(chan struct{})}
+	servers = append(servers, "http@"+startHTTPServer(t, &Cast{mode: "fail"}), "http@"+startHTTPServer(t, slow))
+	xc2 := NewXClient(servers, nil, nil)
+	defer func() { _ = xc2.Close() }()
+	start := time.Now()
+	if err := xc2.Broadcast(context.Background(), "Cast.Do", 3, &reply); err == nil || err.Error() != "cast failed" {
+		t.Fatalf("expect the failing server's error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expect the slow call to be cancelled, Broadcast took %s", elapsed)
+	}
+	select {
+	case <-slow.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the slow server never saw the cancellation")
+	}
+}
+
+func TestBalancer(t *testing.T) {
+	servers := []string{"a", "b", "c", "d"}
+	rr := &RoundRobinSelect{}
+	for i := 0; i < 8; i++ {
+		if got, _ := rr.Next(servers); got != servers[i%4] {
+			t.Fatalf("pick %d: expect %s, got %s", i, servers[i%4], got)
+		}
+	}
+
+	for _, b := range []Balancer{RandomSelect{}, &RoundRobinSelect{}} {
+		if _, err := b.Next(nil); err == nil {
+			t.Fatalf("%T: expect an error without servers", b)
+		}
+		counts := make(map[string]int)
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 2000; i++ {
+					s, _ := b.Next(servers)
+					mu.Lock()
+					counts[s]++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		for _, s := range servers {
+			if counts[s] < 1800 || counts[s] > 2200 {
+				t.Fatalf("%T: expect about 2000 picks of %s, got %v", b, s, counts)
+			}
+		}
+	}
+}
+
+// brokenServer 接受连接、读取到数据后立即断开，模拟处理请求时宕机的服务端
+func brokenServer(t *testing.T) string {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				// 跳过 Option，读到请求后断开
+				br := bufio.NewReader(conn)
+				_, _ = br.ReadBytes('\n')
+				_, _ = br.ReadByte()
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return "tcp@" + l.Addr().String()
+}
+
+func TestXClient_Failover(t *testing.T) {
+	var h Hits
+	s := server.NewServer()
+	_ = s.Register(&h)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go s.Accept(l)
+	servers := []string{brokenServer(t), "tcp@" + l.Addr().String()}
+
+	// 第一个选中的服务端宕机，幂等方法在第二个服务端上成功
+	xc := NewXClient(servers, &RoundRobinSelect{}, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetIdempotent("Hits.Hit")
+	var reply int
+	if err = xc.Call(context.Background(), "Hits.Hit", 1, &reply); err != nil || reply != 1 {
+		t.Fatalf("expect failover to the second server, got %d, %v", reply, err)
+	}
+
+	// 没有标记为幂等的方法不重试
+	xc2 := NewXClient(servers, &RoundRobinSelect{}, nil)
+	defer func() { _ = xc2.Close() }()
+	if err = xc2.Call(context.Background(), "Hits.Hit", 1, &reply); err == nil {
+		t.Fatal("expect the broken server's error without failover")
+	}
+
+	// 只允许一次尝试
+	xc3 := NewXClient(servers, &RoundRobinSelect{}, nil)
+	defer func() { _ = xc3.Close() }()
+	xc3.SetIdempotent("Hits.Hit")
+	xc3.SetMaxAttempts(1)
+	if err = xc3.Call(context.Background(), "Hits.Hit", 1, &reply); err == nil {
+		t.Fatal("expect no failover with a single attempt")
+	}
+	if n := atomic.LoadInt32(&h.n); n != 1 {
+		t.Fatalf("expect the healthy server to see 1 call, got %d", n)
+	}
+}
+
+func TestRegistryDiscovery(t *testing.T) {
+	var h1, h2 Hits
+	servers := []string{"http@" + startHTTPServer(t, &h1), "http@" + startHTTPServer(t, &h2)}
+	var current atomic.Value
+	current.Store(servers[:1])
+	var fetches int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Header().Set(registry.ServersHeader, strings.Join(current.Load().([]string), ","))
+	}))
+	defer ts.Close()
+
+	d := NewRegistryDiscovery(ts.URL, time.Millisecond*100)
+	xc := NewDiscoveryXClient(d, &RoundRobinSelect{}, nil)
+	defer func() { _ = xc.Close() }()
+	var reply int
+	for i := 0; i < 4; i++ {
+		if err := xc.Call(context.Background(), "Hits.Hit", 1, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("expect the list to be cached, got %d fetches", n)
+	}
+	if n1, n2 := atomic.LoadInt32(&h1.n), atomic.LoadInt32(&h2.n); n1 != 4 || n2 != 0 {
+		t.Fatalf("expect all calls on the first server, got %d and %d", n1, n2)
+	}
+
+	// TTL 过期后在后台刷新，刷新完成前仍然返回旧的列表
+	current.Store(servers[1:])
+	time.Sleep(time.Millisecond * 150)
+	if got, _ := d.GetAll(); !reflect.DeepEqual(got, servers[:1]) {
+		t.Fatalf("expect the stale list while refreshing, got %v", got)
+	}
+	deadline := time.Now().Add(time.Second)
+	for got, _ := d.GetAll(); !reflect.DeepEqual(got, servers[1:]); got, _ = d.GetAll() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect the refreshed list, got %v", got)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err := xc.Call(context.Background(), "Hits.Hit", 1, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&h2.n); n != 1 {
+		t.Fatalf("expect the call on the refreshed server, got %d", n)
+	}
+	// 不再列出的服务端的 Client 被关闭并删除
+	xc.mu.Lock()
+	_, ok := xc.clients[servers[0]]
+	xc.mu.Unlock()
+	if ok {
+		t.Fatal("expect the client of the removed server to be evicted")
+	}
+}
+
+func TestRegistryDiscovery_HungRegistry(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	d := NewRegistryDiscovery(ts.URL, time.Millisecond*100)
+	errc := make(chan error, 1)
+	go func() { errc <- d.Refresh() }()
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("expect an error from the hung registry")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("refresh didn't time out")
+	}
+}
+
+// selfSignedTLS 生成 127.0.0.1 的自签名证书，返回服务端和客户端的配置
+func selfSignedTLS(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "xxrpc test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	serverCfg := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	return serverCfg, &tls.Config{RootCAs: pool}
+}
+
+func TestDialTLS(t *testing.T) {
+	serverCfg, clientCfg := selfSignedTLS(t)
+	s := server.NewServer()
+	_ = s.Register(Baz(0))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go s.AcceptTLS(l, serverCfg)
+
+	client, err := DialTLS("tcp", l.Addr().String(), clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	if !client.ConnInfo().TLS {
+		t.Fatal("expect a TLS connection")
+	}
+	var reply Reply
+	if err = client.Call(context.Background(), "Baz.Echo", 4, &reply); err != nil || reply.Count != 4 {
+		t.Fatalf("expect a call over TLS, got %+v, %v", reply, err)
+	}
+
+	// 不信任服务端证书时握手失败
+	if _, err = DialTLS("tcp", l.Addr().String(), &tls.Config{}); err == nil {
+		t.Fatal("expect an untrusted certificate to fail")
+	}
+}
+
+func TestClient_BadMagicNumber(t *testing.T) {
+	addr := startHTTPServer(t, Baz(0))
+	if _, err := DialHTTP("tcp", addr, &common.Option{MagicNumber: 0x123}); !errors.Is(err, common.ErrInvalidOption) || !strings.Contains(err.Error(), "MagicNumber") {
+		t.Fatalf("expect the client to reject the option, got %v", err)
+	}
+
+	// 绕过客户端的检查，服务端同样拒绝握手
+	s := server.NewServer()
+	_ = s.Register(Baz(0))
+	opt, _ := parseOptions(&common.Option{MagicNumber: 0x123})
+	for i := 0; i < 20; i++ {
+		cc, typ, err := newCode(s.ServePipe(), opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := newClientCode(cc, typ, opt)
+		var reply Reply
+		err = client.Call(context.Background(), "Baz.Echo", 1, &reply)
+		var serverErr *ServerError
+		if !errors.Is(err, ErrRejected) || !errors.As(err, &serverErr) || !strings.Contains(serverErr.Message, "invalid magic number 123") {
+			t.Fatalf("expect the handshake to be rejected, got %v", err)
+		}
+		// 之后的调用报告同样的错误，而不是 ErrShutdown
+		if err = client.Call(context.Background(), "Baz.Echo", 1, &reply); !errors.Is(err, ErrRejected) {
+			t.Fatalf("expect later calls to report the rejection, got %v", err)
+		}
+		_ = client.Close()
+	}
+}
+
+func TestClient_ProtocolVersion(t *testing.T) {
+	addr := startHTTPServer(t, Baz(0))
+	tests := []struct {
+		version int
+		ok      bool
+	}{
+		{0, true},
+		{common.ProtocolVersion, true},
+		{common.ProtocolVersion + 1, false},
+		{-1, false},
+	}
+	for _, tt := range tests {
+		client, err := DialHTTP("tcp", addr, &common.Option{ProtocolVersion: tt.version})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reply Reply
+		err = client.Call(context.Background(), "Baz.Echo", 1, &reply)
+		_ = client.Close()
+		if tt.ok && err != nil {
+			t.Fatalf("version %d: expect the call to succeed, got %v", tt.version, err)
+		}
+		if !tt.ok && (!errors.Is(err, ErrRejected) || !strings.Contains(err.Error(), "unsupported protocol version")) {
+			t.Fatalf("version %d: expect the handshake to be rejected, got %v", tt.version, err)
+		}
+	}
+}
+
+func TestClient_AcceptCodecs(t *testing.T) {
+	s := server.NewServer(&common.Option{SupportedCodecs: []xxcode.Type{xxcode.Type_Json, xxcode.Type_Framed}})
+	_ = s.Register(Baz(0))
+	s.SetAuthFunc(func(token string) error {
+		if token != "secret" {
+			return errors.New("bad token")
+		}
+		return nil
+	})
+
+	// 双方只有 Type_Framed 是共同的编解码器
+	accept := []xxcode.Type{xxcode.Type_Proto, xxcode.Type_Gob, xxcode.Type_Framed}
+	client, err := DialPipe(s.ServePipe(), &common.Option{AcceptCodecs: accept, AuthToken: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := client.ConnInfo(); info.CodeType != xxcode.Type_Framed {
+		t.Fatalf("expect the framed codec to be selected, got %+v", info)
+	}
+	var reply Reply
+	if err = client.Call(context.Background(), "Baz.Echo", 3, &reply); err != nil || reply.Count != 3 {
+		t.Fatalf("expect the call to succeed over the negotiated codec, got %+v (%v)", reply, err)
+	}
+	_ = client.Close()
+
+	_, err = DialPipe(s.ServePipe(), &common.Option{AcceptCodecs: []xxcode.Type{xxcode.Type_Gob}, AuthToken: "secret"})
+	if !errors.Is(err, ErrRejected) || !strings.Contains(err.Error(), "unsupported codec") {
+		t.Fatalf("expect no common codec to be rejected, got %v", err)
+	}
+	_, err = DialPipe(s.ServePipe(), &common.Option{AcceptCodecs: accept, AuthToken: "wrong"})
+	if !errors.Is(err, ErrRejected) || !strings.Contains(err.Error(), "auth rejected") {
+		t.Fatalf("expect the auth failure in the handshake reply, got %v", err)
+	}
+
+	// 不协商时声明服务端不支持的编解码器同样被拒绝
+	client, err = DialPipe(s.ServePipe(), &common.Option{CodeType: xxcode.Type_Gob, AuthToken: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.Call(context.Background(), "Baz.Echo", 3, &reply)
+	if !errors.Is(err, ErrRejected) || !strings.Contains(err.Error(), "unsupported codec") {
+		t.Fatalf("expect an unsupported CodeType to be rejected, got %v", err)
+	}
+	_ = client.Close()
+}
+
+func TestClient_AuthToken(t *testing.T) {
+	s := server.NewServer()
+	_ = s.Register(Baz(0))
+	s.SetAuthFunc(func(token string) error {
+		if token != "secret" {
+			return errors.New("bad token")
+		}
+		return nil
+	})
+	addr := serveHTTP(t, s)
+	call := func(token string) error {
+		client, err := DialHTTP("tcp", addr, &common.Option{AuthToken: token})
+		if err != nil {
+			return err
+		}
+		defer func() { _ = client.Close() }()
+		var reply Reply
+		return client.Call(context.Background(), "Baz.Echo", 1, &reply)
+	}
+	if err := call("secret"); err != nil {
+		t.Fatalf("expect the token to be accepted, got %v", err)
+	}
+	var serverErr *ServerError
+	if err := call("wrong"); !errors.As(err, &serverErr) || serverErr.Code != xxcode.CodeUnauthenticated {
+		t.Fatalf("expect the connection to be rejected as unauthenticated, got %v", err)
+	}
+	if err := call(""); err == nil {
+		t.Fatal("expect an empty token to be rejected")
+	}
+
+	// 没有设置 auth func 时不校验，SetAuthFunc 应在开始服务之前调用，因此使用新的 Server
+	plain := server.NewServer()
+	_ = plain.Register(Baz(0))
+	plain.SetAuthFunc(nil)
+	addr = serveHTTP(t, plain)
+	if err := call(""); err != nil {
+		t.Fatalf("expect no auth check without an auth func, got %v", err)
+	}
+}
+
+func TestClient_MaxConcurrentRequests(t *testing.T) {
+	var b Busy
+	s := server.NewServer(&common.Option{MaxConcurrentRequests: 2})
+	_ = s.Register(&b)
+	client, err := DialHTTP("tcp", serveHTTP(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply int
+			if err := client.Call(context.Background(), "Busy.Work", 1, &reply); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak := atomic.LoadInt32(&b.peak); peak != 2 {
+		t.Fatalf("expect at most 2 handlers at once, got %d", peak)
+	}
+
+	// 拒绝模式下超出的请求立即失败
+	g := &Gate{entered: make(chan struct{}), release: make(chan struct{})}
+	s = server.NewServer(&common.Option{MaxConcurrentRequests: 1, RejectExcessRequests: true})
+	_ = s.Register(g)
+	client2, err := DialHTTP("tcp", serveHTTP(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client2.Close() }()
+	call := client2.Go("Gate.Wait", 1, new(int), nil)
+	<-g.entered
+	var reply int
+	if err = client2.Call(context.Background(), "Gate.Wait", 2, &reply); err == nil || !strings.Contains(err.Error(), "too many concurrent requests") {
+		t.Fatalf("expect the excess request to be rejected, got %v", err)
+	}
+	close(g.release)
+	if <-call.Done; call.Error != nil {
+		t.Fatal(call.Error)
+	}
+}
+
+func TestClient_RateLimit(t *testing.T) {
+	s := server.NewServer(&common.Option{RateLimit: 1, RateBurst: 3})
+	_ = s.Register(Baz(0))
+	addr := serveHTTP(t, s)
+	// 限流在同一个服务端的所有连接之间共享
+	var clients []*Client
+	for i := 0; i < 2; i++ {
+		client, err := DialHTTP("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = client.Close() }()
+		clients = append(clients, client)
+	}
+
+	var ok, limited int
+	for i := 0; i < 6; i++ {
+		var reply Reply
+		err := clients[i%2].Call(context.Background(), "Baz.Echo", i, &reply)
+		switch {
+		case err == nil:
+			ok++
+		case strings.Contains(err.Error(), "rate limited"):
+			limited++
+		default:
+			t.Fatal(err)
+		}
+	}
+	if ok != 3 || limited != 3 {
+		t.Fatalf("expect 3 calls within the burst and 3 rate limited, got %d and %d", ok, limited)
 	}
 }
@@ -1 +1,137 @@
 package client
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"xxrpc/registry"
+)
+
+// Discovery 提供候选的服务端地址，地址使用 XDial 的 protocol@addr 格式
+type Discovery interface {
+	Get(b Balancer) (string, error) // 通过 b 从候选地址中选出一个
+	GetAll() ([]string, error)      // 返回所有候选地址
+}
+
+// MultiServersDiscovery 是固定地址列表的 Discovery
+type MultiServersDiscovery struct {
+	mu      sync.RWMutex
+	servers []string
+}
+
+var _ Discovery = (*MultiServersDiscovery)(nil)
+
+// NewMultiServerDiscovery creates a MultiServersDiscovery instance
+func NewMultiServerDiscovery(servers []string) *MultiServersDiscovery {
+	return &MultiServersDiscovery{servers: append([]string(nil), servers...)}
+}
+
+// Update 替换地址列表
+func (d *MultiServersDiscovery) Update(servers []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.servers = append([]string(nil), servers...)
+}
+
+func (d *MultiServersDiscovery) Get(b Balancer) (string, error) {
+	servers, _ := d.GetAll()
+	return b.Next(servers)
+}
+
+func (d *MultiServersDiscovery) GetAll() ([]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]string(nil), d.servers...), nil
+}
+
+// RegistryDiscovery 从注册中心获取存活的服务端并缓存 ttl，
+// 缓存过期后在后台刷新，刷新期间继续返回旧的列表，只有第一次获取会阻塞。
+type RegistryDiscovery struct {
+	registry string // 注册中心的 URL
+	ttl      time.Duration
+
+	mu         sync.Mutex // protect following
+	servers    []string
+	lastUpdate time.Time
+	refreshing bool
+}
+
+var _ Discovery = (*RegistryDiscovery)(nil)
+
+const defaultUpdateTimeout = time.Second * 10
+
+// NewRegistryDiscovery 创建从 registryURL 获取服务端的 Discovery，ttl 为 0 时使用 10s
+func NewRegistryDiscovery(registryURL string, ttl time.Duration) *RegistryDiscovery {
+	if ttl == 0 {
+		ttl = defaultUpdateTimeout
+	}
+	return &RegistryDiscovery{registry: registryURL, ttl: ttl}
+}
+
+// fetch 向注册中心请求存活的服务端，最多等待 ttl，避免没有响应的注册中心使刷新一直无法结束
+func (d *RegistryDiscovery) fetch() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.ttl)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.registry, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("rpc discovery: unexpected registry response: " + resp.Status)
+	}
+	var servers []string
+	for _, s := range strings.Split(resp.Header.Get(registry.ServersHeader), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			servers = append(servers, s)
+		}
+	}
+	return servers, nil
+}
+
+// Refresh 立即从注册中心获取服务端列表
+func (d *RegistryDiscovery) Refresh() error {
+	servers, err := d.fetch()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.refreshing = false
+	if err != nil {
+		log.Println("rpc discovery: refresh err:", err)
+		return err
+	}
+	d.servers, d.lastUpdate = servers, time.Now()
+	return nil
+}
+
+func (d *RegistryDiscovery) Get(b Balancer) (string, error) {
+	servers, err := d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	return b.Next(servers)
+}
+
+func (d *RegistryDiscovery) GetAll() ([]string, error) {
+	d.mu.Lock()
+	if d.lastUpdate.IsZero() {
+		d.mu.Unlock()
+		if err := d.Refresh(); err != nil {
+			return nil, err
+		}
+		d.mu.Lock()
+	} else if !d.refreshing && d.lastUpdate.Add(d.ttl).Before(time.Now()) {
+		d.refreshing = true
+		go func() { _ = d.Refresh() }()
+	}
+	defer d.mu.Unlock()
+	return append([]string(nil), d.servers...), nil
+}
@@ -0,0 +1,30 @@
+package client
+
+import "context"
+
+// ClientInterceptor 拦截 Client.Call，invoker 执行下一个拦截器或真正的调用，
+// 拦截器可以不调用 invoker 而直接返回，此时请求不会被发送。
+// args 为指针时，拦截器可以在调用 invoker 之前修改其指向的值，请求中发送的是修改后的参数。
+// 拦截器只作用于同步的 Call 和 CallWithMeta，异步的 Go 与 Notify 不经过拦截器。
+type ClientInterceptor func(ctx context.Context, method string, args, reply interface{}, invoker func() error) error
+
+// Use 追加拦截器，先追加的拦截器在外层，最先执行。
+func (c *Client) Use(interceptors ...ClientInterceptor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interceptors = append(c.interceptors, interceptors...)
+}
+
+// intercept 依次通过所有拦截器后执行 invoker
+func (c *Client) intercept(ctx context.Context, method string, args, reply interface{}, invoker func() error) error {
+	c.mu.Lock()
+	interceptors := c.interceptors
+	c.mu.Unlock()
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		next, interceptor := invoker, interceptors[i]
+		invoker = func() error {
+			return interceptor(ctx, method, args, reply, next)
+		}
+	}
+	return invoker()
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"xxrpc/common"
+	"xxrpc/xxcode"
+)
+
+// CheckMethod 在调用之前通过服务端的自省服务确认 method 存在，并且参数和 reply 的类型与
+// argSample、replySample 一致。类型名包含包名，参数是否为指针不影响比较；
+// 服务端提供了 reply 类型的指纹时同时比较指纹，能够发现同名类型的字段差异。
+func (c *Client) CheckMethod(method string, argSample, replySample interface{}) error {
+	dot := strings.LastIndex(method, ".")
+	if dot < 0 {
+		return fmt.Errorf("rpc client: service/method request ill-formed: %s", method)
+	}
+	serviceName, methodName := method[:dot], method[dot+1:]
+	var reply common.ServicesReply
+	if err := c.Call(context.Background(), common.IntrospectionService+".ListServices", struct{}{}, &reply); err != nil {
+		return err
+	}
+	for _, svc := range reply.Services {
+		if svc.Name != serviceName {
+			continue
+		}
+		for _, m := range svc.Methods {
+			if m.Name == methodName {
+				return checkTypes(method, m, argSample, replySample)
+			}
+		}
+	}
+	return fmt.Errorf("rpc client: can't find method %s", method)
+}
+
+func checkTypes(method string, m common.MethodInfo, argSample, replySample interface{}) error {
+	argType := reflect.TypeOf(argSample)
+	if argType != nil && argType.Kind() == reflect.Ptr {
+		argType = argType.Elem()
+	}
+	if name := fmt.Sprint(argType); name != strings.TrimPrefix(m.ArgTypeName, "*") {
+		return fmt.Errorf("rpc client: arg type mismatch for %s: %s differs from the server's %s", method, name, m.ArgTypeName)
+	}
+	replyType := reflect.TypeOf(replySample)
+	if name := fmt.Sprint(replyType); name != m.ReplyTypeName {
+		return fmt.Errorf("rpc client: reply type mismatch for %s: %s differs from the server's %s", method, name, m.ReplyTypeName)
+	}
+	if m.ReplyHash != 0 && xxcode.TypeHash(replyType) != m.ReplyHash {
+		return fmt.Errorf("rpc client: reply type mismatch for %s: %s differs in fields from the server's", method, m.ReplyTypeName)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrKeepAliveTimeout 表示在 Option.KeepAliveTimeout 内没有收到服务端对 ping 的回复，连接被认为已经断开
+var ErrKeepAliveTimeout = errors.New("rpc client: keepalive timeout")
+
+// keepAlive 每隔 KeepAliveInterval 发送一次 ping，直到客户端被关闭。
+// 重连期间跳过 ping，重连成功后继续检测新的连接。
+func (c *Client) keepAlive() {
+	timeout := c.opt.KeepAliveTimeout
+	if timeout <= 0 {
+		timeout = c.opt.KeepAliveInterval
+	}
+	ticker := time.NewTicker(c.opt.KeepAliveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.mu.Lock()
+		closing, shutdown, reconnecting := c.closing, c.shutdown, c.reconnecting
+		c.mu.Unlock()
+		if closing || shutdown {
+			return
+		}
+		if reconnecting {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := c.Ping(ctx)
+		cancel()
+		if err != nil && ctx.Err() != nil {
+			c.keepAliveTimeout()
+		}
+	}
+}
+
+// keepAliveTimeout 以 ErrKeepAliveTimeout 结束所有未完成的调用并关闭连接。
+// 开启重连时由 receive 重新拨号，否则客户端不再可用。
+func (c *Client) keepAliveTimeout() {
+	c.mu.Lock()
+	reconnect := c.redial != nil
+	c.mu.Unlock()
+	if !reconnect {
+		c.terminateCalls(ErrKeepAliveTimeout)
+	} else {
+		c.sending.Lock()
+		c.mu.Lock()
+		for seq, call := range c.pending {
+			delete(c.pending, seq)
+			call.Error = ErrKeepAliveTimeout
+			call.done()
+		}
+		c.mu.Unlock()
+		c.sending.Unlock()
+	}
+	c.mu.Lock()
+	cc := c.cc
+	c.mu.Unlock()
+	_ = cc.Close()
+}
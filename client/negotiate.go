@@ -0,0 +1,59 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"xxrpc/common"
+	"xxrpc/xxcode"
+)
+
+// readHandshakeReply 读取服务端对 Option.AcceptCodecs 的 HandshakeReply，返回服务端选定的编解码器，
+// 以及之后供编解码器读写的连接。读取受 ConnectTimeout 限制，使重连时同样不会无限等待不支持协商的服务端。
+func readHandshakeReply(conn net.Conn, opt *common.Option) (xxcode.Type, io.ReadWriteCloser, error) {
+	if timeout := connectTimeout(opt); timeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+	}
+	// 客户端在收到回复之前不会发送请求，服务端也就不会在回复之后写入更多数据，
+	// 但 br 仍然作为连接的读取端交给编解码器，不丢失任何缓冲的字节
+	br := bufio.NewReader(conn)
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return "", nil, err
+	}
+	var reply common.HandshakeReply
+	if err = json.Unmarshal(line, &reply); err != nil {
+		return "", nil, err
+	}
+	if reply.Error != "" {
+		return "", nil, fmt.Errorf("%w: %w", ErrRejected, &ServerError{Message: reply.Error, Code: reply.ErrorCode})
+	}
+	if !containsCodec(opt.AcceptCodecs, reply.CodeType) || xxcode.CodeFunc(reply.CodeType) == nil {
+		return "", nil, fmt.Errorf("rpc client: server chose codec %q, expect one of %v", reply.CodeType, opt.AcceptCodecs)
+	}
+	return reply.CodeType, &bufferedConn{Conn: conn, r: br}, nil
+}
+
+func containsCodec(types []xxcode.Type, typ xxcode.Type) bool {
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedConn 从握手时使用的 r 继续读取 Conn
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,210 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"xxrpc/common"
+)
+
+// Pool 按 rpcAddr 缓存空闲的 Client，rpcAddr 的格式与 XDial 相同。
+// Get 取出一个可用的 Client，没有时再拨号；用完后通过 Put 放回。
+// Pool 可以被多个 goroutine 同时使用。
+type Pool struct {
+	mu         sync.Mutex
+	idle       map[string][]*idleClient // rpcAddr -> 空闲的 Client
+	maxIdle    int                      // 每个 rpcAddr 最多保留的空闲 Client 数
+	closed     bool
+	stopReaper chan struct{} // 关闭时停止 SetReaper 启动的 goroutine，nil 表示没有启动
+}
+
+// idleClient 是一个空闲的 Client 以及它被放回 Pool 的时间
+type idleClient struct {
+	client *Client
+	since  time.Time
+}
+
+// NewPool 创建一个 Pool，每个地址最多保留 maxIdle 个空闲 Client，
+// maxIdle <= 0 时不保留空闲连接，Put 会直接关闭 Client。
+func NewPool(maxIdle int) *Pool {
+	return &Pool{idle: make(map[string][]*idleClient), maxIdle: maxIdle}
+}
+
+// Get 返回 rpcAddr 对应的一个可用 Client，已经断开的空闲 Client 会被关闭并丢弃，
+// 没有可用的空闲 Client 时使用 opts 重新拨号。
+func (p *Pool) Get(rpcAddr string, opts ...*common.Option) (*Client, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrShutdown
+	}
+	var stale []*Client
+	var client *Client
+	clients := p.idle[rpcAddr]
+	for len(clients) > 0 && client == nil {
+		c := clients[len(clients)-1].client
+		clients = clients[:len(clients)-1]
+		if c.IsAvailable() {
+			client = c
+		} else {
+			stale = append(stale, c)
+		}
+	}
+	p.idle[rpcAddr] = clients
+	p.mu.Unlock()
+
+	// 关闭连接后 receive goroutine 随之退出
+	for _, c := range stale {
+		_ = c.Close()
+	}
+	if client != nil {
+		return client, nil
+	}
+	return XDial(rpcAddr, opts...)
+}
+
+// Put 将 Get 得到的 Client 放回 rpcAddr 的空闲列表，
+// Client 不可用、空闲数已满或 Pool 已关闭时关闭该 Client。
+func (p *Pool) Put(rpcAddr string, client *Client) {
+	p.mu.Lock()
+	if !p.closed && client.IsAvailable() && len(p.idle[rpcAddr]) < p.maxIdle {
+		p.idle[rpcAddr] = append(p.idle[rpcAddr], &idleClient{client: client, since: time.Now()})
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	_ = client.Close()
+}
+
+// Warmup 预先为 rpcAddr 拨号 n 个连接并放入空闲列表，使之后的 Get 无需等待拨号和握手。
+// 每个连接都先经过 Ping 确认可用，超出 maxIdle 的部分不会拨号。
+func (p *Pool) Warmup(ctx context.Context, rpcAddr string, n int, opts ...*common.Option) error {
+	p.mu.Lock()
+	if n > p.maxIdle-len(p.idle[rpcAddr]) {
+		n = p.maxIdle - len(p.idle[rpcAddr])
+	}
+	p.mu.Unlock()
+	for i := 0; i < n; i++ {
+		client, err := XDial(rpcAddr, opts...)
+		if err != nil {
+			return err
+		}
+		if err = client.Ping(ctx); err != nil {
+			_ = client.Close()
+			return err
+		}
+		p.Put(rpcAddr, client)
+	}
+	return nil
+}
+
+// Close 关闭所有空闲的 Client，之后的 Get 返回 ErrShutdown，Put 会直接关闭 Client。
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrShutdown
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	if p.stopReaper != nil {
+		close(p.stopReaper)
+		p.stopReaper = nil
+	}
+	p.mu.Unlock()
+
+	for _, clients := range idle {
+		for _, c := range clients {
+			_ = c.client.Close()
+		}
+	}
+	return nil
+}
+
+// SetReaper 启动一个后台 goroutine，每隔 interval 检查一次空闲的 Client：
+// 空闲超过 idleTimeout 的 Client 被关闭，其余的发送一次 Ping，interval 内没有响应的同样被关闭，
+// 这样服务端或中间设备静默丢弃的连接不会在下一次 Get 时才暴露出来。
+// idleTimeout <= 0 表示不按空闲时间淘汰，interval <= 0 时停止检查。Close 会停止这个 goroutine。
+func (p *Pool) SetReaper(interval, idleTimeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopReaper != nil {
+		close(p.stopReaper)
+		p.stopReaper = nil
+	}
+	if p.closed || interval <= 0 {
+		return
+	}
+	p.stopReaper = make(chan struct{})
+	go p.reap(interval, idleTimeout, p.stopReaper)
+}
+
+func (p *Pool) reap(interval, idleTimeout time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			p.reapOnce(interval, idleTimeout)
+		}
+	}
+}
+
+// reapOnce 检查当前所有空闲的 Client，淘汰空闲过久或 Ping 失败的那些
+func (p *Pool) reapOnce(pingTimeout, idleTimeout time.Duration) {
+	p.mu.Lock()
+	var checked []*idleClient
+	for _, clients := range p.idle {
+		checked = append(checked, clients...)
+	}
+	p.mu.Unlock()
+
+	// Ping 期间不持有锁，Get 仍然可以取出正在检查的 Client
+	dead := make(map[*idleClient]bool)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, ic := range checked {
+		if idleTimeout > 0 && time.Since(ic.since) > idleTimeout {
+			dead[ic] = true
+			continue
+		}
+		wg.Add(1)
+		go func(ic *idleClient) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+			defer cancel()
+			if err := ic.client.Ping(ctx); err != nil {
+				mu.Lock()
+				dead[ic] = true
+				mu.Unlock()
+			}
+		}(ic)
+	}
+	wg.Wait()
+	if len(dead) == 0 {
+		return
+	}
+
+	// 只关闭仍在空闲列表中的 Client，检查期间被 Get 取走的交给使用者处理
+	var evicted []*Client
+	p.mu.Lock()
+	for rpcAddr, clients := range p.idle {
+		kept := clients[:0]
+		for _, ic := range clients {
+			if dead[ic] {
+				evicted = append(evicted, ic.client)
+			} else {
+				kept = append(kept, ic)
+			}
+		}
+		p.idle[rpcAddr] = kept
+	}
+	p.mu.Unlock()
+	for _, c := range evicted {
+		_ = c.Close()
+	}
+}
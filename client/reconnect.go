@@ -0,0 +1,119 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"xxrpc/common"
+	"xxrpc/xxcode"
+)
+
+// ErrReconnecting 表示连接已断开，客户端正在重新拨号，调用没有被发送
+var ErrReconnecting = errors.New("rpc client: reconnecting")
+
+const (
+	minReconnectBackoff     = time.Millisecond * 100
+	defaultReconnectBackoff = time.Second * 10
+)
+
+// redialed 是重新拨号建立的连接
+type redialed struct {
+	cc       xxcode.Code
+	codeType xxcode.Type
+	tls      bool
+}
+
+// enableReconnect 在 Option.Reconnect 开启时记住拨号参数，connect 在 Option 握手之前对新连接做协议切换，可以为 nil
+func (c *Client) enableReconnect(network, address string, connect func(net.Conn, *common.Option) (net.Conn, error)) {
+	if !c.opt.Reconnect {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.redial = func() (*redialed, error) {
+		conn, err := net.DialTimeout(network, address, connectTimeout(c.opt))
+		if err != nil {
+			return nil, err
+		}
+		if connect != nil {
+			raw := conn
+			if conn, err = connect(conn, c.opt); err != nil {
+				_ = raw.Close()
+				return nil, err
+			}
+		}
+		cc, typ, err := newCode(conn, c.opt)
+		if err != nil {
+			return nil, err
+		}
+		_, isTLS := conn.(*tls.Conn)
+		return &redialed{cc: cc, codeType: typ, tls: isTLS}, nil
+	}
+}
+
+// reconnect 在连接出错后按指数退避重新拨号，成功时替换 c.cc 并返回 true。
+// 断开时未完成的调用以 err 结束，重连期间的调用返回 ErrReconnecting；用户 Close 后放弃重连。
+func (c *Client) reconnect(err error) bool {
+	c.sending.Lock()
+	c.mu.Lock()
+	redial := c.redial
+	if redial == nil || c.closing {
+		c.mu.Unlock()
+		c.sending.Unlock()
+		return false
+	}
+	c.reconnecting = true
+	_ = c.cc.Close()
+	for seq, call := range c.pending {
+		delete(c.pending, seq)
+		call.Error = err
+		call.done()
+	}
+	c.mu.Unlock()
+	c.sending.Unlock()
+
+	maxBackoff := c.opt.MaxReconnectBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultReconnectBackoff
+	}
+	backoff := minReconnectBackoff
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	for {
+		rd, derr := redial()
+		if derr == nil {
+			c.sending.Lock()
+			c.mu.Lock()
+			defer c.sending.Unlock()
+			defer c.mu.Unlock()
+			if c.closing {
+				_ = rd.cc.Close()
+				xxcode.ReleaseCode(rd.cc)
+				return false
+			}
+			if c.ownCode {
+				xxcode.ReleaseCode(c.cc) // 旧连接的读写都已经结束
+			}
+			c.cc, c.codeType, c.tls, c.reconnecting, c.ownCode = rd.cc, rd.codeType, rd.tls, false, true
+			return true
+		}
+		c.logger().Println("rpc client: reconnect error:", derr)
+
+		time.Sleep(backoff)
+		if c.isClosing() {
+			return false
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (c *Client) isClosing() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closing
+}
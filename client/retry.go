@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"xxrpc/xxcode"
+)
+
+// RetryPolicy 控制 CallRetry 的重试次数和退避时间，只应用于幂等的方法。
+type RetryPolicy struct {
+	MaxAttempts int           // 包括第一次在内的最大尝试次数，<= 1 时不重试
+	Backoff     time.Duration // 第一次重试前的等待时间，之后每次翻倍
+	MaxBackoff  time.Duration // 等待时间的上限，0 表示不限制
+}
+
+// CallRetry 与 Call 相同，但在连接断开、超时等暂时性错误时按 policy 退避重试，
+// 每次等待在 [d/2, d) 之间随机抖动。所有尝试共用 ctx 的截止时间，全部失败时返回最后一次的错误。
+func (c *Client) CallRetry(ctx context.Context, serviceMethod string, args, reply interface{}, policy RetryPolicy, opts ...CallOption) error {
+	backoff := policy.Backoff
+	for attempt := 1; ; attempt++ {
+		err := c.Call(ctx, serviceMethod, args, reply, opts...)
+		if err == nil || attempt >= policy.MaxAttempts || ctx.Err() != nil || !isTransient(err) {
+			return err
+		}
+		if backoff > 0 {
+			wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+			if backoff *= 2; policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+				backoff = policy.MaxBackoff
+			}
+		}
+	}
+}
+
+// isTransient 判断 err 是否可能在重试后消失：连接不可用、读取中断、网络错误以及服务端的超时和繁忙
+func isTransient(err error) bool {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrShutdown), errors.Is(err, ErrReconnecting), errors.Is(err, ErrPartialReply),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &netErr):
+		return true
+	}
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) && (serverErr.Code == xxcode.CodeTimeout || serverErr.Code == xxcode.CodeUnavailable) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "rpc server: request handle timeout") || strings.Contains(msg, "rpc server: server busy")
+}
@@ -0,0 +1,42 @@
+package client
+
+import "sync/atomic"
+
+// Stats 是一个 Client 上调用的统计，ping 控制帧不计入 Sent、Completed 和 Errored
+type Stats struct {
+	Sent      uint64 // 请求已写入连接的调用数
+	Completed uint64 // 成功结束的调用数
+	Errored   uint64 // 以错误结束的调用数，包括发送失败、服务端报错、连接断开和 ctx 结束后放弃的调用
+	Pending   int    // 正在等待响应的调用数，见 NumPending
+}
+
+// callStats 记录 Stats 中的计数，由 Client 和它创建的 Call 共享
+type callStats struct {
+	sent, completed, errored uint64
+}
+
+// finish 按调用的结果计数
+func (s *callStats) finish(err error) {
+	if err != nil {
+		atomic.AddUint64(&s.errored, 1)
+	} else {
+		atomic.AddUint64(&s.completed, 1)
+	}
+}
+
+// NumPending 返回已经发送、正在等待响应的调用数，包括 keepalive 的 ping
+func (c *Client) NumPending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// Stats 返回客户端创建以来的调用统计，各项计数分别读取，并发调用时彼此之间不保证一致
+func (c *Client) Stats() Stats {
+	return Stats{
+		Sent:      atomic.LoadUint64(&c.stats.sent),
+		Completed: atomic.LoadUint64(&c.stats.completed),
+		Errored:   atomic.LoadUint64(&c.stats.errored),
+		Pending:   c.NumPending(),
+	}
+}
@@ -1 +1,236 @@
 package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+
+	"xxrpc/common"
+)
+
+// XClient 将调用通过 Balancer 分发到 Discovery 提供的多个服务端，rpcAddr 的格式与 XDial 相同。
+// 每个地址的 Client 在第一次使用时拨号并缓存，不可用时重新拨号。
+type XClient struct {
+	opt      *common.Option
+	balancer Balancer
+	d        Discovery
+	mu       sync.Mutex         // protect following
+	clients  map[string]*Client // rpcAddr -> 缓存的 Client
+	servers  []string           // 最近一次从 d 获取的地址，见 prune
+
+	idempotent  map[string]bool // 可以换到其他服务端重试的方法，见 SetIdempotent
+	maxAttempts int             // Call 最多尝试的服务端个数，0 表示服务端的个数
+}
+
+var _ io.Closer = (*XClient)(nil)
+
+// NewXClient 创建分发到 servers 的 XClient，balancer 为 nil 时使用 RandomSelect，
+// opt 用于拨号每个服务端，可以为 nil
+func NewXClient(servers []string, balancer Balancer, opt *common.Option) *XClient {
+	return NewDiscoveryXClient(NewMultiServerDiscovery(servers), balancer, opt)
+}
+
+// NewDiscoveryXClient 与 NewXClient 相同，但每次调用时从 d 获取候选的服务端
+func NewDiscoveryXClient(d Discovery, balancer Balancer, opt *common.Option) *XClient {
+	if balancer == nil {
+		balancer = RandomSelect{}
+	}
+	return &XClient{
+		opt:      opt,
+		balancer: balancer,
+		d:        d,
+		clients:  make(map[string]*Client),
+	}
+}
+
+// SetIdempotent 将 serviceMethods 标记为幂等，Call 遇到暂时性错误时会换到其他服务端重试这些方法
+func (xc *XClient) SetIdempotent(serviceMethods ...string) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if xc.idempotent == nil {
+		xc.idempotent = make(map[string]bool)
+	}
+	for _, m := range serviceMethods {
+		xc.idempotent[m] = true
+	}
+}
+
+// SetMaxAttempts 设置一次 Call 最多尝试的服务端个数，n <= 0 表示服务端的个数
+func (xc *XClient) SetMaxAttempts(n int) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.maxAttempts = n
+}
+
+// next 通过 balancer 从没有失败过的服务端中选出下一个地址，都失败过时从全部服务端中选择
+func (xc *XClient) next(servers []string, failed map[string]bool) (string, error) {
+	if len(failed) > 0 {
+		candidates := make([]string, 0, len(servers))
+		for _, s := range servers {
+			if !failed[s] {
+				candidates = append(candidates, s)
+			}
+		}
+		if len(candidates) > 0 {
+			servers = candidates
+		}
+	}
+	return xc.balancer.Next(servers)
+}
+
+// prune 在 d 返回的地址变化时关闭并删除不再列出的服务端的 Client
+func (xc *XClient) prune(servers []string) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if reflect.DeepEqual(servers, xc.servers) {
+		return
+	}
+	xc.servers = append([]string(nil), servers...)
+	listed := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		listed[s] = true
+	}
+	for rpcAddr, client := range xc.clients {
+		if !listed[rpcAddr] {
+			_ = client.Close()
+			delete(xc.clients, rpcAddr)
+		}
+	}
+}
+
+// dial 返回 rpcAddr 缓存的 Client，缓存的 Client 不可用时关闭并重新拨号。
+// 拨号时不持有 mu，以免一个无法连接的服务端阻塞其他服务端的调用；
+// 同时拨号同一个地址时保留先存入的 Client，关闭其余的。
+func (xc *XClient) dial(rpcAddr string) (*Client, error) {
+	xc.mu.Lock()
+	client, ok := xc.clients[rpcAddr]
+	if ok && client.IsAvailable() {
+		xc.mu.Unlock()
+		return client, nil
+	}
+	if ok {
+		_ = client.Close()
+		delete(xc.clients, rpcAddr)
+	}
+	xc.mu.Unlock()
+
+	client, err := XDial(rpcAddr, xc.opt)
+	if err != nil {
+		return nil, err
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if cached, ok := xc.clients[rpcAddr]; ok && cached.IsAvailable() {
+		_ = client.Close()
+		return cached, nil
+	} else if ok {
+		_ = cached.Close()
+	}
+	xc.clients[rpcAddr] = client
+	return client, nil
+}
+
+// Call 在 balancer 选中的服务端上调用命名的函数，选中的服务端无法连接时换一个服务端。
+// 通过 SetIdempotent 标记的方法遇到连接断开、超时等暂时性错误时同样换到其他服务端重试，
+// 其他方法的调用错误直接返回。最多尝试 SetMaxAttempts 个服务端，且不超过 ctx 的截止时间，
+// 全部失败时返回最后一次的错误。
+func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return err
+	}
+	xc.prune(servers)
+	xc.mu.Lock()
+	attempts := len(servers)
+	if xc.maxAttempts > 0 {
+		attempts = xc.maxAttempts
+	}
+	idempotent := xc.idempotent[serviceMethod]
+	xc.mu.Unlock()
+	err = errNoServers
+	failed := make(map[string]bool)
+	for i := 0; i < attempts; i++ {
+		if i > 0 && ctx.Err() != nil {
+			break // 截止时间已过，返回上一次的错误
+		}
+		var rpcAddr string
+		if rpcAddr, err = xc.next(servers, failed); err != nil {
+			return err
+		}
+		var client *Client
+		if client, err = xc.dial(rpcAddr); err != nil {
+			failed[rpcAddr] = true
+			continue
+		}
+		err = client.Call(ctx, serviceMethod, args, reply)
+		if err == nil || !idempotent || !isTransient(err) {
+			return err
+		}
+		failed[rpcAddr] = true
+	}
+	return err
+}
+
+// Broadcast 并发地在所有服务端上调用命名的函数，返回第一个错误并取消其余未完成的调用。
+// 全部成功时 reply 为其中一个服务端的结果，各服务端的结果应当是等价的。
+func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return err
+	}
+	xc.prune(servers)
+	if len(servers) == 0 {
+		return errNoServers
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex // protect e and replyDone
+	var e error
+	replyDone := reply == nil // if reply is nil, don't need to set value
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for _, rpcAddr := range servers {
+		wg.Add(1)
+		go func(rpcAddr string) {
+			defer wg.Done()
+			// 每个调用使用独立的 reply，避免并发写入同一个对象
+			var clonedReply interface{}
+			if reply != nil {
+				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+			}
+			client, err := xc.dial(rpcAddr)
+			if err == nil {
+				err = client.Call(ctx, serviceMethod, args, clonedReply)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && e == nil {
+				e = err
+				cancel() // if any call failed, cancel unfinished calls
+			}
+			if err == nil && !replyDone {
+				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(clonedReply).Elem())
+				replyDone = true
+			}
+		}(rpcAddr)
+	}
+	wg.Wait()
+	return e
+}
+
+// Close 关闭所有缓存的 Client，返回第一个关闭错误。
+// 已经被关闭的 Client 返回的 ErrShutdown 不算作错误。
+func (xc *XClient) Close() error {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	var first error
+	for key, client := range xc.clients {
+		if err := client.Close(); err != nil && !errors.Is(err, ErrShutdown) && first == nil {
+			first = err
+		}
+		delete(xc.clients, key)
+	}
+	return first
+}
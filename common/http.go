@@ -1,7 +1,10 @@
 package common
 
 const (
-	Connected        = "200 Connected to Gee RPC"
-	DefaultRPCPath   = "/_xxrpc_"
-	DefaultDebugPath = "/debug/xxrpc"
+	Connected          = "200 Connected to Gee RPC"
+	DefaultRPCPath     = "/_xxrpc_"
+	DefaultDebugPath   = "/debug/xxrpc"
+	DefaultUnaryPath   = "/_xxrpc_/call/" // POST {DefaultUnaryPath}Service.Method
+	DefaultHealthPath  = "/_xxrpc_/health"
+	DefaultMetricsPath = "/debug/xxrpc/metrics"
 )
@@ -0,0 +1,23 @@
+package common
+
+// IntrospectionService 是服务端内置的自省服务的名称，通过 "Introspection.ListServices" 调用
+const IntrospectionService = "Introspection"
+
+// MethodInfo 描述一个已注册的方法
+type MethodInfo struct {
+	Name          string
+	ArgTypeName   string // 参数类型，例如 main.Args
+	ReplyTypeName string // reply 类型，例如 *int
+	ReplyHash     uint64 // reply 类型的指纹，见 xxcode.TypeHash
+}
+
+// ServiceInfo 描述一个已注册的服务，Methods 按名称排序
+type ServiceInfo struct {
+	Name    string
+	Methods []MethodInfo
+}
+
+// ServicesReply 是 ListServices 的返回值，Services 按名称排序
+type ServicesReply struct {
+	Services []ServiceInfo
+}
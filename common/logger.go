@@ -0,0 +1,18 @@
+package common
+
+import "log"
+
+// Logger receives the logs of clients and servers. *log.Logger implements it, and so can
+// adapters for structured loggers.
+type Logger interface {
+	Printf(format string, v ...interface{})
+	Println(v ...interface{})
+}
+
+// DefaultLogger returns logger, or the standard logger when logger is nil.
+func DefaultLogger(logger Logger) Logger {
+	if logger == nil {
+		return log.Default()
+	}
+	return logger
+}
@@ -1,6 +1,8 @@
 package common
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"xxrpc/xxcode"
@@ -8,15 +10,182 @@ import (
 
 const MagicNumber = 0x3bef5c
 
+// ProtocolVersion is the version of the framing spoken after the handshake. A server accepts
+// versions from MinProtocolVersion up to ProtocolVersion and rejects the others, so a change
+// to the framing or codecs bumps ProtocolVersion instead of failing cryptically on old peers.
+//
+// Version 2 lets the gob codec write []byte bodies verbatim, see RawBytesVersion.
+const (
+	ProtocolVersion    = 2
+	MinProtocolVersion = 1
+)
+
+// RawBytesVersion is the first protocol version whose peers write []byte bodies without gob
+// encoding them, see xxcode.SetRawBytes. Both sides can read such bodies from version 2 on,
+// so each side turns it on once it knows the other speaks at least this version.
+const RawBytesVersion = 2
+
+// NoTimeout set as ConnectTimeout or HandleTimeout explicitly asks for no limit,
+// while a zero value is filled in from DefaultOption by the client.
+const NoTimeout time.Duration = -1
+
 type Option struct {
 	MagicNumber    int           // MagicNumber marks this is a rpc request
 	CodeType       xxcode.Type   // client may choose different Codec to encode body
-	ConnectTimeout time.Duration // 0 means no limit
-	HandleTimeout  time.Duration
+	ConnectTimeout time.Duration // 0 means DefaultOption.ConnectTimeout, NoTimeout means no limit
+	HandleTimeout  time.Duration // 0 means DefaultOption.HandleTimeout, NoTimeout means no limit
+	// ProtocolVersion is the protocol version the client speaks, 0 means ProtocolVersion.
+	// Servers treat 0 as version 1, sent by clients that predate the field.
+	ProtocolVersion int
+	// OrderedProcessing makes the server handle this connection's requests one at a time,
+	// in the order they were sent, instead of concurrently. A request that exceeds
+	// HandleTimeout stops holding up the connection once its timeout response is sent.
+	OrderedProcessing bool
+	// AuthToken is checked by the server's auth func, see Server.SetAuthFunc.
+	AuthToken string
+	// AcceptCodecs lists the codecs the client can use, most preferred first, for example
+	// from xxcode.PreferCodecs. When set the server picks the first one it supports instead
+	// of CodeType and answers the Option with a HandshakeReply before the codec takes over.
+	// Servers that predate negotiation never answer, so the dial fails after ConnectTimeout.
+	AcceptCodecs []xxcode.Type `json:",omitempty"`
+	// Logger receives the logs of the client or server using this Option, nil means the
+	// standard logger. Server.SetLogger overrides it for a server.
+	Logger Logger `json:"-"`
+	// Tracer records a span for every call a client sends or a server handles, linked by
+	// the trace ID carried in the request metadata, see TraceIDKey. nil disables tracing.
+	Tracer Tracer `json:"-"`
+	// Compression compresses bodies of at least xxcode.CompressThreshold bytes in both
+	// directions with the named algorithm, "" or xxcode.CompressNone for none, or xxcode.CompressGzip.
+	Compression string
+
+	// PipelineHandshake lets DialHTTP send the Option and first requests without waiting
+	// for the CONNECT response, saving a round trip. It only affects the client.
+	PipelineHandshake bool `json:"-"`
+	// CheckReplyType makes the client reject replies whose type fingerprint differs from
+	// the reply it decodes into, catching schema skew instead of silently mis-mapping fields.
+	CheckReplyType bool `json:"-"`
+	// Reconnect makes a client created by Dial or DialHTTP re-dial the same address with
+	// exponential backoff, capped at MaxReconnectBackoff (0 means 10s), after the connection
+	// breaks. Calls pending at that moment fail; calls made while reconnecting fail fast.
+	Reconnect           bool          `json:"-"`
+	MaxReconnectBackoff time.Duration `json:"-"`
+	// KeepAliveInterval makes the client ping the server every KeepAliveInterval. When no pong
+	// arrives within KeepAliveTimeout (0 means KeepAliveInterval), pending calls fail with a
+	// keepalive timeout and the connection is closed. 0 disables keepalive.
+	KeepAliveInterval time.Duration `json:"-"`
+	KeepAliveTimeout  time.Duration `json:"-"`
+	// MaxResponseBytes caps the encoded size of each response the client reads. A response
+	// over it fails its call with ErrPartialReply, or all pending calls when the header alone
+	// is too large, and breaks the connection. 0 means no limit.
+	MaxResponseBytes int64 `json:"-"`
+
+	// The fields below configure a server and are never sent over the wire.
+	MaxInflightBytes int64                  `json:"-"` // budget for decoded args held by running handlers, 0 means no limit; it doesn't bound decoding, see MaxRequestBytes
+	ErrorRedactor    func(err error) string `json:"-"` // maps handler errors to client-facing messages, nil passes them through
+	FallbackCodecs   []xxcode.Type          `json:"-"` // codecs tried in order when the first request doesn't decode with the declared one
+	// SupportedCodecs restricts the codecs a server accepts, both as CodeType and when
+	// negotiating AcceptCodecs; nil means every codec the server can create, including those
+	// added by Server.RegisterCodec. A client declaring another codec is rejected with
+	// "unsupported codec".
+	SupportedCodecs []xxcode.Type `json:"-"`
+	// MaxConcurrentStreams caps the streams a single HTTP/2 connection may have in flight
+	// in Server.HTTP2Handler; streams beyond it fail with "too many streams". 0 means no limit.
+	MaxConcurrentStreams int `json:"-"`
+
+	// OnRunaway reports handlers still running RunawayThreshold after they started,
+	// typically well past HandleTimeout. The goroutine can't be stopped, only surfaced.
+	RunawayThreshold time.Duration                                                 `json:"-"`
+	OnRunaway        func(serviceMethod string, seq uint64, elapsed time.Duration) `json:"-"`
+
+	// MaxConcurrentRequests caps the handlers running at once for a single connection.
+	// Requests over the cap wait, blocking the connection's read loop, or are rejected with
+	// "too many concurrent requests" when RejectExcessRequests is set. 0 means no limit.
+	MaxConcurrentRequests int  `json:"-"`
+	RejectExcessRequests  bool `json:"-"`
+
+	// RateLimit throttles requests across all connections of a server to RateLimit per
+	// second with bursts of up to RateBurst (at least 1). Requests without a token fail with
+	// "rate limited" instead of running. 0 means no limit.
+	RateLimit float64 `json:"-"`
+	RateBurst int     `json:"-"`
+
+	// RecoverPanics turns a panicking handler into an error response instead of crashing
+	// the process. nil means true; point it at false to let panics propagate.
+	RecoverPanics *bool `json:"-"`
+
+	// ReadTimeout limits reading the handshake and then each request, including the wait for
+	// the next one, so a peer that stalls or idles longer than ReadTimeout gets its connection
+	// closed. WriteTimeout limits writing each response; a response that can't be written in
+	// time, typically because the client stopped reading, closes the connection and frees the
+	// handlers waiting to respond on it. They apply to connections with deadlines, such as
+	// net.Conn, and 0 means no limit.
+	ReadTimeout  time.Duration `json:"-"`
+	WriteTimeout time.Duration `json:"-"`
+
+	// MaxRequestBytes caps the encoded size of each request a server reads, header and body
+	// together. A request over it gets a "message too large" error response and its
+	// connection is closed, since the rest of the stream can't be realigned. Length-prefixed
+	// codecs check the declared size before allocating. 0 means no limit.
+	MaxRequestBytes int64 `json:"-"`
+
+	// DisableIntrospection hides the built-in "Introspection" service, which otherwise
+	// lets any client list the registered services and methods.
+	DisableIntrospection bool `json:"-"`
+}
+
+// HandshakeReply is written by the server as JSON right after an Option carrying
+// AcceptCodecs, in place of the RejectServiceMethod frame when the handshake fails.
+type HandshakeReply struct {
+	CodeType  xxcode.Type   `json:"code_type,omitempty"`  // the codec chosen for the connection
+	Codecs    []xxcode.Type `json:"codecs,omitempty"`     // every codec the server supports
+	Error     string        `json:"error,omitempty"`      // why the server rejected the handshake
+	ErrorCode int           `json:"error_code,omitempty"` // classifies Error, see xxcode.CodeNotFound
 }
 
 var DefaultOption = &Option{
-	MagicNumber:    MagicNumber,
-	CodeType:       xxcode.Type_Gob,
-	ConnectTimeout: time.Second * 10,
+	MagicNumber:     MagicNumber,
+	CodeType:        xxcode.Type_Gob,
+	ConnectTimeout:  time.Second * 10,
+	ProtocolVersion: ProtocolVersion,
+}
+
+// ErrInvalidOption is wrapped by the errors of Option.Validate.
+var ErrInvalidOption = errors.New("invalid option")
+
+// Validate reports the first field of opt that can't work, naming it in an error wrapping
+// ErrInvalidOption: a wrong MagicNumber, a CodeType missing from the codec registry, see
+// xxcode.CodeFunc, or a negative timeout other than NoTimeout where that is allowed.
+// NewClient and Server.ServeConn call it instead of failing later at use time.
+func (opt *Option) Validate() error {
+	return opt.ValidateCodecs(xxcode.CodeFunc)
+}
+
+// ValidateCodecs is Validate but looks CodeType up with codecFunc, so a server can check it
+// against the codecs added by Server.RegisterCodec.
+func (opt *Option) ValidateCodecs(codecFunc func(xxcode.Type) xxcode.NewCodeFunc) error {
+	if opt.MagicNumber != MagicNumber {
+		return fmt.Errorf("%w: MagicNumber %#x, expect %#x", ErrInvalidOption, opt.MagicNumber, MagicNumber)
+	}
+	if codecFunc(opt.CodeType) == nil {
+		return fmt.Errorf("%w: CodeType %q is not a registered codec", ErrInvalidOption, opt.CodeType)
+	}
+	for _, d := range []struct {
+		name      string
+		value     time.Duration
+		noTimeout bool // NoTimeout is a valid value
+	}{
+		{"ConnectTimeout", opt.ConnectTimeout, true},
+		{"HandleTimeout", opt.HandleTimeout, true},
+		{"MaxReconnectBackoff", opt.MaxReconnectBackoff, false},
+		{"KeepAliveInterval", opt.KeepAliveInterval, false},
+		{"KeepAliveTimeout", opt.KeepAliveTimeout, false},
+		{"RunawayThreshold", opt.RunawayThreshold, false},
+		{"ReadTimeout", opt.ReadTimeout, false},
+		{"WriteTimeout", opt.WriteTimeout, false},
+	} {
+		if d.value < 0 && !(d.noTimeout && d.value == NoTimeout) {
+			return fmt.Errorf("%w: %s %s is negative", ErrInvalidOption, d.name, d.value)
+		}
+	}
+	return nil
 }
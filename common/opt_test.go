@@ -0,0 +1,58 @@
+package common
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"xxrpc/xxcode"
+)
+
+func TestOption_Validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		opt   Option
+		field string // "" means valid
+	}{
+		{"default", *DefaultOption, ""},
+		{"no timeout", Option{MagicNumber: MagicNumber, CodeType: xxcode.Type_Json, ConnectTimeout: NoTimeout, HandleTimeout: NoTimeout}, ""},
+		{"magic number", Option{MagicNumber: 0x123, CodeType: xxcode.Type_Gob}, "MagicNumber"},
+		{"empty codec", Option{MagicNumber: MagicNumber}, "CodeType"},
+		{"unknown codec", Option{MagicNumber: MagicNumber, CodeType: "application/x-unknown"}, "CodeType"},
+		{"connect timeout", Option{MagicNumber: MagicNumber, CodeType: xxcode.Type_Gob, ConnectTimeout: -time.Second}, "ConnectTimeout"},
+		{"handle timeout", Option{MagicNumber: MagicNumber, CodeType: xxcode.Type_Gob, HandleTimeout: -time.Second}, "HandleTimeout"},
+		{"reconnect backoff", Option{MagicNumber: MagicNumber, CodeType: xxcode.Type_Gob, MaxReconnectBackoff: NoTimeout}, "MaxReconnectBackoff"},
+		{"keepalive interval", Option{MagicNumber: MagicNumber, CodeType: xxcode.Type_Gob, KeepAliveInterval: -time.Second}, "KeepAliveInterval"},
+		{"keepalive timeout", Option{MagicNumber: MagicNumber, CodeType: xxcode.Type_Gob, KeepAliveTimeout: -time.Second}, "KeepAliveTimeout"},
+		{"runaway threshold", Option{MagicNumber: MagicNumber, CodeType: xxcode.Type_Gob, RunawayThreshold: -time.Second}, "RunawayThreshold"},
+		{"read timeout", Option{MagicNumber: MagicNumber, CodeType: xxcode.Type_Gob, ReadTimeout: -time.Second}, "ReadTimeout"},
+		{"write timeout", Option{MagicNumber: MagicNumber, CodeType: xxcode.Type_Gob, WriteTimeout: -time.Second}, "WriteTimeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opt.Validate()
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("expect a valid option, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidOption) || !strings.Contains(err.Error(), tt.field) {
+				t.Fatalf("expect an error naming %s, got %v", tt.field, err)
+			}
+		})
+	}
+
+	// ValidateCodecs 使用调用方的注册表
+	custom := Option{MagicNumber: MagicNumber, CodeType: "application/x-custom"}
+	lookup := func(t xxcode.Type) xxcode.NewCodeFunc {
+		if t == "application/x-custom" {
+			return xxcode.NewGobCode
+		}
+		return nil
+	}
+	if err := custom.ValidateCodecs(lookup); err != nil {
+		t.Fatalf("expect the custom codec to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// TraceIDKey is the Header.Metadata key carrying the trace ID of a call. A client sends the
+// trace ID with every request, so the spans of the server handling it share the client's ID.
+const TraceIDKey = "trace-id"
+
+// Tracer starts spans around calls. A client starts a span for each call it sends and
+// finishes it with the call's error once the reply arrives or the call is abandoned; a
+// server starts one for each request it handles and finishes it after the response is
+// written. name is "<service>.<method>" and traceID links the spans of one call. The
+// returned context is passed on to the handler on the server and ignored on the client.
+// Implementations must be safe for concurrent use.
+type Tracer interface {
+	StartSpan(ctx context.Context, name, traceID string) (context.Context, Span)
+}
+
+// Span is a unit of work started by a Tracer.
+type Span interface {
+	Finish(err error)
+}
+
+type traceIDKey struct{}
+
+// WithTraceID returns a copy of ctx carrying traceID. Calls made by a client with the
+// context send traceID unless their metadata already has one.
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceID returns the trace ID in ctx, "" if there is none. A server puts the trace ID of
+// each request in the handler's context, so calls made with it continue the same trace.
+func TraceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+// NewTraceID returns a random 32-digit hex trace ID.
+func NewTraceID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
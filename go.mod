@@ -1,3 +1,10 @@
 module xxrpc
 
 go 1.20
+
+require google.golang.org/protobuf v1.34.2
+
+require (
+	golang.org/x/net v0.20.0
+	golang.org/x/text v0.14.0 // indirect
+)
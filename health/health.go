@@ -0,0 +1,54 @@
+// Package health 提供内置的健康检查服务，客户端像调用普通 RPC 一样调用 "Health.Check"。
+package health
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	Serving    = "SERVING"
+	NotServing = "NOT_SERVING"
+)
+
+// HealthRequest 中 Service 为空时查询整个服务端的状态
+type HealthRequest struct {
+	Service string
+}
+
+type HealthResponse struct {
+	Status string // Serving 或 NotServing
+}
+
+// Health 记录每个服务名的状态，整个服务端（Service 为空）默认为 Serving，
+// 其他服务名需要先通过 SetServingStatus 设置才能查询。Health 可以被多个 goroutine 同时使用。
+type Health struct {
+	mu      sync.Mutex // protect following
+	serving map[string]bool
+}
+
+func New() *Health {
+	return &Health{serving: map[string]bool{"": true}}
+}
+
+// SetServingStatus 设置 service 的状态，service 为空时设置整个服务端的状态
+func (h *Health) SetServingStatus(service string, serving bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.serving[service] = serving
+}
+
+// Check 返回 req.Service 的状态，没有设置过状态的服务名返回错误
+func (h *Health) Check(req HealthRequest, resp *HealthResponse) error {
+	h.mu.Lock()
+	serving, ok := h.serving[req.Service]
+	h.mu.Unlock()
+	if !ok {
+		return errors.New("health: unknown service " + req.Service)
+	}
+	resp.Status = NotServing
+	if serving {
+		resp.Status = Serving
+	}
+	return nil
+}
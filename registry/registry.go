@@ -1 +1,142 @@
+// Package registry 提供一个简单的注册中心：服务端定期发送心跳注册自己，客户端获取存活的服务端列表。
 package registry
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry 是一个简单的注册中心，提供以下功能：
+//   - POST 时通过 X-Xxrpc-Server 头添加服务端或刷新它的心跳时间
+//   - GET 时通过 X-Xxrpc-Servers 头返回所有存活的服务端，以逗号分隔
+//
+// 超过 timeout 没有收到心跳的服务端被视为不可用。
+type Registry struct {
+	timeout time.Duration
+	mu      sync.Mutex // protect following
+	servers map[string]*ServerItem
+}
+
+type ServerItem struct {
+	Addr  string
+	start time.Time // 最近一次心跳的时间
+}
+
+const (
+	DefaultPath    = "/_xxrpc_/registry"
+	DefaultTimeout = time.Minute * 5
+
+	ServerHeader  = "X-Xxrpc-Server"  // POST 时携带的服务端地址
+	ServersHeader = "X-Xxrpc-Servers" // GET 时返回的存活服务端
+)
+
+// New create a registry instance with timeout setting, 0 means no timeout
+func New(timeout time.Duration) *Registry {
+	return &Registry{
+		servers: make(map[string]*ServerItem),
+		timeout: timeout,
+	}
+}
+
+var DefaultRegistry = New(DefaultTimeout)
+
+// putServer 添加服务端，已存在时刷新它的心跳时间
+func (r *Registry) putServer(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.servers[addr]
+	if s == nil {
+		r.servers[addr] = &ServerItem{Addr: addr, start: time.Now()}
+	} else {
+		s.start = time.Now() // if exists, update start time to keep alive
+	}
+}
+
+// aliveServers 返回存活的服务端并删除超时的服务端，结果按地址排序
+func (r *Registry) aliveServers() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var alive []string
+	for addr, s := range r.servers {
+		if r.timeout == 0 || s.start.Add(r.timeout).After(time.Now()) {
+			alive = append(alive, addr)
+		} else {
+			delete(r.servers, addr)
+		}
+	}
+	sort.Strings(alive)
+	return alive
+}
+
+// ServeHTTP runs at DefaultPath
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		// keep it simple, servers are in w.Header
+		w.Header().Set(ServersHeader, strings.Join(r.aliveServers(), ","))
+	case http.MethodPost:
+		// keep it simple, server is in req.Header
+		addr := req.Header.Get(ServerHeader)
+		if addr == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.putServer(addr)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleHTTP registers an HTTP handler for Registry messages on registryPath
+func (r *Registry) HandleHTTP(registryPath string) {
+	http.Handle(registryPath, r)
+	log.Println("rpc registry path:", registryPath)
+}
+
+func HandleHTTP() {
+	DefaultRegistry.HandleHTTP(DefaultPath)
+}
+
+// Heartbeat 立即向注册中心 registry 注册 addr，之后每隔 duration 发送一次心跳，
+// duration 为 0 时使用比 DefaultTimeout 少一分钟的间隔，保证在超时之前发送心跳。
+// addr 使用 client.XDial 的 protocol@addr 格式。第一次心跳失败时返回错误，
+// 之后的失败只记录日志，继续按间隔发送，注册中心恢复后重新注册。
+func Heartbeat(registry, addr string, duration time.Duration) error {
+	if duration == 0 {
+		duration = DefaultTimeout - time.Minute
+	}
+	if err := sendHeartbeat(registry, addr); err != nil {
+		return err
+	}
+	go func() {
+		t := time.NewTicker(duration)
+		defer t.Stop()
+		for range t.C {
+			_ = sendHeartbeat(registry, addr)
+		}
+	}()
+	return nil
+}
+
+// sendHeartbeat 发送一次心跳，注册中心返回非 2xx 的状态码同样视为失败
+func sendHeartbeat(registry, addr string) error {
+	req, _ := http.NewRequest(http.MethodPost, registry, nil)
+	req.Header.Set(ServerHeader, addr)
+	resp, err := http.DefaultClient.Do(req)
+	if err == nil {
+		_ = resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err = errors.New("unexpected registry response: " + resp.Status)
+		}
+	}
+	if err != nil {
+		log.Println("rpc server: heart beat err:", err)
+		return err
+	}
+	return nil
+}
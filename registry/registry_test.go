@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	r := New(time.Millisecond * 200)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	alive := func() string {
+		t.Helper()
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.Header.Get(ServersHeader)
+	}
+
+	// a 持续发送心跳，b 只注册一次
+	if err := Heartbeat(ts.URL, "tcp@a", time.Millisecond*50); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendHeartbeat(ts.URL, "tcp@b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := alive(); got != "tcp@a,tcp@b" {
+		t.Fatalf("expect both servers alive, got %q", got)
+	}
+	time.Sleep(time.Millisecond * 300)
+	if got := alive(); got != "tcp@a" {
+		t.Fatalf("expect tcp@b to expire, got %q", got)
+	}
+}
+
+func TestHeartbeat_Failures(t *testing.T) {
+	var beats, fail int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&beats, 1)
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	atomic.StoreInt32(&fail, 1)
+	if err := Heartbeat(ts.URL, "tcp@a", time.Millisecond*20); err == nil {
+		t.Fatal("expect a non-2xx response to fail the first heartbeat")
+	}
+	atomic.StoreInt32(&fail, 0)
+	if err := Heartbeat(ts.URL, "tcp@a", time.Millisecond*20); err != nil {
+		t.Fatal(err)
+	}
+
+	// 之后的失败不会停止心跳
+	atomic.StoreInt32(&fail, 1)
+	time.Sleep(time.Millisecond * 100)
+	atomic.StoreInt32(&fail, 0)
+	n := atomic.LoadInt32(&beats)
+	time.Sleep(time.Millisecond * 100)
+	if atomic.LoadInt32(&beats) <= n {
+		t.Fatal("expect heartbeats to continue after a failure")
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+	"sort"
+
+	"xxrpc/xxcode"
+)
+
+// RegisterCodec 使这个 Server 接受 CodeType 为 t 的连接，用 f 创建它们的编解码器，
+// 也可以替换 t 已有的实现。注册只作用于这个 Server，不修改全局的 xxcode.NewCodeFuncMap，
+// 同一进程中的多个 Server 可以支持不同的编解码器，没有在这里注册的类型仍然使用全局注册的编解码器。
+// 与 SetAuthFunc 一样，它和正在服务的连接之间没有同步，应在开始服务之前调用。
+func (s *Server) RegisterCodec(t xxcode.Type, f xxcode.NewCodeFunc) error {
+	if t == "" || f == nil {
+		return errors.New("rpc server: register codec: empty type or nil func")
+	}
+	if s.codecs == nil {
+		s.codecs = make(map[xxcode.Type]xxcode.NewCodeFunc)
+	}
+	s.codecs[t] = f
+	return nil
+}
+
+// codecFunc 返回这个 Server 创建类型为 t 的编解码器的函数，不支持时返回 nil
+func (s *Server) codecFunc(t xxcode.Type) xxcode.NewCodeFunc {
+	if f := s.codecs[t]; f != nil {
+		return f
+	}
+	return xxcode.CodeFunc(t)
+}
+
+// codecFuncs 返回这个 Server 可以创建的编解码器：全局注册的编解码器加上 RegisterCodec 注册的
+func (s *Server) codecFuncs() map[xxcode.Type]xxcode.NewCodeFunc {
+	funcs := xxcode.CodeFuncs()
+	for t, f := range s.codecs {
+		funcs[t] = f
+	}
+	return funcs
+}
+
+// supportedCodecs 返回服务端接受的编解码器，见 Option.SupportedCodecs。
+// 它会复制全局注册的编解码器，只用于回复客户端和错误信息，判断单个类型使用 supportsCodec。
+func (s *Server) supportedCodecs() []xxcode.Type {
+	var types []xxcode.Type
+	if s.opt.SupportedCodecs != nil {
+		for _, typ := range s.opt.SupportedCodecs {
+			if s.codecFunc(typ) != nil {
+				types = append(types, typ)
+			}
+		}
+		return types
+	}
+	for typ := range s.codecFuncs() {
+		types = append(types, typ)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+func (s *Server) supportsCodec(typ xxcode.Type) bool {
+	if s.codecFunc(typ) == nil {
+		return false
+	}
+	if s.opt.SupportedCodecs == nil {
+		return true
+	}
+	for _, t := range s.opt.SupportedCodecs {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateCodec 按客户端的偏好顺序返回第一个服务端支持的编解码器
+func (s *Server) negotiateCodec(accept []xxcode.Type) (xxcode.Type, bool) {
+	for _, typ := range accept {
+		if s.supportsCodec(typ) {
+			return typ, true
+		}
+	}
+	return "", false
+}
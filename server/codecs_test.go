@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"xxrpc/common"
+	"xxrpc/xxcode"
+)
+
+// typeTestGob 是只注册在一个 Server 上的编解码器，线上格式与 gob 相同
+const typeTestGob xxcode.Type = "application/x-test-gob"
+
+func TestServer_RegisterCodec(t *testing.T) {
+	custom := NewServer()
+	_ = custom.Register(Foo(0))
+	if err := custom.RegisterCodec(typeTestGob, xxcode.NewGobCode); err != nil {
+		t.Fatal(err)
+	}
+	if err := custom.RegisterCodec("", xxcode.NewGobCode); err == nil {
+		t.Fatal("expect an error for an empty codec type")
+	}
+	plain := NewServer()
+	_ = plain.Register(Foo(0))
+	if xxcode.CodeFunc(typeTestGob) != nil {
+		t.Fatal("expect the global codec map to be left alone")
+	}
+
+	opt := &common.Option{MagicNumber: common.MagicNumber, CodeType: typeTestGob}
+	conn := custom.ServePipe()
+	defer func() { _ = conn.Close() }()
+	if err := json.NewEncoder(conn).Encode(opt); err != nil {
+		t.Fatal(err)
+	}
+	cc := xxcode.NewGobCode(conn)
+	go func() { _ = cc.Write(&xxcode.Header{ServiceMethod: "Foo.Sum", SeqId: 1}, Args{Num1: 1, Num2: 2}) }()
+	var h xxcode.Header
+	var reply int
+	if err := cc.ReadHeader(&h); err != nil || h.Error != "" {
+		t.Fatalf("expect the custom codec to be served, got %+v (%v)", h, err)
+	}
+	if err := cc.ReadBody(&reply); err != nil || reply != 3 {
+		t.Fatalf("expect 3, got %d (%v)", reply, err)
+	}
+
+	// 另一个 Server 不认识这个编解码器，协商时拒绝
+	conn = plain.ServePipe()
+	defer func() { _ = conn.Close() }()
+	if err := json.NewEncoder(conn).Encode(&common.Option{MagicNumber: common.MagicNumber, AcceptCodecs: []xxcode.Type{typeTestGob}}); err != nil {
+		t.Fatal(err)
+	}
+	var hr common.HandshakeReply
+	if err := json.NewDecoder(conn).Decode(&hr); err != nil || !strings.Contains(hr.Error, "unsupported codec") {
+		t.Fatalf("expect the codec to be unsupported, got %+v (%v)", hr, err)
+	}
+
+	// 协商时注册了编解码器的 Server 选中并公布它
+	conn = custom.ServePipe()
+	defer func() { _ = conn.Close() }()
+	if err := json.NewEncoder(conn).Encode(&common.Option{MagicNumber: common.MagicNumber, AcceptCodecs: []xxcode.Type{typeTestGob}}); err != nil {
+		t.Fatal(err)
+	}
+	hr = common.HandshakeReply{}
+	if err := json.NewDecoder(conn).Decode(&hr); err != nil || hr.CodeType != typeTestGob {
+		t.Fatalf("expect the custom codec to be chosen, got %+v (%v)", hr, err)
+	}
+
+	// 之后全局注册的编解码器对这个 Server 同样可用
+	const typeLateGob xxcode.Type = "application/x-test-late-gob"
+	if err := xxcode.RegisterCodec(typeLateGob, xxcode.NewGobCode); err != nil {
+		t.Fatal(err)
+	}
+	if !custom.supportsCodec(typeLateGob) || !custom.supportsCodec(typeTestGob) {
+		t.Fatal("expect both the global and the per-server codecs to be supported")
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"io"
+	"time"
+
+	"xxrpc/xxcode"
+)
+
+// deadlineCode 在每次读取请求和写入响应之前设置连接的读写超时，见 Option.ReadTimeout 和 Option.WriteTimeout
+type deadlineCode struct {
+	xxcode.Code
+	s     *Server
+	conn  io.ReadWriteCloser
+	read  time.Duration
+	write time.Duration
+}
+
+// ReadHeader 的超时同时覆盖之后读取 body 的时间，也包括等待下一个请求的时间
+func (c *deadlineCode) ReadHeader(h *xxcode.Header) error {
+	if c.read > 0 {
+		c.s.extendReadDeadline(c.conn, c.read)
+	}
+	return c.Code.ReadHeader(h)
+}
+
+func (c *deadlineCode) Write(h *xxcode.Header, body interface{}) error {
+	if c.write > 0 {
+		setWriteDeadline(c.conn, time.Now().Add(c.write))
+	}
+	return c.Code.Write(h, body)
+}
+
+// extendReadDeadline 将 conn 的读超时设置为 d 之后。Shutdown 已经将读超时设置为当前时间时不再延长。
+// 每个请求都会调用，因此不加锁：Shutdown 先标记 inShutdown 再设置超时，
+// 设置之后仍然看到 inShutdown 说明可能覆盖了 Shutdown 的超时，重新设置为当前时间
+func (s *Server) extendReadDeadline(conn io.ReadWriteCloser, d time.Duration) {
+	if s.inShutdown.Load() {
+		return
+	}
+	setReadDeadline(conn, time.Now().Add(d))
+	if s.inShutdown.Load() {
+		setReadDeadline(conn, time.Now())
+	}
+}
+
+// setWriteDeadline 与 setReadDeadline 相同，设置连接的写超时
+func setWriteDeadline(conn io.ReadWriteCloser, t time.Time) {
+	if hc, ok := conn.(*handshakeConn); ok {
+		conn = hc.ReadWriteCloser
+	}
+	if c, ok := conn.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		_ = c.SetWriteDeadline(t)
+	}
+}
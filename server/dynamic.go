@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"xxrpc/xxcode"
+)
+
+// DynamicHandler 处理没有注册服务的请求，serviceMethod 可以是任意名称，
+// body 和返回的字节是编解码器中未经解码的 body，格式见 xxcode.RawBody。
+// 返回的错误与服务方法的错误一样发送给客户端。
+type DynamicHandler func(ctx context.Context, serviceMethod string, body []byte) ([]byte, error)
+
+// RegisterDynamic 设置找不到对应服务或方法时使用的处理函数，用于网关等按名称动态分发请求的场景，
+// 通过 Register 注册的服务仍然优先。gob 的 body 不能单独解码，使用 gob 的连接上这些请求返回错误。
+// handler 为 nil 时恢复为返回 can't find service，应在开始服务之前调用。
+func (s *Server) RegisterDynamic(handler DynamicHandler) {
+	s.dynamic = handler
+}
+
+// readDynamicRequest 以 RawBody 读取交给 DynamicHandler 的请求体
+func (s *Server) readDynamicRequest(cc xxcode.Code, req *request) (*request, error) {
+	req.svc, req.mtype = nil, nil // findService 可能找到了服务但没有找到方法
+	var raw xxcode.RawBody
+	if err := cc.ReadBody(&raw); err != nil {
+		if errors.Is(err, xxcode.ErrRawUnsupported) {
+			return req, fmt.Errorf("rpc server: dynamic handler for %s: %w", req.head.ServiceMethod, err)
+		}
+		s.logger.Println("rpc server: read body err:", err)
+		return req, err
+	}
+	req.argv = reflect.ValueOf([]byte(raw))
+	req.replyv = reflect.ValueOf(xxcode.RawBody(nil))
+	return req, nil
+}
+
+// callDynamic 调用 DynamicHandler，返回的字节作为 reply 原样写出
+func (s *Server) callDynamic(ctx context.Context, req *request) error {
+	reply, err := s.dynamic(ctx, req.head.ServiceMethod, req.argv.Bytes())
+	if err != nil {
+		return err
+	}
+	req.replyv = reflect.ValueOf(xxcode.RawBody(reply))
+	return nil
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"xxrpc/client"
+	"xxrpc/common"
+	"xxrpc/xxcode"
+)
+
+func TestServer_RegisterDynamic(t *testing.T) {
+	s := NewServer()
+	_ = s.Register(Foo(0))
+	s.RegisterDynamic(func(ctx context.Context, serviceMethod string, body []byte) ([]byte, error) {
+		if serviceMethod == "Gateway.Fail" {
+			return nil, errors.New("gateway failed")
+		}
+		return []byte(`{"method":"` + serviceMethod + `","body":` + string(body) + `}`), nil
+	})
+	c, err := client.DialPipe(s.ServePipe(), &common.Option{CodeType: xxcode.Type_Json})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+
+	type echo struct {
+		Method string
+		Body   Args
+	}
+	for _, method := range []string{"Gateway.Echo", "Foo.Unknown", "no-dot"} {
+		var reply echo
+		if err = c.Call(context.Background(), method, Args{Num1: 1, Num2: 2}, &reply); err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		if reply.Method != method || reply.Body != (Args{Num1: 1, Num2: 2}) {
+			t.Fatalf("%s: unexpected reply %+v", method, reply)
+		}
+	}
+	// 注册的服务仍然优先
+	var sum int
+	if err = c.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &sum); err != nil || sum != 3 {
+		t.Fatalf("expect Foo.Sum to be served by Foo, got %d (%v)", sum, err)
+	}
+	var reply echo
+	if err = c.Call(context.Background(), "Gateway.Fail", Args{}, &reply); err == nil || !strings.Contains(err.Error(), "gateway failed") {
+		t.Fatalf("expect the handler's error, got %v", err)
+	}
+
+	// gob 的 body 不能以原始字节转发，请求返回错误但连接仍然可用
+	gc := startInterceptorServer(t, s)
+	if err = gc.Call(context.Background(), "Gateway.Echo", Args{}, &reply); err == nil || !strings.Contains(err.Error(), "raw bodies") {
+		t.Fatalf("expect a raw body error over gob, got %v", err)
+	}
+	if err = gc.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &sum); err != nil || sum != 3 {
+		t.Fatalf("expect the gob connection to stay usable, got %d (%v)", sum, err)
+	}
+}
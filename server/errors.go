@@ -0,0 +1,21 @@
+package server
+
+import "errors"
+
+// codedError 是带有错误码的错误，错误码随响应的 header 发送给客户端，见 xxcode.CodeNotFound 等
+type codedError struct {
+	code int
+	msg  string
+}
+
+func (e *codedError) Error() string { return e.msg }
+func (e *codedError) Code() int     { return e.code }
+
+// errorCode 返回 err 的错误码，err 没有实现 interface{ Code() int } 时返回 0
+func errorCode(err error) int {
+	var coder interface{ Code() int }
+	if errors.As(err, &coder) {
+		return coder.Code()
+	}
+	return 0
+}
@@ -0,0 +1,24 @@
+package server
+
+import "context"
+
+// Interceptor 拦截处理函数的调用，method 为 "<service>.<method>"，args 为解码后的参数，
+// next 执行下一个拦截器或处理函数。拦截器可以不调用 next 而直接返回错误，此时处理函数不会被调用，
+// 错误照常作为响应发送给客户端。
+type Interceptor func(ctx context.Context, method string, args interface{}, next func() error) error
+
+// Use 追加拦截器，先追加的拦截器在外层，最先执行。应在开始服务之前调用。
+func (s *Server) Use(interceptors ...Interceptor) {
+	s.interceptors = append(s.interceptors, interceptors...)
+}
+
+// intercept 依次通过所有拦截器后执行 handler
+func (s *Server) intercept(ctx context.Context, method string, args interface{}, handler func() error) error {
+	for i := len(s.interceptors) - 1; i >= 0; i-- {
+		next, interceptor := handler, s.interceptors[i]
+		handler = func() error {
+			return interceptor(ctx, method, args, next)
+		}
+	}
+	return handler()
+}
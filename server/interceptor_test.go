@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"xxrpc/client"
+)
+
+// Counter 记录 Add 被调用的次数
+type Counter struct {
+	calls int
+}
+
+func (c *Counter) Add(argv int, reply *int) error {
+	c.calls++
+	*reply = argv + 1
+	return nil
+}
+
+// startInterceptorServer 通过内存连接拨号到 s，不需要监听端口
+func startInterceptorServer(t *testing.T, s *Server) *client.Client {
+	c, err := client.DialPipe(s.ServePipe())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestServer_Use(t *testing.T) {
+	counter := &Counter{}
+	s := NewServer()
+	_ = s.Register(counter)
+	var order []string
+	trace := func(name string) Interceptor {
+		return func(ctx context.Context, method string, args interface{}, next func() error) error {
+			if method != "Counter.Add" || args.(int) != 1 {
+				t.Errorf("unexpected method %s args %v", method, args)
+			}
+			order = append(order, name+" before")
+			err := next()
+			order = append(order, name+" after")
+			return err
+		}
+	}
+	s.Use(trace("first"), trace("second"))
+	c := startInterceptorServer(t, s)
+
+	var reply int
+	if err := c.Call(context.Background(), "Counter.Add", 1, &reply); err != nil || reply != 2 {
+		t.Fatalf("expect reply 2, got %d, %v", reply, err)
+	}
+	expect := []string{"first before", "second before", "second after", "first after"}
+	if !reflect.DeepEqual(order, expect) {
+		t.Fatalf("expect order %v, got %v", expect, order)
+	}
+}
+
+func TestServer_UseShortCircuit(t *testing.T) {
+	counter := &Counter{}
+	s := NewServer()
+	_ = s.Register(counter)
+	s.Use(func(ctx context.Context, method string, args interface{}, next func() error) error {
+		return errors.New("permission denied")
+	})
+	c := startInterceptorServer(t, s)
+
+	var reply int
+	err := c.Call(context.Background(), "Counter.Add", 1, &reply)
+	if err == nil || err.Error() != "permission denied" {
+		t.Fatalf("expect permission denied, got %v", err)
+	}
+	if counter.calls != 0 {
+		t.Fatalf("expect the handler to be skipped, called %d times", counter.calls)
+	}
+}
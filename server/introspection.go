@@ -0,0 +1,45 @@
+package server
+
+import (
+	"sort"
+
+	"xxrpc/common"
+	"xxrpc/service"
+)
+
+// Introspection 是内置的自省服务 common.IntrospectionService，列出服务端已注册的服务和方法，
+// 与 /debug/xxrpc 页面的内容相同。它不出现在服务列表中，
+// 注册了同名服务时以注册的服务为准，Option.DisableIntrospection 可以关闭它。
+type Introspection struct {
+	s *Server
+}
+
+func (i *Introspection) ListServices(_ struct{}, reply *common.ServicesReply) error {
+	reply.Services = nil
+	i.s.serviceMap.Range(func(namei, svci interface{}) bool {
+		svc := svci.(*service.Service)
+		info := common.ServiceInfo{Name: namei.(string)}
+		for name, mtype := range svc.Method {
+			info.Methods = append(info.Methods, common.MethodInfo{
+				Name:          name,
+				ArgTypeName:   mtype.ArgType.String(),
+				ReplyTypeName: mtype.ReplyType.String(),
+				ReplyHash:     mtype.ReplyHash,
+			})
+		}
+		sort.Slice(info.Methods, func(a, b int) bool { return info.Methods[a].Name < info.Methods[b].Name })
+		reply.Services = append(reply.Services, info)
+		return true
+	})
+	sort.Slice(reply.Services, func(a, b int) bool { return reply.Services[a].Name < reply.Services[b].Name })
+	return nil
+}
+
+// newIntrospection 创建 s 的自省服务，关闭时返回 nil
+func newIntrospection(s *Server) *service.Service {
+	if s.opt.DisableIntrospection {
+		return nil
+	}
+	svc, _ := service.NewNamedService(&Introspection{s: s}, common.IntrospectionService, "")
+	return svc
+}
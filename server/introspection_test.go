@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"xxrpc/common"
+	"xxrpc/xxcode"
+)
+
+func TestServer_Introspection(t *testing.T) {
+	s := NewServer()
+	_ = s.Register(Zoo(0))
+	_ = s.Register(&Counter{})
+	c := startInterceptorServer(t, s)
+
+	var reply common.ServicesReply
+	if err := c.Call(context.Background(), "Introspection.ListServices", struct{}{}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	intHash, stringHash := xxcode.TypeHash(reflect.TypeOf(new(int))), xxcode.TypeHash(reflect.TypeOf(new(string)))
+	want := []common.ServiceInfo{
+		{Name: "Counter", Methods: []common.MethodInfo{{Name: "Add", ArgTypeName: "int", ReplyTypeName: "*int", ReplyHash: intHash}}},
+		{Name: "Zoo", Methods: []common.MethodInfo{{Name: "Feed", ArgTypeName: "string", ReplyTypeName: "*string", ReplyHash: stringHash}}},
+	}
+	if !reflect.DeepEqual(reply.Services, want) {
+		t.Fatalf("expect %+v, got %+v", want, reply.Services)
+	}
+
+	disabled := NewServer(&common.Option{DisableIntrospection: true})
+	_ = disabled.Register(Zoo(0))
+	err := startInterceptorServer(t, disabled).Call(context.Background(), "Introspection.ListServices", struct{}{}, &reply)
+	if err == nil || !strings.Contains(err.Error(), "can't find service Introspection") {
+		t.Fatalf("expect introspection to be disabled, got %v", err)
+	}
+}
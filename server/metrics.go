@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsCollector 收集每次处理函数调用的结果，service 和 method 为请求中的服务名和方法名，
+// dur 为处理函数的执行时间，err 为处理函数返回的错误。
+// ObserveCall 会被多个 goroutine 同时调用。
+type MetricsCollector interface {
+	ObserveCall(service, method string, dur time.Duration, err error)
+}
+
+// SetMetrics 设置处理函数调用结束时上报的 MetricsCollector，应在开始服务之前调用。
+func (s *Server) SetMetrics(m MetricsCollector) {
+	s.metrics = m
+}
+
+// observeCall 向 MetricsCollector 上报一次调用
+func (s *Server) observeCall(serviceMethod string, dur time.Duration, err error) {
+	if s.metrics == nil {
+		return
+	}
+	// DynamicHandler 处理的名称可能不含 "."，此时服务名为空
+	serviceName, methodName := "", serviceMethod
+	if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+		serviceName, methodName = serviceMethod[:dot], serviceMethod[dot+1:]
+	}
+	s.metrics.ObserveCall(serviceName, methodName, dur, err)
+}
+
+// MethodStats 为一个方法的累计调用统计
+type MethodStats struct {
+	Calls        uint64        `json:"calls"`
+	Errors       uint64        `json:"errors"`
+	TotalLatency time.Duration `json:"total_latency"`
+	MaxLatency   time.Duration `json:"max_latency"`
+	// Buckets[i] 为延迟不超过 LatencyBuckets[i] 且超过前一个边界的调用数，超过所有边界的调用只计入 Calls
+	Buckets []uint64 `json:"buckets"`
+}
+
+// LatencyBuckets 是 MemoryMetrics 统计延迟分布使用的桶边界，按升序排列，应在开始统计之前修改
+var LatencyBuckets = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond,
+	50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// MemoryMetrics 是在内存中累计统计的 MetricsCollector
+type MemoryMetrics struct {
+	mu    sync.Mutex
+	stats map[string]*MethodStats // "<service>.<method>" -> 统计
+}
+
+func NewMemoryMetrics() *MemoryMetrics {
+	return &MemoryMetrics{stats: make(map[string]*MethodStats)}
+}
+
+func (m *MemoryMetrics) ObserveCall(service, method string, dur time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.stats[service+"."+method]
+	if st == nil {
+		st = &MethodStats{Buckets: make([]uint64, len(LatencyBuckets))}
+		m.stats[service+"."+method] = st
+	}
+	if i := sort.Search(len(LatencyBuckets), func(i int) bool { return dur <= LatencyBuckets[i] }); i < len(st.Buckets) {
+		st.Buckets[i]++
+	}
+	st.Calls++
+	if err != nil {
+		st.Errors++
+	}
+	st.TotalLatency += dur
+	if dur > st.MaxLatency {
+		st.MaxLatency = dur
+	}
+}
+
+// Snapshot 返回当前统计的副本，key 为 "<service>.<method>"
+func (m *MemoryMetrics) Snapshot() map[string]MethodStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make(map[string]MethodStats, len(m.stats))
+	for name, st := range m.stats {
+		cp := *st
+		cp.Buckets = append([]uint64(nil), st.Buckets...)
+		snapshot[name] = cp
+	}
+	return snapshot
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WritePrometheus 以 Prometheus 文本格式写出当前统计：每个方法的调用数 xxrpc_server_calls_total、
+// 错误数 xxrpc_server_errors_total 和延迟直方图 xxrpc_server_handling_seconds，方法按 service 和 method 标签区分
+func (m *MemoryMetrics) WritePrometheus(w io.Writer) error {
+	snapshot := m.Snapshot()
+	names := make([]string, 0, len(snapshot))
+	for name := range snapshot {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	labels := make(map[string]string, len(names))
+	for _, name := range names {
+		dot := strings.LastIndex(name, ".")
+		labels[name] = fmt.Sprintf(`service="%s",method="%s"`, labelEscaper.Replace(name[:dot]), labelEscaper.Replace(name[dot+1:]))
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# HELP xxrpc_server_calls_total Handler calls completed, by method.")
+	fmt.Fprintln(bw, "# TYPE xxrpc_server_calls_total counter")
+	for _, name := range names {
+		fmt.Fprintf(bw, "xxrpc_server_calls_total{%s} %d\n", labels[name], snapshot[name].Calls)
+	}
+	fmt.Fprintln(bw, "# HELP xxrpc_server_errors_total Handler calls that returned an error, by method.")
+	fmt.Fprintln(bw, "# TYPE xxrpc_server_errors_total counter")
+	for _, name := range names {
+		fmt.Fprintf(bw, "xxrpc_server_errors_total{%s} %d\n", labels[name], snapshot[name].Errors)
+	}
+	fmt.Fprintln(bw, "# HELP xxrpc_server_handling_seconds Handler latency, by method.")
+	fmt.Fprintln(bw, "# TYPE xxrpc_server_handling_seconds histogram")
+	for _, name := range names {
+		st := snapshot[name]
+		var count uint64
+		for i, n := range st.Buckets {
+			count += n
+			le := strconv.FormatFloat(LatencyBuckets[i].Seconds(), 'g', -1, 64)
+			fmt.Fprintf(bw, "xxrpc_server_handling_seconds_bucket{%s,le=\"%s\"} %d\n", labels[name], le, count)
+		}
+		fmt.Fprintf(bw, "xxrpc_server_handling_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels[name], st.Calls)
+		fmt.Fprintf(bw, "xxrpc_server_handling_seconds_sum{%s} %s\n", labels[name], strconv.FormatFloat(st.TotalLatency.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(bw, "xxrpc_server_handling_seconds_count{%s} %d\n", labels[name], st.Calls)
+	}
+	return bw.Flush()
+}
+
+// MetricsHandler 返回以 Prometheus 文本格式响应 GET 的 HTTP 处理器，HandleHTTP 将它注册在 common.DefaultMetricsPath。
+// SetMetrics 设置的 MetricsCollector 需要像 MemoryMetrics 一样实现 WritePrometheus(io.Writer) error，否则响应 404。
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			httpError(w, http.StatusMethodNotAllowed, "405 must GET")
+			return
+		}
+		exporter, ok := s.metrics.(interface{ WritePrometheus(w io.Writer) error })
+		if !ok {
+			httpError(w, http.StatusNotFound, "404 metrics not enabled, see Server.SetMetrics")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := exporter.WritePrometheus(w); err != nil {
+			s.logger.Println("rpc server: write metrics error:", err)
+		}
+	})
+}
+
+// DefaultExpvarName 是 Publish 常用的 expvar 变量名
+const DefaultExpvarName = "xxrpc"
+
+// Publish 将统计以 name 为名发布到 expvar，通过 /debug/vars 以 JSON 读取当前的 Snapshot，
+// 延迟的单位为纳秒。同一个 name 只能发布一次，重复发布时 expvar 会 panic。
+func (m *MemoryMetrics) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return m.Snapshot()
+	}))
+}
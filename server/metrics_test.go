@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"xxrpc/common"
+)
+
+// Parity 对奇数返回错误
+type Parity int
+
+func (p Parity) Even(argv int, reply *bool) error {
+	if argv%2 != 0 {
+		return errors.New("odd")
+	}
+	*reply = true
+	return nil
+}
+
+func TestServer_SetMetrics(t *testing.T) {
+	metrics := NewMemoryMetrics()
+	s := NewServer()
+	_ = s.Register(Parity(0))
+	s.SetMetrics(metrics)
+	c := startInterceptorServer(t, s)
+
+	var reply bool
+	for i := 0; i < 5; i++ {
+		_ = c.Call(context.Background(), "Parity.Even", i, &reply)
+	}
+	// 找不到方法的请求不会执行处理函数，也就不会上报
+	_ = c.Call(context.Background(), "Parity.Missing", 1, &reply)
+
+	snapshot := metrics.Snapshot()
+	if len(snapshot) != 1 {
+		t.Fatalf("expect only Parity.Even to be observed, got %v", snapshot)
+	}
+	st := snapshot["Parity.Even"]
+	if st.Calls != 5 || st.Errors != 2 {
+		t.Fatalf("expect 5 calls and 2 errors, got %+v", st)
+	}
+	if st.MaxLatency <= 0 || st.TotalLatency < st.MaxLatency {
+		t.Fatalf("expect latencies to be recorded, got %+v", st)
+	}
+}
+
+func TestMemoryMetrics_Publish(t *testing.T) {
+	// expvar 不允许重复发布，-count 大于 1 时每次使用不同的变量名
+	name := fmt.Sprintf("%s_%d", DefaultExpvarName, time.Now().UnixNano())
+	metrics := NewMemoryMetrics()
+	metrics.Publish(name)
+	s := NewServer()
+	_ = s.Register(Parity(0))
+	s.SetMetrics(metrics)
+	c := startInterceptorServer(t, s)
+
+	var reply bool
+	for i := 0; i < 3; i++ {
+		_ = c.Call(context.Background(), "Parity.Even", i, &reply)
+	}
+
+	v := expvar.Get(name)
+	if v == nil {
+		t.Fatal("expect metrics to be published")
+	}
+	var published map[string]MethodStats
+	if err := json.Unmarshal([]byte(v.String()), &published); err != nil {
+		t.Fatal(err)
+	}
+	st := published["Parity.Even"]
+	if st.Calls != 3 || st.Errors != 1 || st.TotalLatency <= 0 {
+		t.Fatalf("expect 3 calls and 1 error, got %+v", st)
+	}
+}
+
+func TestServer_MetricsHandler(t *testing.T) {
+	s := NewServer()
+	_ = s.Register(Parity(0))
+	w := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", common.DefaultMetricsPath, nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expect 404 without metrics, got %d", w.Code)
+	}
+
+	s.SetMetrics(NewMemoryMetrics())
+	c := startInterceptorServer(t, s)
+	var reply bool
+	for i := 0; i < 3; i++ {
+		_ = c.Call(context.Background(), "Parity.Even", i, &reply)
+	}
+
+	w = httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", common.DefaultMetricsPath, nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("expect the text exposition format, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE xxrpc_server_calls_total counter",
+		`xxrpc_server_calls_total{service="Parity",method="Even"} 3`,
+		`xxrpc_server_errors_total{service="Parity",method="Even"} 1`,
+		"# TYPE xxrpc_server_handling_seconds histogram",
+		`xxrpc_server_handling_seconds_bucket{service="Parity",method="Even",le="10"} 3`,
+		`xxrpc_server_handling_seconds_bucket{service="Parity",method="Even",le="+Inf"} 3`,
+		`xxrpc_server_handling_seconds_count{service="Parity",method="Even"} 3`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("expect %q in the exposition, got:\n%s", line, body)
+		}
+	}
+	if !strings.Contains(body, `xxrpc_server_handling_seconds_sum{service="Parity",method="Even"} `) {
+		t.Fatalf("expect the latency sum in the exposition, got:\n%s", body)
+	}
+}
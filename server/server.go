@@ -1,18 +1,23 @@
 package server
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"reflect"
+	runtimedebug "runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"xxrpc/common"
+	"xxrpc/health"
 	"xxrpc/service"
 	"xxrpc/xxcode"
 )
@@ -20,11 +25,42 @@ import (
 // Server represents an RPC Server.
 type Server struct {
 	serviceMap sync.Map
+	opt        *common.Option // server-wide settings
+	inflight   int64          // approximate bytes held by in-flight requests
+	prefix     string         // method name prefix required by Register, see SetMethodPrefix
+	conns      int64          // connections currently served by ServeConn
+	draining   int32          // 1 while the server is draining, see SetDraining
+	authFunc   func(token string) error
+	limiter    *tokenBucket // shared by all connections, nil without Option.RateLimit
+
+	interceptors []Interceptor    // wrap handler calls, see Use
+	metrics      MetricsCollector // observes every handler call, see SetMetrics
+	healthOnce   sync.Once
+	healthSvc    *health.Health                     // the built-in Health service, see SetServingStatus
+	introspect   *service.Service                   // the built-in Introspection service, nil when disabled
+	logger       common.Logger                      // receives the package's logs, see SetLogger
+	codecs       map[xxcode.Type]xxcode.NewCodeFunc // overrides of xxcode.NewCodeFuncMap, see RegisterCodec
+	dynamic      DynamicHandler                     // serves methods without a registered service, see RegisterDynamic
+
+	mu         sync.Mutex // protect following, see Shutdown
+	listeners  map[net.Listener]struct{}
+	active     map[io.ReadWriteCloser]struct{}
+	inShutdown atomic.Bool // 只在持有 mu 时设置，extendReadDeadline 不加锁读取
 }
 
 // NewServer returns a new Server.
-func NewServer() *Server {
-	return &Server{}
+// An optional Option configures server-wide settings such as MaxInflightBytes.
+func NewServer(opts ...*common.Option) *Server {
+	opt := &common.Option{}
+	if len(opts) > 0 && opts[0] != nil {
+		opt = opts[0]
+	}
+	s := &Server{opt: opt, logger: common.DefaultLogger(opt.Logger)}
+	s.introspect = newIntrospection(s)
+	if opt.RateLimit > 0 {
+		s.limiter = newTokenBucket(opt.RateLimit, opt.RateBurst)
+	}
+	return s
 }
 
 // DefaultServer is the default instance of *Server.
@@ -33,47 +69,279 @@ var DefaultServer = NewServer()
 // ServeConn 在单一链接上运行服务，并阻塞直到客户端断开链接
 // ServeConn blocks, serving the connection until the client hangs up.
 func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	if !s.trackConn(conn, true) {
+		_ = conn.Close() // 正在关闭，不再接受新的连接
+		return
+	}
+	atomic.AddInt64(&s.conns, 1)
 	defer func() {
+		atomic.AddInt64(&s.conns, -1)
 		_ = conn.Close()
+		s.trackConn(conn, false)
 	}()
 
 	// json.NewDecoder 反序列化得到 Option 实例，检查MagicNumber和CodeType
 	var opt common.Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		log.Println("rpc server: options error: ", err)
+	dec := json.NewDecoder(conn)
+	if s.opt.ReadTimeout > 0 {
+		s.extendReadDeadline(conn, s.opt.ReadTimeout)
+	}
+	if err := dec.Decode(&opt); err != nil {
+		s.logger.Println("rpc server: options error: ", err)
 		return
 	}
 	if opt.MagicNumber != common.MagicNumber {
-		log.Printf("rpc server: invalid magic number %x", opt.MagicNumber)
+		msg := fmt.Sprintf("rpc server: invalid magic number %x, expect %x", opt.MagicNumber, common.MagicNumber)
+		s.logger.Println(msg)
+		s.reject(conn, &opt, 0, msg)
+		return
+	}
+	if v := opt.ProtocolVersion; v > common.ProtocolVersion || v < common.MinProtocolVersion && v != 0 {
+		msg := fmt.Sprintf("rpc server: unsupported protocol version %d, expect %d to %d", v, common.MinProtocolVersion, common.ProtocolVersion)
+		s.logger.Println(msg)
+		s.reject(conn, &opt, 0, msg)
+		return
+	}
+	if s.authFunc != nil {
+		if err := s.authFunc(opt.AuthToken); err != nil {
+			s.logger.Println("rpc server: auth rejected:", err)
+			s.reject(conn, &opt, xxcode.CodeUnauthenticated, "rpc server: auth rejected")
+			return
+		}
+	}
+	if len(opt.AcceptCodecs) > 0 {
+		typ, ok := s.negotiateCodec(opt.AcceptCodecs)
+		if !ok {
+			msg := fmt.Sprintf("rpc server: unsupported codec: none of %v, support %v", opt.AcceptCodecs, s.supportedCodecs())
+			s.logger.Println(msg)
+			s.reject(conn, &opt, 0, msg)
+			return
+		}
+		opt.CodeType = typ
+		if err := json.NewEncoder(conn).Encode(&common.HandshakeReply{CodeType: typ, Codecs: s.supportedCodecs()}); err != nil {
+			s.logger.Println("rpc server: write handshake reply error:", err)
+			return
+		}
+	}
+	if err := opt.ValidateCodecs(s.codecFunc); err != nil {
+		msg := "rpc server: " + err.Error()
+		s.logger.Println(msg)
+		s.reject(conn, &opt, 0, msg)
+		return
+	}
+	f := s.codecFunc(opt.CodeType)
+	if !s.supportsCodec(opt.CodeType) {
+		msg := fmt.Sprintf("rpc server: unsupported codec %s, support %v", opt.CodeType, s.supportedCodecs())
+		s.logger.Println(msg)
+		s.reject(conn, &opt, 0, msg)
+		return
+	}
+	// 客户端发送 Option 后紧接着就会发送请求，json 解码器可能已经预读了请求的一部分，
+	// 因此先读出解码器缓冲的数据，并跳过 json.Encoder 在 Option 之后写入的换行符
+	br := bufio.NewReader(io.MultiReader(dec.Buffered(), conn))
+	if b, err := br.Peek(1); err == nil && b[0] == '\n' {
+		_, _ = br.Discard(1)
+	}
+	ctx := context.Background()
+	if addr := remoteAddr(conn); addr != nil {
+		ctx = context.WithValue(ctx, peerKey{}, addr)
+	}
+	hc := &handshakeConn{r: br, ReadWriteCloser: conn}
+	var cc xxcode.Code
+	if len(s.opt.FallbackCodecs) == 0 {
+		cc = f(hc)
+	} else {
+		types := append([]xxcode.Type{opt.CodeType}, s.opt.FallbackCodecs...)
+		funcs := make(map[xxcode.Type]xxcode.NewCodeFunc, len(types))
+		for _, t := range types {
+			if fn := s.codecFunc(t); fn != nil {
+				funcs[t] = fn
+			}
+		}
+		fc, err := xxcode.NewFallbackCodeFuncs(hc, types, funcs)
+		if err != nil {
+			s.logger.Println("rpc server: codec error:", err)
+			return
+		}
+		cc = fc
+	}
+	cc, err := xxcode.NewCompressCode(cc, opt.CodeType, opt.Compression)
+	if err != nil {
+		s.logger.Println("rpc server: codec error:", err)
+		return
+	}
+	xxcode.SetReadLimit(cc, s.opt.MaxRequestBytes)
+	xxcode.SetRawBytes(cc, opt.ProtocolVersion >= common.RawBytesVersion)
+	defer xxcode.ReleaseCode(cc) // serveCode 返回时这个连接上的读写都已经结束
+	if s.opt.ReadTimeout > 0 || s.opt.WriteTimeout > 0 {
+		cc = &deadlineCode{Code: cc, s: s, conn: conn, read: s.opt.ReadTimeout, write: s.opt.WriteTimeout}
+	}
+	s.serveCode(ctx, cc, &opt)
+}
+
+// reject 在关闭连接前用客户端选择的编解码器发送 RejectServiceMethod 帧，告诉客户端握手失败的原因，
+// 使客户端不必等到读取出错才发现连接不可用。协商编解码器的客户端等待的是 HandshakeReply，
+// 此时改为在其中说明原因。opt.CodeType 无效时无法编码，直接返回。
+func (s *Server) reject(conn io.ReadWriteCloser, opt *common.Option, code int, msg string) {
+	if len(opt.AcceptCodecs) > 0 {
+		if err := json.NewEncoder(conn).Encode(&common.HandshakeReply{Error: msg, ErrorCode: code}); err != nil {
+			s.logger.Println("rpc server: write reject error:", err)
+		}
 		return
 	}
-	f := xxcode.NewCodeFuncMap[opt.CodeType]
+	f := s.codecFunc(opt.CodeType)
 	if f == nil {
-		log.Printf("rpc server: invalid codec type %s", opt.CodeType)
 		return
 	}
-	s.serveCode(f(conn), &opt)
+	h := &xxcode.Header{ServiceMethod: xxcode.RejectServiceMethod, Error: msg, ErrorCode: code}
+	cc := f(conn)
+	if err := cc.Write(h, invalidRequest); err != nil {
+		s.logger.Println("rpc server: write reject error:", err)
+	}
+	xxcode.ReleaseCode(cc)
+}
+
+// ServeCodec 在已经协商好的编解码器 cc 上处理请求，跳过 ServeConn 中 Option 的 JSON 握手，
+// 适用于双方事先约定了编解码方式的场景，例如可信的内部总线或测试中的内存管道。
+// opt 中只有连接级别的字段（如 HandleTimeout、OrderedProcessing、ProtocolVersion）生效，nil 表示使用默认值。
+// ServeCodec 阻塞到 cc 读取出错，返回前关闭 cc。Shutdown 不会跟踪这样的连接，由调用方关闭 cc。
+func (s *Server) ServeCodec(cc xxcode.Code, opt *common.Option) {
+	if opt == nil {
+		opt = common.DefaultOption
+	}
+	atomic.AddInt64(&s.conns, 1)
+	defer func() {
+		atomic.AddInt64(&s.conns, -1)
+		_ = cc.Close()
+	}()
+	xxcode.SetReadLimit(cc, s.opt.MaxRequestBytes)
+	xxcode.SetRawBytes(cc, opt.ProtocolVersion >= common.RawBytesVersion)
+	s.serveCode(context.Background(), cc, opt)
+}
+
+type peerKey struct{}
+
+// PeerAddr 返回处理函数的 ctx 中记录的客户端地址，未知时返回 nil
+func PeerAddr(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(peerKey{}).(net.Addr)
+	return addr
+}
+
+type metadataKey struct{}
+
+// Metadata 返回处理函数的 ctx 中客户端随请求发送的元数据，没有时返回 nil，调用方不应修改返回的 map
+func Metadata(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(metadataKey{}).(map[string]string)
+	return md
+}
+
+func remoteAddr(conn io.ReadWriteCloser) net.Addr {
+	if hc, ok := conn.(*handshakeConn); ok {
+		conn = hc.ReadWriteCloser
+	}
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		return c.RemoteAddr()
+	}
+	return nil
+}
+
+// handshakeConn 先返回 Option 解码器中缓冲的剩余数据，再继续读取原始连接
+type handshakeConn struct {
+	r io.Reader
+	io.ReadWriteCloser
+}
+
+func (c *handshakeConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
 }
 
 // invalidRequest 是发生错误时响应 argv 的占位符
 var invalidRequest = struct{}{}
 
-func (s *Server) serveCode(cc xxcode.Code, opt *common.Option) {
-	sending := new(sync.Mutex) // 确保发送完整的回复
-	wg := new(sync.WaitGroup)  // 等到所有请求都被处理
+// serveCode 处理连接上的请求，ctx 携带连接级别的信息（如 PeerAddr），并作为每个请求 context 的父 context
+func (s *Server) serveCode(ctx context.Context, cc xxcode.Code, opt *common.Option) {
+	sending := new(sender)    // 确保发送完整的回复
+	wg := new(sync.WaitGroup) // 等到所有请求都被处理
+	cancels := new(sync.Map)  // SeqId -> context.CancelFunc，用于处理取消帧
+	var slots chan struct{}   // 限制同时运行的处理函数，见 Option.MaxConcurrentRequests
+	if s.opt.MaxConcurrentRequests > 0 {
+		slots = make(chan struct{}, s.opt.MaxConcurrentRequests)
+	}
 	for {
 		// 读取请求
 		req, err := s.readRequest(cc)
+		if req != nil && req.head.ServiceMethod == xxcode.CancelServiceMethod {
+			if cancel, ok := cancels.LoadAndDelete(req.head.SeqId); ok {
+				cancel.(context.CancelFunc)()
+			}
+			continue
+		}
+		if req != nil && req.head.ServiceMethod == xxcode.PingServiceMethod && err == nil {
+			s.sendResponse(cc, req.head, invalidRequest, sending)
+			continue
+		}
 		if err != nil {
 			if req == nil {
 				break // 无法恢复，所以关闭连接
 			}
-			req.head.Error = err.Error()
+			req.head.Error, req.head.ErrorCode = err.Error(), errorCode(err)
+			s.sendResponse(cc, req.head, invalidRequest, sending)
+			if errors.Is(err, xxcode.ErrMessageTooLarge) {
+				break // 请求体没有读完，之后的数据无法对齐到下一个请求
+			}
+			continue
+		}
+		timeout, ok := handleTimeout(opt.HandleTimeout, req.deadline)
+		if !ok {
+			req.head.Error, req.head.ErrorCode = "rpc server: request deadline exceeded", xxcode.CodeTimeout
 			s.sendResponse(cc, req.head, invalidRequest, sending)
 			continue
 		}
+		if s.limiter != nil && !s.limiter.allow() {
+			req.head.Error, req.head.ErrorCode = "rpc server: rate limited", xxcode.CodeUnavailable
+			s.sendResponse(cc, req.head, invalidRequest, sending)
+			continue
+		}
+		if slots != nil {
+			if !acquireSlot(slots, s.opt.RejectExcessRequests) {
+				req.head.Error, req.head.ErrorCode = "rpc server: too many concurrent requests", xxcode.CodeUnavailable
+				s.sendResponse(cc, req.head, invalidRequest, sending)
+				continue
+			}
+			req.release = func() { <-slots }
+		}
+		if !s.acquireInflight(req) {
+			if req.release != nil {
+				req.release()
+			}
+			req.head.Error, req.head.ErrorCode = "rpc server: server busy: too many in-flight request bytes", xxcode.CodeUnavailable
+			s.sendResponse(cc, req.head, invalidRequest, sending)
+			continue
+		}
+		reqCtx, cancel := context.WithCancel(ctx)
+		if req.metadata != nil {
+			reqCtx = context.WithValue(reqCtx, metadataKey{}, req.metadata)
+		}
+		if id := req.metadata[common.TraceIDKey]; id != "" {
+			reqCtx = common.WithTraceID(reqCtx, id)
+		} else if s.opt.Tracer != nil {
+			reqCtx = common.WithTraceID(reqCtx, common.NewTraceID())
+		}
+		req.done = cancel
+		if seq := req.head.SeqId; seq != xxcode.OneWaySeqId {
+			cancels.Store(seq, cancel)
+			req.done = func() {
+				cancels.Delete(seq)
+				cancel()
+			}
+		}
 		wg.Add(1)
-		go s.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
+		if opt.OrderedProcessing {
+			// 在读取下一个请求之前处理完当前请求，保证按发送顺序执行，此时取消帧要等到请求处理完才会被读取
+			s.handleRequest(reqCtx, cc, req, sending, wg, timeout)
+			continue
+		}
+		go s.handleRequest(reqCtx, cc, req, sending, wg, timeout)
 	}
 
 	wg.Wait()
@@ -85,14 +353,70 @@ type request struct {
 	head         *xxcode.Header // 请求头
 	argv, replyv reflect.Value  // argv and replyv of request
 	mtype        *service.MethodType
-	svc          *service.Service
+	svc          *service.Service  // nil for requests served by the DynamicHandler
+	size         int64             // bytes accounted against MaxInflightBytes
+	done         func()            // releases the request's context once it has been handled
+	metadata     map[string]string // head.Metadata, kept out of the response header
+	deadline     int64             // head.Deadline in unix nanos, 0 if the client set none
+	release      func()            // frees the MaxConcurrentRequests slot once the handler returns
+}
+
+// handleTimeout 返回请求的处理期限：客户端带来的截止时间 deadline 与连接的 HandleTimeout 中较早的一个，
+// 0 表示不限制。deadline 已经过去时 ok 为 false。这里假定客户端与服务端的时钟基本同步。
+func handleTimeout(timeout time.Duration, deadline int64) (d time.Duration, ok bool) {
+	if deadline == 0 {
+		return timeout, true
+	}
+	remaining := time.Until(time.Unix(0, deadline))
+	if remaining <= 0 {
+		return 0, false
+	}
+	if timeout <= 0 || remaining < timeout {
+		return remaining, true
+	}
+	return timeout, true
+}
+
+// acquireSlot 占用一个处理函数的名额，reject 为 true 时没有空闲名额立即返回 false，否则等待
+func acquireSlot(slots chan struct{}, reject bool) bool {
+	if !reject {
+		slots <- struct{}{}
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// acquireInflight 将请求参数的近似大小计入 MaxInflightBytes 预算，超出预算时返回 false。
+// 参数在解码之后才计入，因此预算不限制读取单个请求时的分配，这由 MaxRequestBytes 限制。
+func (s *Server) acquireInflight(req *request) bool {
+	if s.opt.MaxInflightBytes <= 0 {
+		return true
+	}
+	req.size = xxcode.SizeOf(req.argv.Interface())
+	if atomic.AddInt64(&s.inflight, req.size) > s.opt.MaxInflightBytes {
+		atomic.AddInt64(&s.inflight, -req.size)
+		return false
+	}
+	return true
+}
+
+// releaseInflight 在处理函数返回后归还请求占用的预算
+func (s *Server) releaseInflight(req *request) {
+	if req.size > 0 {
+		atomic.AddInt64(&s.inflight, -req.size)
+	}
 }
 
 func (s *Server) readRequestHeader(cc xxcode.Code) (*xxcode.Header, error) {
 	var h xxcode.Header
 	if err := cc.ReadHeader(&h); err != nil {
-		if err != io.EOF && err != io.ErrUnexpectedEOF {
-			log.Println("rpc server: read header error:", err)
+		if err != io.EOF && err != io.ErrUnexpectedEOF && !s.shuttingDown() {
+			s.logger.Println("rpc server: read header error:", err)
 		}
 		return nil, err
 	}
@@ -104,9 +428,21 @@ func (s *Server) readRequest(cc xxcode.Code) (*request, error) {
 	if err != nil {
 		return nil, err
 	}
-	req := &request{head: h}
+	// 响应复用请求的 header，元数据和截止时间不回传给客户端
+	req := &request{head: h, metadata: h.Metadata, deadline: h.Deadline}
+	h.Metadata, h.Deadline = nil, 0
+	if h.ServiceMethod == xxcode.CancelServiceMethod || h.ServiceMethod == xxcode.PingServiceMethod {
+		return req, cc.ReadBody(nil)
+	}
 	req.svc, req.mtype, err = s.findService(h.ServiceMethod)
+	if err != nil && s.dynamic != nil {
+		return s.readDynamicRequest(cc, req)
+	}
 	if err != nil {
+		// 丢弃请求体，否则它会被当作下一个请求的 header 读取
+		if berr := cc.ReadBody(nil); errors.Is(berr, xxcode.ErrMessageTooLarge) {
+			return req, berr
+		}
 		return req, err
 	}
 
@@ -121,56 +457,138 @@ func (s *Server) readRequest(cc xxcode.Code) (*request, error) {
 		argvi = req.argv.Addr().Interface()
 	}
 	if err = cc.ReadBody(argvi); err != nil {
-		log.Println("rpc server: read body err:", err)
+		s.logger.Println("rpc server: read body err:", err)
 		return req, err
 	}
 	return req, nil
 }
 
-func (s *Server) sendResponse(cc xxcode.Code, h *xxcode.Header, body interface{}, sending *sync.Mutex) {
+// sender 保证一个连接上的响应依次完整地写出
+type sender struct {
+	sync.Mutex
+	broken bool // 写入响应超时，连接已经被关闭
+}
+
+func (s *Server) sendResponse(cc xxcode.Code, h *xxcode.Header, body interface{}, sending *sender) {
+	if h.SeqId == xxcode.OneWaySeqId {
+		return // 单向请求不需要任何响应，包括错误
+	}
 	sending.Lock()
 	defer sending.Unlock()
+	if sending.broken {
+		return
+	}
 	if err := cc.Write(h, body); err != nil {
-		log.Println("rpc server: write response error:", err)
+		s.logger.Println("rpc server: write response error:", err)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			// 客户端在 WriteTimeout 内没有读取响应，关闭连接使读取请求的循环退出，
+			// 这个连接上等待发送响应的处理函数随后直接返回
+			sending.broken = true
+			_ = cc.Close()
+		}
 	}
 }
 
+// call 经过拦截器调用服务方法，开启 RecoverPanics 时将 panic 转换为错误
+func (s *Server) call(ctx context.Context, req *request) (err error) {
+	if s.opt.RecoverPanics == nil || *s.opt.RecoverPanics {
+		defer func() {
+			if r := recover(); r != nil {
+				s.logger.Printf("rpc server: %s panic: %v\n%s", req.head.ServiceMethod, r, runtimedebug.Stack())
+				err = &codedError{xxcode.CodeInternal, fmt.Sprintf("rpc server: %s panic: %v", req.head.ServiceMethod, r)}
+			}
+		}()
+	}
+	return s.intercept(ctx, req.head.ServiceMethod, req.argv.Interface(), func() error {
+		if req.svc == nil {
+			return s.callDynamic(ctx, req)
+		}
+		return req.svc.Call(ctx, req.mtype, req.argv, req.replyv)
+	})
+}
+
 // 通过 req.svc.call 完成方法调用，将 replyv 传递给 sendResponse 完成序列化即可。
 // 这里需要确保 sendResponse 仅调用一次，因此将整个过程拆分为 called 和 sent 两个阶段，在这段代码中只会发生如下两种情况：
 // called 信道接收到消息，代表处理没有超时，继续执行 sendResponse。
 // time.After() 先于 called 接收到消息，说明处理已经超时，called 和 sent 都将被阻塞。在 case <-time.After(timeout) 处调用 sendResponse。
-func (s *Server) handleRequest(cc xxcode.Code, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
+func (s *Server) handleRequest(ctx context.Context, cc xxcode.Code, req *request, sending *sender, wg *sync.WaitGroup, timeout time.Duration) {
 	defer wg.Done()
-	called := make(chan struct{})
-	sent := make(chan struct{})
+	defer req.done()
+	var respErr error // 响应中报告的错误，用于结束 span
+	if s.opt.Tracer != nil {
+		var span common.Span
+		ctx, span = s.opt.Tracer.StartSpan(ctx, req.head.ServiceMethod, common.TraceID(ctx))
+		defer func() { span.Finish(respErr) }()
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// 只有 handleRequest 发送响应，处理函数超时后仍会继续运行，但结束时可以写入缓冲的 called 并退出
+	called := make(chan error, 1)
 	go func() {
-		err := req.svc.Call(req.mtype, req.argv, req.replyv)
-		called <- struct{}{}
-		if err != nil {
-			req.head.Error = err.Error()
-			s.sendResponse(cc, req.head, invalidRequest, sending)
-			sent <- struct{}{}
-			return
+		stop := s.watchRunaway(req)
+		start := time.Now()
+		err := s.call(ctx, req)
+		s.observeCall(req.head.ServiceMethod, time.Since(start), err)
+		stop()
+		if req.release != nil {
+			req.release() // 超时后处理函数仍在运行，直到返回才释放
 		}
-		s.sendResponse(cc, req.head, req.replyv.Interface(), sending)
-		sent <- struct{}{}
+		s.releaseInflight(req) // 同样在处理函数返回后才归还它持有的参数占用的预算
+		called <- err
 	}()
 
-	if timeout == 0 {
-		<-called
-		<-sent
-		return
+	var timedOut <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timedOut = timer.C
 	}
 
 	select {
-	case <-time.After(timeout):
+	case <-timedOut:
 		req.head.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
+		req.head.ErrorCode = xxcode.CodeTimeout
 		s.sendResponse(cc, req.head, invalidRequest, sending)
-	case <-called:
-		<-sent
+		respErr = errors.New(req.head.Error)
+	case err := <-called:
+		if err != nil {
+			respErr = err
+			req.head.Error, req.head.ErrorCode = s.redactError(req.head.ServiceMethod, err), errorCode(err)
+			s.sendResponse(cc, req.head, invalidRequest, sending)
+			return
+		}
+		if req.mtype != nil {
+			req.head.ReplyHash = req.mtype.ReplyHash
+		}
+		s.sendResponse(cc, req.head, req.replyv.Interface(), sending)
 	}
 }
 
+// redactError 返回发送给客户端的错误信息，配置了 ErrorRedactor 时完整的错误只记录在服务端日志中
+func (s *Server) redactError(serviceMethod string, err error) string {
+	if s.opt.ErrorRedactor == nil {
+		return err.Error()
+	}
+	s.logger.Printf("rpc server: %s error: %v", serviceMethod, err)
+	return s.opt.ErrorRedactor(err)
+}
+
+// watchRunaway 在处理函数运行超过 RunawayThreshold 时通过 OnRunaway 上报，返回的函数在处理结束时调用
+func (s *Server) watchRunaway(req *request) (stop func()) {
+	if s.opt.OnRunaway == nil || s.opt.RunawayThreshold <= 0 {
+		return func() {}
+	}
+	start := time.Now()
+	t := time.AfterFunc(s.opt.RunawayThreshold, func() {
+		s.opt.OnRunaway(req.head.ServiceMethod, req.head.SeqId, time.Since(start))
+	})
+	return func() { t.Stop() }
+}
+
 // 通过 ServiceMethod 从 serviceMap 中找到对应的 service
 // ServiceMethod 的构成是 “Service.Method”，
 // 因此先将其分割成 2 部分，第一部分是 Service 的名称，第二部分即方法名。
@@ -178,31 +596,40 @@ func (s *Server) handleRequest(cc xxcode.Code, req *request, sending *sync.Mutex
 func (s *Server) findService(serviceMethod string) (svc *service.Service, mtype *service.MethodType, err error) {
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
-		err = errors.New("rpc server: service/method request ill-formed: " + serviceMethod)
+		err = &codedError{xxcode.CodeNotFound, "rpc server: service/method request ill-formed: " + serviceMethod}
 		return
 	}
 	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
 	svci, ok := s.serviceMap.Load(serviceName)
-	fmt.Printf("%s---------%s\n", serviceName, methodName)
-	fmt.Printf("%T------------------\n", svci)
+	if !ok && serviceName == common.IntrospectionService && s.introspect != nil {
+		svci, ok = s.introspect, true
+	}
 	if !ok {
-		err = errors.New("rpc server: can't find service " + serviceName)
+		err = &codedError{xxcode.CodeNotFound, "rpc server: can't find service " + serviceName}
 		return
 	}
 	svc = svci.(*service.Service)
 	mtype = svc.Method[methodName]
 	if mtype == nil {
-		err = errors.New("rpc server: can't find method " + methodName)
+		err = &codedError{xxcode.CodeNotFound, "rpc server: can't find method " + methodName}
 	}
 	return
 }
 
-// accept 接受监听net.listener上的链接，并为每一个链接启动一个服务
-func (s *Server) accept(lis net.Listener) {
+// Accept 接受监听 net.Listener 上的链接，并为每一个链接启动一个服务，直到 lis 出错或被 Shutdown 关闭。
+// 通过 NewServer 创建的 Server 同样可以用它服务自己的 listener。
+func (s *Server) Accept(lis net.Listener) {
+	if !s.trackListener(lis, true) {
+		_ = lis.Close()
+		return
+	}
+	defer s.trackListener(lis, false)
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Println("rpc server: accept error:", err)
+			if !s.shuttingDown() {
+				s.logger.Println("rpc server: accept error:", err)
+			}
 			return
 		}
 		go s.ServeConn(conn)
@@ -210,7 +637,33 @@ func (s *Server) accept(lis net.Listener) {
 }
 
 func Accept(lis net.Listener) {
-	DefaultServer.accept(lis) // DefaultServer 是一个默认的 Server 实例，主要为了用户使用方便。
+	DefaultServer.Accept(lis) // DefaultServer 是一个默认的 Server 实例，主要为了用户使用方便。
+}
+
+// AcceptTLS 与 Accept 相同，但在每个连接上先使用 cfg 完成 TLS 握手，Option 握手和之后的消息都经过加密
+func (s *Server) AcceptTLS(lis net.Listener, cfg *tls.Config) {
+	s.Accept(tls.NewListener(lis, cfg))
+}
+
+// AcceptTLS accepts TLS connections on the listener and serves requests with DefaultServer
+func AcceptTLS(lis net.Listener, cfg *tls.Config) {
+	DefaultServer.AcceptTLS(lis, cfg)
+}
+
+// Serve 在 network 和 address 上监听并在后台调用 Accept，立即返回实际监听的地址，
+// address 为 ":0" 时可以由此得知系统分配的端口。与 Accept 一样，Shutdown 会关闭这个 listener。
+func (s *Server) Serve(network, address string) (net.Addr, error) {
+	lis, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	go s.Accept(lis)
+	return lis.Addr(), nil
+}
+
+// Serve listens on the address and serves requests with DefaultServer in the background
+func Serve(network, address string) (net.Addr, error) {
+	return DefaultServer.Serve(network, address)
 }
 
 // Register publishes in the server the set of methods of the
@@ -220,14 +673,79 @@ func Accept(lis net.Listener) {
 //   - the second argument is a pointer
 //   - one return value, of type error
 func (s *Server) Register(rcvr interface{}) error {
-	svc := service.NewService(rcvr)
+	return s.register(rcvr, "")
+}
+
+// RegisterGobTypes 登记处理函数在接口类型的参数或 reply 中使用的具体类型，见 xxcode.RegisterGobTypes。
+// 在开始服务之前调用，避免第一次编码这些值时报告 type not registered。
+func (s *Server) RegisterGobTypes(values ...interface{}) error {
+	return xxcode.RegisterGobTypes(values...)
+}
+
+// RegisterName 与 Register 相同，但以 name 作为服务名而不是类型名，
+// 同一类型的多个实例可以注册为不同的服务。
+func (s *Server) RegisterName(name string, rcvr interface{}) error {
+	if name == "" {
+		return errors.New("rpc: no service name for type " + reflect.TypeOf(rcvr).String())
+	}
+	return s.register(rcvr, name)
+}
+
+// Unregister 移除名为 name 的服务，之后的请求返回 can't find service。
+// 已经找到该服务的请求不受影响，会正常执行完成。
+func (s *Server) Unregister(name string) error {
+	if _, ok := s.serviceMap.LoadAndDelete(name); !ok {
+		return errors.New("rpc: service not defined: " + name)
+	}
+	return nil
+}
+
+// register 注册 rcvr，name 为空时使用类型名
+func (s *Server) register(rcvr interface{}, name string) error {
+	svc, err := service.NewNamedService(rcvr, name, s.prefix)
+	if err != nil {
+		return err
+	}
+	if err = svc.Validate(); err != nil {
+		return err
+	}
 	if _, dup := s.serviceMap.LoadOrStore(svc.Name, svc); dup {
 		return errors.New("rpc: service already defined: " + svc.Name)
 	}
+	for _, name := range svc.MethodNames() {
+		s.logger.Printf("rpc server: register %s.%s\n", svc.Name, name)
+	}
+	for _, name := range svc.PointerMethods() {
+		s.logger.Printf("rpc server: %s.%s has a pointer receiver and is not registered, register a pointer to expose it\n", svc.Name, name)
+	}
 	return nil
 }
 
+// SetLogger 设置服务端输出日志使用的 logger，nil 表示标准库默认的 logger。
+// 传入 log.New(io.Discard, "", 0) 可以关闭日志，应在开始服务之前调用。
+func (s *Server) SetLogger(logger common.Logger) {
+	s.logger = common.DefaultLogger(logger)
+}
+
+// SetMethodPrefix 使之后的 Register 只注册名称以 prefix 开头的方法，
+// 并以去掉 prefix 后的名称对外提供，例如 RPCGetUser 通过 User.GetUser 调用。
+// 应在 Register 之前调用。
+func (s *Server) SetMethodPrefix(prefix string) {
+	s.prefix = prefix
+}
+
+// SetAuthFunc 设置校验客户端 Option.AuthToken 的函数，f 返回错误时服务端关闭连接。
+// f 为 nil 时不做校验。它和正在服务的连接之间没有同步，应在开始服务之前调用。
+func (s *Server) SetAuthFunc(f func(token string) error) {
+	s.authFunc = f
+}
+
 // Register publishes the receiver's methods in the DefaultServer.
 func Register(rcvr interface{}) error {
 	return DefaultServer.Register(rcvr)
 }
+
+// RegisterName publishes the receiver's methods in the DefaultServer under name.
+func RegisterName(name string, rcvr interface{}) error {
+	return DefaultServer.RegisterName(name, rcvr)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"sort"
 
 	"xxrpc/service"
 )
@@ -20,7 +21,7 @@ const debugText = `<html>
 		{{range $name, $mtype := .Method}}
 			<tr>
 			<td align=left font=fixed>{{$name}}({{$mtype.ArgType}}, {{$mtype.ReplyType}}) error</td>
-			<td align=center>{{$mtype.NumCalls}}</td>
+			<td align=center>{{$mtype.NumCall}}</td>
 			</tr>
 		{{end}}
 		</table>
@@ -40,6 +41,7 @@ type debugService struct {
 }
 
 // Runs at /debug/xxrpc
+// The template reads call counts through MethodType.NumCall, so the page is safe to serve while handling traffic.
 func (s debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// Build a sorted version of the data.
 	var services []debugService
@@ -51,6 +53,7 @@ func (s debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		})
 		return true
 	})
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
 	err := debug.Execute(w, services)
 	if err != nil {
 		_, _ = fmt.Fprintln(w, "rpc: error executing template:", err.Error())
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"xxrpc/common"
+	"xxrpc/xxcode"
+)
+
+type Zoo int
+
+func (z Zoo) Feed(animal string, reply *string) error {
+	*reply = animal + " fed"
+	return nil
+}
+
+type User int
+
+func (u User) RPCGetUser(id int, reply *string) error {
+	*reply = "user"
+	return nil
+}
+
+func (u User) Validate(id int, reply *string) error {
+	return nil
+}
+
+func TestServer_SetMethodPrefix(t *testing.T) {
+	var user User
+	s := NewServer()
+	s.SetMethodPrefix("RPC")
+	_ = s.Register(&user)
+	if _, _, err := s.findService("User.GetUser"); err != nil {
+		t.Fatalf("expect User.GetUser registered: %v", err)
+	}
+	for _, name := range []string{"User.RPCGetUser", "User.Validate"} {
+		if _, _, err := s.findService(name); err == nil {
+			t.Fatalf("expect %s not registered", name)
+		}
+	}
+}
+
+type zoo int
+
+func (z zoo) Feed(animal string, reply *string) error {
+	return nil
+}
+
+func TestServer_RegisterInvalid(t *testing.T) {
+	s := NewServer()
+	if err := s.Register(zoo(0)); err == nil || !strings.Contains(err.Error(), "not a valid service name") {
+		t.Fatalf("expect an error for an unexported type, got %v", err)
+	}
+	if err := s.Register(&struct{ Zoo }{}); err == nil {
+		t.Fatal("expect an error for an anonymous type")
+	}
+	if err := s.Register(Zoo(0)); err != nil {
+		t.Fatalf("expect the server to keep working, got %v", err)
+	}
+}
+
+func TestServer_RegisterName(t *testing.T) {
+	a, b := &Counter{}, &Counter{}
+	s := NewServer()
+	if err := s.RegisterName("A", a); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterName("B", b); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterName("A", &Counter{}); err == nil {
+		t.Fatal("expect an error for a duplicate name")
+	}
+	if err := s.RegisterName("", &Counter{}); err == nil {
+		t.Fatal("expect an error for an empty name")
+	}
+	c := startInterceptorServer(t, s)
+
+	var reply int
+	for _, method := range []string{"A.Add", "B.Add", "B.Add"} {
+		if err := c.Call(context.Background(), method, 1, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.calls != 1 || b.calls != 2 {
+		t.Fatalf("expect each name to reach its own instance, got %d and %d", a.calls, b.calls)
+	}
+	if _, _, err := s.findService("Counter.Add"); err == nil {
+		t.Fatal("expect the type name not to be registered")
+	}
+}
+
+func TestServer_Unregister(t *testing.T) {
+	g := &Gate{entered: make(chan struct{}), release: make(chan struct{})}
+	s := NewServer()
+	_ = s.Register(g)
+	_ = s.Register(&Counter{})
+	c := startInterceptorServer(t, s)
+
+	var reply int
+	if err := c.Call(context.Background(), "Counter.Add", 1, &reply); err != nil {
+		t.Fatal(err)
+	}
+	// 注销之前已经开始的调用照常完成
+	call := c.Go("Gate.Wait", 7, new(int), nil)
+	<-g.entered
+	if err := s.Unregister("Gate"); err != nil {
+		t.Fatal(err)
+	}
+	close(g.release)
+	if <-call.Done; call.Error != nil || *call.Reply.(*int) != 7 {
+		t.Fatalf("expect the in-flight call to complete, got %v", call.Error)
+	}
+
+	if err := s.Unregister("Counter"); err != nil {
+		t.Fatal(err)
+	}
+	err := c.Call(context.Background(), "Counter.Add", 1, &reply)
+	if err == nil || !strings.Contains(err.Error(), "can't find service Counter") {
+		t.Fatalf("expect can't find service, got %v", err)
+	}
+	if err = s.Unregister("Counter"); err == nil {
+		t.Fatal("expect an error for an unknown service")
+	}
+}
+
+func TestServer_SetLogger(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewServer()
+	s.SetLogger(log.New(&buf, "", 0))
+	_ = s.Register(Zoo(0))
+
+	// findService 在每个请求上调用，不应输出任何内容
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	_, _, err = s.findService("Zoo.Feed")
+	os.Stdout = stdout
+	_ = w.Close()
+	out, _ := io.ReadAll(r)
+	if err != nil || len(out) != 0 {
+		t.Fatalf("expect findService to stay silent, got %q (%v)", out, err)
+	}
+
+	conn, peer := net.Pipe()
+	go func() {
+		_ = json.NewEncoder(peer).Encode(&common.Option{MagicNumber: 0x123})
+		_ = peer.Close()
+	}()
+	s.ServeConn(conn)
+	if !strings.Contains(buf.String(), "rpc server: invalid magic number 123") {
+		t.Fatalf("expect the log to reach the custom logger, got %q", buf.String())
+	}
+}
+
+func TestServer_InvalidOption(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewServer()
+	s.SetLogger(log.New(&buf, "", 0))
+	_ = s.Register(Zoo(0))
+
+	// 编解码器可用时通过 RejectServiceMethod 帧告诉客户端哪个字段无效
+	conn := s.ServePipe()
+	defer func() { _ = conn.Close() }()
+	opt := &common.Option{MagicNumber: common.MagicNumber, CodeType: xxcode.Type_Gob, HandleTimeout: -time.Second}
+	if err := json.NewEncoder(conn).Encode(opt); err != nil {
+		t.Fatal(err)
+	}
+	var h xxcode.Header
+	if err := xxcode.NewGobCode(conn).ReadHeader(&h); err != nil {
+		t.Fatal(err)
+	}
+	if h.ServiceMethod != xxcode.RejectServiceMethod || !strings.Contains(h.Error, "HandleTimeout -1s is negative") {
+		t.Fatalf("expect the handshake to be rejected naming HandleTimeout, got %+v", h)
+	}
+
+	// 未注册的编解码器无法回复，只记录日志
+	conn, peer := net.Pipe()
+	go func() {
+		_ = json.NewEncoder(peer).Encode(&common.Option{MagicNumber: common.MagicNumber, CodeType: "application/x-unknown"})
+		_ = peer.Close()
+	}()
+	s.ServeConn(conn)
+	if !strings.Contains(buf.String(), `CodeType "application/x-unknown" is not a registered codec`) {
+		t.Fatalf("expect the log to name CodeType, got %q", buf.String())
+	}
+}
+
+func TestDebugHTTP(t *testing.T) {
+	var foo Foo
+	var zoo Zoo
+	s := NewServer()
+	_ = s.Register(&zoo)
+	_ = s.Register(&foo)
+	svc, mtype, _ := s.findService("Foo.Sum")
+	_ = svc.Call(context.Background(), mtype, mtype.NewArgv(), mtype.NewReplyv())
+
+	w := httptest.NewRecorder()
+	debugHTTP{s}.ServeHTTP(w, httptest.NewRequest("GET", common.DefaultDebugPath, nil))
+	page := w.Body.String()
+	foos, zoos := strings.Index(page, "Service Foo"), strings.Index(page, "Service Zoo")
+	if foos < 0 || zoos < foos {
+		t.Fatalf("services missing or unsorted:\n%s", page)
+	}
+	if !strings.Contains(page, "Sum(server.Args, *int) error</td>\n\t\t\t<td align=center>1</td>") {
+		t.Fatalf("missing Foo.Sum call count:\n%s", page)
+	}
+	if !strings.Contains(page, "Feed(string, *string) error</td>\n\t\t\t<td align=center>0</td>") {
+		t.Fatalf("missing Zoo.Feed:\n%s", page)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"xxrpc/health"
+	"xxrpc/service"
+)
+
+// Health is the JSON body served by HealthHandler.
+type Health struct {
+	Services    int   `json:"services"`    // registered services
+	Connections int64 `json:"connections"` // connections currently being served
+	Draining    bool  `json:"draining"`    // the server is going away, see SetDraining
+}
+
+// SetDraining marks the server as draining, so HealthHandler reports 503 and
+// orchestrators stop routing new clients to it. It does not affect serving.
+func (s *Server) SetDraining(draining bool) {
+	var v int32
+	if draining {
+		v = 1
+	}
+	atomic.StoreInt32(&s.draining, v)
+}
+
+// SetServingStatus records whether name is serving in the built-in "Health" service,
+// an empty name stands for the whole server. The first call registers the service,
+// which reports through Health.Check(health.HealthRequest, *health.HealthResponse).
+// The method prefix set by SetMethodPrefix does not apply to it.
+func (s *Server) SetServingStatus(name string, serving bool) {
+	s.healthOnce.Do(func() {
+		s.healthSvc = health.New()
+		svc, _ := service.NewService(s.healthSvc) // health.Health 总是有效的服务
+		s.serviceMap.LoadOrStore("Health", svc)
+	})
+	s.healthSvc.SetServingStatus(name, serving)
+}
+
+// Health returns the current status of the server.
+func (s *Server) Health() Health {
+	h := Health{
+		Connections: atomic.LoadInt64(&s.conns),
+		Draining:    atomic.LoadInt32(&s.draining) == 1,
+	}
+	s.serviceMap.Range(func(_, _ interface{}) bool {
+		h.Services++
+		return true
+	})
+	return h
+}
+
+// HealthHandler returns a plain HTTP handler answering GET with the server's Health as JSON,
+// with status 200, or 503 while draining. HandleHTTP registers it at common.DefaultHealthPath.
+func (s *Server) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			httpError(w, http.StatusMethodNotAllowed, "405 must GET")
+			return
+		}
+		h := s.Health()
+		w.Header().Set("Content-Type", "application/json")
+		if h.Draining {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(h)
+	})
+}
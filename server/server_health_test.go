@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"xxrpc/common"
+	"xxrpc/health"
+)
+
+func TestServer_HealthHandler(t *testing.T) {
+	var foo Foo
+	var zoo Zoo
+	s := NewServer()
+	_ = s.Register(&foo)
+	_ = s.Register(&zoo)
+
+	check := func(wantCode int, want Health) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.HealthHandler().ServeHTTP(w, httptest.NewRequest("GET", common.DefaultHealthPath, nil))
+		if w.Code != wantCode {
+			t.Fatalf("expect status %d, got %d", wantCode, w.Code)
+		}
+		var got Health
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil || got != want {
+			t.Fatalf("expect %+v, got %+v (%v)", want, got, err)
+		}
+	}
+	check(http.StatusOK, Health{Services: 2})
+
+	c1, c2 := net.Pipe()
+	go s.ServeConn(c1)
+	for s.Health().Connections != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	check(http.StatusOK, Health{Services: 2, Connections: 1})
+	_ = c2.Close()
+	for s.Health().Connections != 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	s.SetDraining(true)
+	check(http.StatusServiceUnavailable, Health{Services: 2, Draining: true})
+	s.SetDraining(false)
+	check(http.StatusOK, Health{Services: 2})
+}
+
+func TestServer_SetServingStatus(t *testing.T) {
+	var foo Foo
+	s := NewServer()
+	_ = s.Register(&foo)
+	s.SetServingStatus("Foo", true)
+	c := startInterceptorServer(t, s)
+
+	check := func(name, want string) {
+		t.Helper()
+		var resp health.HealthResponse
+		if err := c.Call(context.Background(), "Health.Check", health.HealthRequest{Service: name}, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != want {
+			t.Fatalf("expect %s to be %s, got %s", name, want, resp.Status)
+		}
+	}
+	check("", health.Serving)
+	check("Foo", health.Serving)
+	s.SetServingStatus("Foo", false)
+	check("Foo", health.NotServing)
+	check("", health.Serving)
+	s.SetServingStatus("", false)
+	check("", health.NotServing)
+
+	var resp health.HealthResponse
+	err := c.Call(context.Background(), "Health.Check", health.HealthRequest{Service: "Bar"}, &resp)
+	if err == nil || !strings.Contains(err.Error(), "unknown service Bar") {
+		t.Fatalf("expect unknown service error, got %v", err)
+	}
+}
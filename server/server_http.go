@@ -1,11 +1,19 @@
 package server
 
 import (
+	"encoding/gob"
+	"encoding/json"
 	"io"
-	"log"
 	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
 	"xxrpc/common"
+	"xxrpc/xxcode"
 )
 
 // ServeHTTP implements an http.Handler that answers RPC requests.
@@ -16,13 +24,111 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		_, _ = io.WriteString(w, "405 must CONNECT\n")
 		return
 	}
-	conn, _, err := w.(http.Hijacker).Hijack()
+	conn, bufrw, err := w.(http.Hijacker).Hijack()
 	if err != nil {
-		log.Print("rpc hijacking ", req.RemoteAddr, ": ", err.Error())
+		s.logger.Println("rpc hijacking", req.RemoteAddr+":", err.Error())
 		return
 	}
 	_, _ = io.WriteString(conn, "HTTP/1.0 "+common.Connected+"\n\n")
-	s.ServeConn(conn)
+	// 客户端可能没有等待 CONNECT 的响应就发送了 Option 和请求，这些数据已经在 bufrw 中
+	s.ServeConn(&handshakeConn{r: bufrw.Reader, ReadWriteCloser: conn})
+}
+
+// ServeHTTPUnary answers a single RPC carried by a plain HTTP POST, without the CONNECT tunnel.
+// The method is the last segment of the URL path, e.g. POST /_xxrpc_/call/Foo.Sum.
+// The request body is the encoded argument and the response body is the encoded reply,
+// both using the codec named by the Content-Type header (gob by default).
+// Errors are reported with an HTTP status code and the error message as a plain text body.
+func (s *Server) ServeHTTPUnary(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, "405 must POST")
+		return
+	}
+	codeType := xxcode.Type(req.Header.Get("Content-Type"))
+	if codeType == "" {
+		codeType = xxcode.Type_Gob
+	}
+	var decode func(interface{}) error
+	var encode func(interface{}) error
+	switch codeType {
+	case xxcode.Type_Gob:
+		decode, encode = gob.NewDecoder(req.Body).Decode, gob.NewEncoder(w).Encode
+	case xxcode.Type_Json:
+		decode, encode = json.NewDecoder(req.Body).Decode, json.NewEncoder(w).Encode
+	default:
+		httpError(w, http.StatusUnsupportedMediaType, "rpc server: invalid codec type "+string(codeType))
+		return
+	}
+
+	serviceMethod := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+	svc, mtype, err := s.findService(serviceMethod)
+	if err != nil {
+		httpError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	argv, replyv := mtype.NewArgv(), mtype.NewReplyv()
+	argvi := argv.Interface()
+	if argv.Type().Kind() != reflect.Ptr {
+		argvi = argv.Addr().Interface()
+	}
+	if err = decode(argvi); err != nil {
+		httpError(w, http.StatusBadRequest, "rpc server: read body err: "+err.Error())
+		return
+	}
+	if err = svc.Call(req.Context(), mtype, argv, replyv); err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", string(codeType))
+	if err = encode(replyv.Interface()); err != nil {
+		s.logger.Println("rpc server: write response error:", err)
+	}
+}
+
+// HTTP2Handler returns an http.Handler serving RPCs over cleartext HTTP/2 (h2c).
+// Every call is its own POST stream handled like ServeHTTPUnary, so many calls
+// are multiplexed over one connection, as used by client.DialHTTP2.
+// Option.MaxConcurrentStreams is enforced per connection before dispatching a stream;
+// limits above the HTTP/2 transport default (250 streams) make the transport queue the excess instead.
+func (s *Server) HTTP2Handler() http.Handler {
+	h := http.Handler(http.HandlerFunc(s.ServeHTTPUnary))
+	if s.opt.MaxConcurrentStreams > 0 {
+		h = &streamLimiter{next: h, max: s.opt.MaxConcurrentStreams, streams: make(map[string]int)}
+	}
+	return h2c.NewHandler(h, &http2.Server{})
+}
+
+// streamLimiter 按连接统计正在处理的流，同一连接上的流共享 RemoteAddr
+type streamLimiter struct {
+	next    http.Handler
+	max     int
+	mu      sync.Mutex
+	streams map[string]int
+}
+
+func (l *streamLimiter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	l.mu.Lock()
+	if l.streams[req.RemoteAddr] >= l.max {
+		l.mu.Unlock()
+		httpError(w, http.StatusTooManyRequests, "rpc server: too many streams")
+		return
+	}
+	l.streams[req.RemoteAddr]++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		if l.streams[req.RemoteAddr]--; l.streams[req.RemoteAddr] == 0 {
+			delete(l.streams, req.RemoteAddr)
+		}
+		l.mu.Unlock()
+	}()
+	l.next.ServeHTTP(w, req)
+}
+
+func httpError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	_, _ = io.WriteString(w, msg+"\n")
 }
 
 // HandleHTTP registers an HTTP handler for RPC messages on rpcPath.
@@ -30,7 +136,10 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 func (s *Server) HandleHTTP() {
 	http.Handle(common.DefaultRPCPath, s)
 	http.Handle(common.DefaultDebugPath, debugHTTP{s})
-	log.Println("rpc server debug path:", common.DefaultDebugPath)
+	http.HandleFunc(common.DefaultUnaryPath, s.ServeHTTPUnary)
+	http.Handle(common.DefaultHealthPath, s.HealthHandler())
+	http.Handle(common.DefaultMetricsPath, s.MetricsHandler())
+	s.logger.Println("rpc server debug path:", common.DefaultDebugPath)
 }
 
 // HandleHTTP is a convenient approach for default server to register HTTP handlers
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"xxrpc/common"
+)
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func TestServer_ServeHTTPUnary(t *testing.T) {
+	var foo Foo
+	s := NewServer()
+	_ = s.Register(&foo)
+
+	body := strings.NewReader(`{"Num1":1,"Num2":2}`)
+	req := httptest.NewRequest(http.MethodPost, common.DefaultUnaryPath+"Foo.Sum", body)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTPUnary(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	var reply int
+	if err := json.NewDecoder(w.Body).Decode(&reply); err != nil || reply != 3 {
+		t.Fatalf("expect 3, got %d (%v)", reply, err)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, common.DefaultUnaryPath+"Foo.Missing", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	s.ServeHTTPUnary(w, req)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "can't find method Missing") {
+		t.Fatalf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,12 @@
+package server
+
+import "net"
+
+// ServePipe 创建一条内存中的连接，在后台以 ServeConn 服务其中一端，返回另一端。
+// 返回的连接交给 client.DialPipe 即可完成握手和调用，不需要监听端口，适合测试。
+// 服务端的一端和其他连接一样受 Shutdown 管理。
+func (s *Server) ServePipe() net.Conn {
+	c1, c2 := net.Pipe()
+	go s.ServeConn(c2)
+	return c1
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// shutdownPollInterval 是 Shutdown 检查连接是否都已关闭的间隔
+const shutdownPollInterval = time.Millisecond * 10
+
+// Shutdown 优雅地关闭服务端：关闭 accept 的所有 listener 使新的连接被拒绝，
+// 停止所有连接读取新的请求，等到正在处理的请求都发送响应、连接关闭后返回 nil。
+// ctx 先结束时强制关闭剩余的连接并返回 ctx.Err()。Shutdown 同时将服务端标记为 draining。
+//
+// 无法设置读超时的连接（不是 net.Conn）要等到客户端断开或 ctx 结束。
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.SetDraining(true)
+	s.mu.Lock()
+	s.inShutdown.Store(true)
+	for lis := range s.listeners {
+		_ = lis.Close()
+	}
+	for conn := range s.active {
+		// 让阻塞在读取请求上的 serveCode 立即返回，正在处理的请求仍然可以写回响应
+		setReadDeadline(conn, time.Now())
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		n := len(s.active)
+		s.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			for conn := range s.active {
+				_ = conn.Close()
+			}
+			s.mu.Unlock()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) shuttingDown() bool {
+	return s.inShutdown.Load()
+}
+
+// trackListener 记录或删除 accept 使用的 listener，正在关闭时拒绝记录并返回 false
+func (s *Server) trackListener(lis net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.listeners, lis)
+		return true
+	}
+	if s.inShutdown.Load() {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[lis] = struct{}{}
+	return true
+}
+
+// trackConn 记录或删除 ServeConn 正在服务的连接，正在关闭时拒绝记录并返回 false
+func (s *Server) trackConn(conn io.ReadWriteCloser, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.active, conn)
+		return true
+	}
+	if s.inShutdown.Load() {
+		return false
+	}
+	if s.active == nil {
+		s.active = make(map[io.ReadWriteCloser]struct{})
+	}
+	s.active[conn] = struct{}{}
+	return true
+}
+
+// setReadDeadline 设置连接的读超时，ServeHTTP 传入的 handshakeConn 使用被劫持的原始连接
+func setReadDeadline(conn io.ReadWriteCloser, t time.Time) {
+	if hc, ok := conn.(*handshakeConn); ok {
+		conn = hc.ReadWriteCloser
+	}
+	if c, ok := conn.(interface{ SetReadDeadline(time.Time) error }); ok {
+		_ = c.SetReadDeadline(t)
+	}
+}
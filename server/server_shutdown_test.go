@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"xxrpc/client"
+	"xxrpc/common"
+	"xxrpc/xxcode"
+)
+
+// Gate 阻塞到 release 被关闭，进入时通知 entered
+type Gate struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (g *Gate) Wait(argv int, reply *int) error {
+	close(g.entered)
+	<-g.release
+	*reply = argv
+	return nil
+}
+
+// 通过 NewServer 创建的 Server 在自己的 listener 上服务，关闭 listener 后 Accept 返回
+func TestServer_Accept(t *testing.T) {
+	var counter Counter
+	s := NewServer()
+	_ = s.Register(&counter)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	returned := make(chan struct{})
+	go func() {
+		s.Accept(l)
+		close(returned)
+	}()
+
+	c, err := client.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	var reply int
+	if err = c.Call(context.Background(), "Counter.Add", 1, &reply); err != nil || reply != 2 {
+		t.Fatalf("expect the call to succeed, got %d (%v)", reply, err)
+	}
+	_ = l.Close()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("expect Accept to return once the listener is closed")
+	}
+}
+
+// Serve 在 ":0" 上监听时返回系统分配的地址，Shutdown 关闭它的 listener
+func TestServer_Serve(t *testing.T) {
+	var counter Counter
+	s := NewServer()
+	_ = s.Register(&counter)
+	addr, err := s.Serve("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.(*net.TCPAddr).Port == 0 {
+		t.Fatalf("expect the resolved port, got %s", addr)
+	}
+	c, err := client.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply int
+	if err = c.Call(context.Background(), "Counter.Add", 1, &reply); err != nil || reply != 2 {
+		t.Fatalf("expect the call to succeed, got %d (%v)", reply, err)
+	}
+	_ = c.Close()
+	if _, err = s.Serve("tcp", addr.String()); err == nil {
+		t.Fatal("expect an error for an address in use")
+	}
+
+	if err = s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Dial("tcp", addr.String()); err == nil {
+		t.Fatal("expect the listener to be closed by Shutdown")
+	}
+}
+
+// 只发送一部分 Option 的连接在 ReadTimeout 后被关闭
+func TestServer_ReadTimeout(t *testing.T) {
+	var counter Counter
+	s := NewServer(&common.Option{ReadTimeout: time.Millisecond * 100, WriteTimeout: time.Millisecond * 100})
+	_ = s.Register(&counter)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go s.Accept(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err = conn.Write([]byte(`{"MagicNumber":`)); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect the server to close the connection, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*500 {
+		t.Fatalf("expect the connection to be closed after about 100ms, took %s", elapsed)
+	}
+
+	// 及时发送的请求不受影响，空闲超过 ReadTimeout 的连接被关闭
+	c, err := client.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	var reply int
+	for i := 0; i < 3; i++ {
+		if err = c.Call(context.Background(), "Counter.Add", i, &reply); err != nil || reply != i+1 {
+			t.Fatalf("expect the call to succeed, got %d (%v)", reply, err)
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	time.Sleep(time.Millisecond * 200)
+	if c.IsAvailable() {
+		t.Fatal("expect the idle connection to be closed")
+	}
+}
+
+// Blob 返回 n 字节的字符串
+type Blob int
+
+func (b Blob) Get(n int, reply *string) error {
+	*reply = strings.Repeat("x", n)
+	return nil
+}
+
+// 客户端发送请求后不再读取响应，写入超时后服务端关闭连接并释放处理函数
+func TestServer_WriteTimeout(t *testing.T) {
+	s := NewServer(&common.Option{WriteTimeout: time.Millisecond * 100})
+	_ = s.Register(Blob(0))
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go s.Accept(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	if err = json.NewEncoder(conn).Encode(common.DefaultOption); err != nil {
+		t.Fatal(err)
+	}
+	cc := xxcode.NewGobCode(conn)
+	for i := 1; i <= 200; i++ {
+		if err = cc.Write(&xxcode.Header{ServiceMethod: "Blob.Get", SeqId: uint64(i)}, 64<<10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(time.Second * 2)
+	for atomic.LoadInt64(&s.conns) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expect the server to close the connection of a client that stopped reading")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	g := &Gate{entered: make(chan struct{}), release: make(chan struct{})}
+	s := NewServer()
+	_ = s.Register(g)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	go s.Accept(l)
+
+	c, err := client.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	call := c.Go("Gate.Wait", 7, new(int), nil)
+	<-g.entered
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	for !s.shuttingDown() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err = net.DialTimeout("tcp", addr, time.Second); err == nil {
+		t.Fatal("expect new dials to be refused")
+	}
+	select {
+	case err = <-shutdown:
+		t.Fatalf("expect Shutdown to wait for the in-flight call, got %v", err)
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	close(g.release)
+	<-call.Done
+	if call.Error != nil || *call.Reply.(*int) != 7 {
+		t.Fatalf("expect the in-flight call to complete, got %v", call.Error)
+	}
+	if err = <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if !s.Health().Draining {
+		t.Fatal("expect the server to report draining")
+	}
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	g := &Gate{entered: make(chan struct{}), release: make(chan struct{})}
+	defer close(g.release)
+	s := NewServer()
+	_ = s.Register(g)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Accept(l)
+	c, err := client.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	call := c.Go("Gate.Wait", 1, new(int), nil)
+	<-g.entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err = s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expect the deadline error, got %v", err)
+	}
+	// 剩余的连接被强制关闭
+	if <-call.Done; call.Error == nil {
+		t.Fatal("expect the in-flight call to fail")
+	}
+}
@@ -8,15 +8,19 @@ import (
 type MethodType struct {
 	Method    reflect.Method //方法本身
 	ArgType   reflect.Type   //第一个参数的类型
-	ReplyType reflect.Type   //第二个参数的类型
+	ReplyType reflect.Type   //第二个参数的类型，方法返回 reply 时为返回值对应的指针类型
 	NumCalls  uint64         //统计方法调用次数s
+	ReplyHash uint64         //第二个参数类型的指纹，随响应发送给客户端
+	WantsCtx  bool           //方法的第一个参数是 context.Context
+	Returns   bool           //方法以 (Reply, error) 返回 reply，而不是写入 *Reply 参数
 }
 
 func (m *MethodType) NumCall() uint64 {
 	return atomic.LoadUint64(&m.NumCalls)
 }
 
-// TODO:
+// NewArgv 为每个请求分配一个新的参数实例，不做复用，
+// 处理函数对参数（包括其中的 slice、map）的修改不会影响其他请求。
 func (m *MethodType) NewArgv() reflect.Value {
 	var argv reflect.Value
 	// arg may be a pointer type, or a value type
@@ -28,14 +32,26 @@ func (m *MethodType) NewArgv() reflect.Value {
 	return argv
 }
 
+// NewReplyv 为每个请求分配 ReplyType 指向的 reply，ReplyType 总是指针。
+// reply 为 map 或 slice（如 *map[string][]int、*[]*Foo）时初始化为空的 map 或 slice，处理函数可以直接写入或 append；
+// reply 本身是指针（如 **Foo）时沿指针链逐级分配到非指针类型，避免处理函数写入 nil 指针，
+// 也避免 gob 因为无法编码 nil 指针而丢弃没有被处理函数赋值的 reply。
+// map 和 slice 中的元素以及结构体的字段不做分配，由处理函数负责。
 func (m *MethodType) NewReplyv() reflect.Value {
 	// reply must be a pointer type
-	replyv := reflect.New(m.ReplyType.Elem())
-	switch m.ReplyType.Elem().Kind() {
+	return newValue(m.ReplyType.Elem())
+}
+
+// newValue 返回指向 t 的新值的指针，按 NewReplyv 的规则初始化
+func newValue(t reflect.Type) reflect.Value {
+	v := reflect.New(t)
+	switch t.Kind() {
+	case reflect.Ptr:
+		v.Elem().Set(newValue(t.Elem()))
 	case reflect.Map:
-		replyv.Elem().Set(reflect.MakeMap(m.ReplyType.Elem()))
+		v.Elem().Set(reflect.MakeMap(t))
 	case reflect.Slice:
-		replyv.Elem().Set(reflect.MakeSlice(m.ReplyType.Elem(), 0, 0))
+		v.Elem().Set(reflect.MakeSlice(t, 0, 0))
 	}
-	return replyv
+	return v
 }
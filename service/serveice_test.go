@@ -1,9 +1,13 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 type Foo int
@@ -15,6 +19,20 @@ func (f Foo) Sum(args Args, reply *int) error {
 	return nil
 }
 
+type ctxKey struct{}
+
+// Scale 接受 context，从中读取倍数
+func (f Foo) Scale(ctx context.Context, args Args, reply *int) error {
+	*reply = (args.Num1 + args.Num2) * ctx.Value(ctxKey{}).(int)
+	return nil
+}
+
+// Wait 一直阻塞到 ctx 被取消
+func (f Foo) Wait(ctx context.Context, args Args, reply *int) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
 // it's not a exported Method
 func (f Foo) sum(args Args, reply *int) error {
 	*reply = args.Num1 + args.Num2
@@ -29,24 +47,50 @@ func _assert(condition bool, msg string, v ...interface{}) {
 
 func TestNewService(t *testing.T) {
 	var foo Foo
-	s := NewService(&foo)
-	_assert(len(s.Method) == 1, "wrong service Method, expect 1, but got %d", len(s.Method))
+	s, _ := NewService(&foo)
+	_assert(len(s.Method) == 3, "wrong service Method, expect 3, but got %d", len(s.Method))
 	mType := s.Method["Sum"]
-	_assert(mType != nil, "wrong Method, Sum shouldn't nil")
+	_assert(mType != nil && !mType.WantsCtx, "wrong Method, Sum shouldn't nil")
+	mType = s.Method["Scale"]
+	_assert(mType != nil && mType.WantsCtx, "wrong Method, Scale should want a context")
 }
 
 func TestMethodType_Call(t *testing.T) {
 	var foo Foo
-	s := NewService(&foo)
+	s, _ := NewService(&foo)
 	mType := s.Method["Sum"]
 
 	argv := mType.NewArgv()
 	replyv := mType.NewReplyv()
 	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
-	err := s.Call(mType, argv, replyv)
+	err := s.Call(context.Background(), mType, argv, replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.NumCall() == 1, "failed to call Foo.Sum")
 }
 
+func TestMethodType_CallWithContext(t *testing.T) {
+	var foo Foo
+	s, _ := NewService(&foo)
+	mType := s.Method["Scale"]
+
+	argv := mType.NewArgv()
+	replyv := mType.NewReplyv()
+	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
+	ctx := context.WithValue(context.Background(), ctxKey{}, 10)
+	err := s.Call(ctx, mType, argv, replyv)
+	_assert(err == nil && *replyv.Interface().(*int) == 40 && mType.NumCall() == 1, "failed to call Foo.Scale")
+}
+
+func TestMethodType_CallCanceled(t *testing.T) {
+	var foo Foo
+	s, _ := NewService(&foo)
+	mType := s.Method["Wait"]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*50, cancel)
+	err := s.Call(ctx, mType, mType.NewArgv(), mType.NewReplyv())
+	_assert(errors.Is(err, context.Canceled), "expect context.Canceled, but got %v", err)
+}
+
 func GetKeys[K comparable, V any](m map[K]V) []K {
 	res := make([]K, 0, len(m))
 	for k := range m {
@@ -54,3 +98,175 @@ func GetKeys[K comparable, V any](m map[K]V) []K {
 	}
 	return res
 }
+
+// Mixed 同时有值接收者和指针接收者的方法
+type Mixed struct{ n int }
+
+func (m Mixed) Get(args Args, reply *int) error {
+	*reply = m.n
+	return nil
+}
+
+func (m *Mixed) Set(args Args, reply *int) error {
+	m.n = args.Num1
+	*reply = m.n
+	return nil
+}
+
+// PtrOnly 只有指针接收者的方法
+type PtrOnly struct{}
+
+func (p *PtrOnly) Ping(args Args, reply *int) error {
+	return nil
+}
+
+func TestNewService_Receivers(t *testing.T) {
+	byValue, _ := NewService(Mixed{})
+	_assert(len(byValue.Method) == 1 && byValue.Method["Get"] != nil, "expect only Get by value, got %v", byValue.Method)
+	_assert(byValue.Validate() == nil, "expect a value with methods to be valid")
+	_assert(reflect.DeepEqual(byValue.PointerMethods(), []string{"Set"}), "expect Set to be reported, got %v", byValue.PointerMethods())
+
+	byPtr, _ := NewService(&Mixed{})
+	_assert(len(byPtr.Method) == 2 && byPtr.Method["Set"] != nil, "expect Get and Set by pointer, got %v", byPtr.Method)
+	_assert(len(byPtr.PointerMethods()) == 0, "expect nothing to be reported by pointer, got %v", byPtr.PointerMethods())
+
+	ptrOnly, _ := NewService(PtrOnly{})
+	err := ptrOnly.Validate()
+	_assert(err != nil && strings.Contains(err.Error(), "pass a pointer"), "expect a hint to pass a pointer, got %v", err)
+	ptrOnly, _ = NewService(&PtrOnly{})
+	_assert(ptrOnly.Validate() == nil, "expect a pointer with methods to be valid")
+}
+
+// unexported 的类型名没有导出
+type unexported int
+
+func (u unexported) Sum(args Args, reply *int) error {
+	return nil
+}
+
+func TestNewService_InvalidName(t *testing.T) {
+	_, err := NewService(unexported(0))
+	_assert(err != nil && strings.Contains(err.Error(), "not a valid service name"), "expect an error for an unexported type, got %v", err)
+	_, err = NewService(&struct{ Foo }{})
+	_assert(err != nil, "expect an error for an anonymous type")
+}
+
+// Ret 的方法以返回值的形式给出 reply
+type Ret int
+
+func (r Ret) Sum(args Args) (int, error) {
+	return args.Num1 + args.Num2, nil
+}
+
+func (r Ret) Scale(ctx context.Context, args Args) (int, error) {
+	return (args.Num1 + args.Num2) * ctx.Value(ctxKey{}).(int), nil
+}
+
+// Find 在 Num1 为负数时返回 nil
+func (r Ret) Find(args Args) (*Args, error) {
+	if args.Num1 < 0 {
+		return nil, nil
+	}
+	return &args, nil
+}
+
+func (r Ret) Fail(args Args) (int, error) {
+	return 1, errors.New("ret failed")
+}
+
+// Pair 的第二个返回值不是 error，不会被注册
+func (r Ret) Pair(args Args) (int, int) {
+	return args.Num1, args.Num2
+}
+
+func TestMethodType_CallReturns(t *testing.T) {
+	s, _ := NewService(Ret(0))
+	_assert(reflect.DeepEqual(s.MethodNames(), []string{"Fail", "Find", "Scale", "Sum"}), "wrong methods, got %v", s.MethodNames())
+	_assert(s.Method["Sum"].ReplyType == reflect.TypeOf(new(int)), "expect Sum's reply to be *int, got %v", s.Method["Sum"].ReplyType)
+	_assert(s.Method["Find"].ReplyType == reflect.TypeOf(new(Args)), "expect Find's reply to be *Args, got %v", s.Method["Find"].ReplyType)
+
+	call := func(ctx context.Context, method string, args Args) (reflect.Value, error) {
+		mType := s.Method[method]
+		argv, replyv := mType.NewArgv(), mType.NewReplyv()
+		argv.Set(reflect.ValueOf(args))
+		return replyv, s.Call(ctx, mType, argv, replyv)
+	}
+	replyv, err := call(context.Background(), "Sum", Args{Num1: 1, Num2: 3})
+	_assert(err == nil && *replyv.Interface().(*int) == 4, "failed to call Ret.Sum: %v", err)
+	replyv, err = call(context.WithValue(context.Background(), ctxKey{}, 10), "Scale", Args{Num1: 1, Num2: 3})
+	_assert(err == nil && *replyv.Interface().(*int) == 40, "failed to call Ret.Scale: %v", err)
+	replyv, err = call(context.Background(), "Find", Args{Num1: 1, Num2: 3})
+	_assert(err == nil && *replyv.Interface().(*Args) == Args{Num1: 1, Num2: 3}, "failed to call Ret.Find: %v", err)
+	replyv, err = call(context.Background(), "Find", Args{Num1: -1})
+	_assert(err == nil && *replyv.Interface().(*Args) == Args{}, "expect a nil reply to leave the zero value, got %v (%v)", replyv, err)
+	replyv, err = call(context.Background(), "Fail", Args{})
+	_assert(err != nil && *replyv.Interface().(*int) == 0, "expect the reply to be ignored on error, got %v (%v)", replyv, err)
+}
+
+type Shapes int
+
+type Item struct{ Name string }
+
+func (s Shapes) Strings(n int, reply *[]string) error {
+	*reply = append(*reply, strings.Repeat("s", n))
+	return nil
+}
+
+func (s Shapes) Counts(n int, reply *map[string][]int) error {
+	(*reply)["n"] = append((*reply)["n"], n)
+	return nil
+}
+
+func (s Shapes) Items(n int, reply *[]*Item) error {
+	*reply = append(*reply, &Item{Name: "item"})
+	return nil
+}
+
+func (s Shapes) Item(n int, reply **Item) error {
+	(*reply).Name = "item"
+	return nil
+}
+
+func TestMethodType_NewReplyv(t *testing.T) {
+	s, _ := NewService(Shapes(0))
+	tests := []struct {
+		method string
+		want   interface{}
+	}{
+		{"Strings", &[]string{"s"}},
+		{"Counts", &map[string][]int{"n": {1}}},
+		{"Items", &[]*Item{{Name: "item"}}},
+		{"Item", func() **Item { item := &Item{Name: "item"}; return &item }()},
+	}
+	for _, tt := range tests {
+		mType := s.Method[tt.method]
+		argv, replyv := mType.NewArgv(), mType.NewReplyv()
+		argv.Set(reflect.ValueOf(1))
+		if err := s.Call(context.Background(), mType, argv, replyv); err != nil {
+			t.Fatalf("%s: %v", tt.method, err)
+		}
+		if !reflect.DeepEqual(replyv.Interface(), tt.want) {
+			t.Fatalf("%s: expect %v, got %v", tt.method, tt.want, replyv.Elem())
+		}
+	}
+}
+
+func BenchmarkService_Call(b *testing.B) {
+	var foo Foo
+	s, _ := NewService(&foo)
+	for _, name := range []string{"Sum", "Scale"} {
+		mType := s.Method[name]
+		ctx := context.WithValue(context.Background(), ctxKey{}, 2)
+		argv := mType.NewArgv()
+		argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
+		replyv := mType.NewReplyv()
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := s.Call(ctx, mType, argv, replyv); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
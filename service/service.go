@@ -1,10 +1,16 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"go/ast"
-	"log"
 	"reflect"
+	"sort"
+	"strings"
 	"sync/atomic"
+
+	"xxrpc/xxcode"
 )
 
 type Service struct {
@@ -12,56 +18,175 @@ type Service struct {
 	Typ    reflect.Type           //结构体的类型
 	Rcvr   reflect.Value          //结构体的实例本身
 	Method map[string]*MethodType //存储映射的结构体的所有符合条件的方法
+	Prefix string                 //不为空时只注册以 Prefix 开头的方法，注册名去掉 Prefix
+}
+
+// 入参是任意需要映射为服务的结构体实例，类型名没有导出（包括匿名类型）时返回错误
+func NewService(rcvr interface{}) (*Service, error) {
+	return NewServiceWithPrefix(rcvr, "")
+}
+
+// NewServiceWithPrefix 与 NewService 相同，但只注册名称以 prefix 开头的方法
+func NewServiceWithPrefix(rcvr interface{}, prefix string) (*Service, error) {
+	return NewNamedService(rcvr, "", prefix)
 }
 
-// 入参是任意需要映射为服务的结构体实例
-func NewService(rcvr interface{}) *Service {
-	s := new(Service)
+// NewNamedService 与 NewServiceWithPrefix 相同，但以 name 作为服务名，
+// name 为空时使用类型名。指定 name 时类型本身不需要导出。
+func NewNamedService(rcvr interface{}, name, prefix string) (*Service, error) {
+	s := &Service{Name: name, Prefix: prefix}
 	s.Typ = reflect.TypeOf(rcvr)
 	s.Rcvr = reflect.ValueOf(rcvr)
-	s.Name = reflect.Indirect(s.Rcvr).Type().Name()
-	if !ast.IsExported(s.Name) {
-		log.Fatalf("rpc server: %s is not a valid service name", s.Name)
+	if s.Name == "" {
+		s.Name = reflect.Indirect(s.Rcvr).Type().Name()
+		if !ast.IsExported(s.Name) {
+			return nil, fmt.Errorf("rpc server: %q is not a valid service name", s.Name)
+		}
 	}
 	s.RegisterMethods()
-	return s
+	return s, nil
 }
 
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// RegisterMethods 注册以下四种签名的方法：
+//   - func (t *T) MethodName(argType T1, replyType *T2) error
+//   - func (t *T) MethodName(ctx context.Context, argType T1, replyType *T2) error
+//   - func (t *T) MethodName(argType T1) (T2, error)
+//   - func (t *T) MethodName(ctx context.Context, argType T1) (T2, error)
+//
+// 后两种方法返回 reply，对客户端来说与 reply 类型为 *T2（T2 本身是指针时为 T2）的前两种相同。
+// 设置了 Prefix 时，方法以去掉 Prefix 后的名称注册，例如 RPCGetUser 注册为 GetUser。
+// 与 Go 的方法集一致，传入值时只注册值接收者的方法，传入指针时值接收者和指针接收者的方法都会注册，
+// 传入值时因接收者为指针而被忽略的方法见 PointerMethods。
 func (s *Service) RegisterMethods() {
-	s.Method = make(map[string]*MethodType)
-	for i := 0; i < s.Typ.NumMethod(); i++ {
-		method := s.Typ.Method(i)
+	s.Method = s.suitableMethods(s.Typ)
+}
+
+// MethodNames 返回已注册的方法名，按字典序排列
+func (s *Service) MethodNames() []string {
+	return sortedNames(s.Method)
+}
+
+// PointerMethods 返回因接收者为指针而没有注册的方法名，按字典序排列，只在传入值时不为空
+func (s *Service) PointerMethods() []string {
+	if s.Typ.Kind() == reflect.Ptr {
+		return nil
+	}
+	var names []string
+	for _, name := range sortedNames(s.suitableMethods(reflect.PtrTo(s.Typ))) {
+		if s.Method[name] == nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func sortedNames(methods map[string]*MethodType) []string {
+	names := make([]string, 0, len(methods))
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// suitableMethods 返回 typ 的方法集中符合签名要求的方法
+func (s *Service) suitableMethods(typ reflect.Type) map[string]*MethodType {
+	methods := make(map[string]*MethodType)
+	for i := 0; i < typ.NumMethod(); i++ {
+		method := typ.Method(i)
+		name, ok := strings.CutPrefix(method.Name, s.Prefix)
+		if !ok || name == "" {
+			continue
+		}
 		mType := method.Type
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		// 返回 reply 的方法少一个参数
+		returns := mType.NumOut() == 2
+		if mType.NumOut() != 1 && !returns || mType.Out(mType.NumOut()-1) != typeOfError {
 			continue
 		}
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		numIn := 3
+		if returns {
+			numIn = 2
+		}
+		wantsCtx := mType.NumIn() == numIn+1 && mType.In(1) == typeOfContext
+		if mType.NumIn() != numIn && !wantsCtx {
 			continue
 		}
-		argType, replyType := mType.In(1), mType.In(2)
+		argType := mType.In(mType.NumIn() - 1)
+		var replyType reflect.Type
+		if returns {
+			replyType = mType.Out(0)
+			if replyType.Kind() != reflect.Ptr {
+				replyType = reflect.PtrTo(replyType)
+			}
+		} else {
+			argType, replyType = mType.In(mType.NumIn()-2), mType.In(mType.NumIn()-1)
+		}
 		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
 			continue
 		}
-		s.Method[method.Name] = &MethodType{
+		methods[name] = &MethodType{
 			Method:    method,
 			ArgType:   argType,
 			ReplyType: replyType,
+			ReplyHash: xxcode.TypeHash(replyType),
+			WantsCtx:  wantsCtx,
+			Returns:   returns,
 		}
-		log.Printf("rpc server: register %s.%s\n", s.Name, method.Name)
 	}
+	return methods
+}
+
+// Validate 在服务没有任何可注册的方法时返回错误，只有指针接收者的方法时提示传入指针
+func (s *Service) Validate() error {
+	if len(s.Method) > 0 {
+		return nil
+	}
+	if len(s.PointerMethods()) > 0 {
+		return errors.New("rpc: type " + s.Name + " has no exported methods of suitable type (hint: pass a pointer to value of that type)")
+	}
+	return errors.New("rpc: type " + s.Name + " has no exported methods of suitable type")
 }
 
 func isExportedOrBuiltinType(t reflect.Type) bool {
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
 
-// 通过反射值调用方法
-func (s *Service) Call(m *MethodType, argv, replyv reflect.Value) error {
+// 通过反射值调用方法，方法接受 context.Context 时将 ctx 作为第一个参数传入。
+// 方法返回 reply 时，成功后将返回值写入 replyv，返回 nil 指针时 replyv 保持零值。
+func (s *Service) Call(ctx context.Context, m *MethodType, argv, replyv reflect.Value) error {
 	atomic.AddUint64(&m.NumCalls, 1)
-	f := m.Method.Func
-	returnValues := f.Call([]reflect.Value{s.Rcvr, argv, replyv})
-	if errInter := returnValues[0].Interface(); errInter != nil {
-		return errInter.(error)
+	// 参数最多 4 个，使用栈上的数组避免每次调用分配 slice
+	var buf [4]reflect.Value
+	in := append(buf[:0], s.Rcvr)
+	if m.WantsCtx {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	in = append(in, argv)
+	if !m.Returns {
+		in = append(in, replyv)
+	}
+	returnValues := m.Method.Func.Call(in)
+	if errv := returnValues[len(returnValues)-1]; !errv.IsNil() {
+		return errv.Interface().(error)
+	}
+	if m.Returns {
+		reply := returnValues[0]
+		if reply.Kind() == reflect.Ptr {
+			if reply.IsNil() {
+				return nil
+			}
+			reply = reply.Elem()
+		}
+		replyv.Elem().Set(reply)
 	}
 	return nil
 }
@@ -0,0 +1,182 @@
+package xxcode
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// 握手时通过 Option.Compression 协商的压缩算法
+const (
+	CompressNone = "none" // 与空字符串相同，不压缩
+	CompressGzip = "gzip"
+)
+
+// CompressThreshold 是压缩 body 的最小编码长度，更小的 body 按原样发送
+const CompressThreshold = 1024
+
+var _ Code = (*CompressCode)(nil)
+var _ ReadLimiter = (*CompressCode)(nil)
+var _ Releaser = (*CompressCode)(nil)
+var _ RawBytesWriter = (*CompressCode)(nil)
+
+// CompressCode 包装一个编解码器，压缩较大的 body，header 总是按原样发送。
+//
+// 压缩时先用 typ 对应的格式单独编码 body，压缩后作为 bytes 交给被包装的编解码器发送，
+// 并在 header 中设置 Compressed；读取时根据 header 的 Compressed 决定是否解压，
+// 因此未压缩的消息与不使用 CompressCode 时完全相同。
+type CompressCode struct {
+	Code              // 被包装的编解码器
+	typ        Type   // body 的编码格式，FallbackCode 选定编解码器后以其 Type 为准
+	compressed bool   // 最近一次 ReadHeader 读到的 Compressed
+	algo       string // 压缩算法
+	limit      int64  // 解压后 body 的最大字节数，见 SetReadLimit
+}
+
+// NewCompressCode 使用 algo 压缩 cc 的 body，typ 为 cc 的编码格式，algo 为空或 CompressNone 时原样返回 cc
+func NewCompressCode(cc Code, typ Type, algo string) (Code, error) {
+	switch algo {
+	case "", CompressNone:
+		return cc, nil
+	case CompressGzip:
+		return &CompressCode{Code: cc, typ: typ, algo: algo}, nil
+	default:
+		return nil, fmt.Errorf("rpc: unsupported compression %q", algo)
+	}
+}
+
+func (c *CompressCode) codeType() Type {
+	if tc, ok := c.Code.(interface{ Type() Type }); ok {
+		return tc.Type()
+	}
+	return c.typ
+}
+
+// SetReadLimit 限制被包装的编解码器读取的消息，同时限制解压后的 body 不超过 n 字节
+func (c *CompressCode) SetReadLimit(n int64) {
+	c.limit = n
+	SetReadLimit(c.Code, n)
+}
+
+// SetRawBytes 设置被包装的编解码器，压缩后的 body 同样是 []byte，也会原样写出
+func (c *CompressCode) SetRawBytes(on bool) {
+	SetRawBytes(c.Code, on)
+}
+
+// Release 归还被包装的编解码器的缓冲
+func (c *CompressCode) Release() {
+	ReleaseCode(c.Code)
+}
+
+func (c *CompressCode) ReadHeader(h *Header) error {
+	h.Compressed = false // gob 不写入零值，复用的 header 会保留上一条消息的 Compressed
+	err := c.Code.ReadHeader(h)
+	c.compressed = err == nil && h.Compressed
+	return err
+}
+
+func (c *CompressCode) ReadBody(body interface{}) error {
+	if !c.compressed || body == nil {
+		return c.Code.ReadBody(body)
+	}
+	var data []byte
+	if c.codeType() == Type_Proto {
+		var v wrapperspb.BytesValue
+		if err := c.Code.ReadBody(&v); err != nil {
+			return err
+		}
+		data = v.Value
+	} else if err := c.Code.ReadBody(&data); err != nil {
+		return err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("rpc: gzip error decoding body: %w", err)
+	}
+	var r io.Reader = zr
+	if c.limit > 0 {
+		r = io.LimitReader(zr, c.limit+1)
+	}
+	if data, err = io.ReadAll(r); err != nil {
+		return fmt.Errorf("rpc: gzip error decoding body: %w", err)
+	}
+	if c.limit > 0 && int64(len(data)) > c.limit {
+		return ErrMessageTooLarge
+	}
+	return c.unmarshal(data, body)
+}
+
+func (c *CompressCode) Write(h *Header, body interface{}) error {
+	h.Compressed = false
+	if _, empty := body.(struct{}); h.Error != "" || body == nil || empty {
+		return c.Code.Write(h, body)
+	}
+	data, err := c.marshal(body)
+	if err != nil || len(data) < CompressThreshold {
+		// 编码失败时交给被包装的编解码器报告错误
+		return c.Code.Write(h, body)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write(data)
+	if err = zw.Close(); err != nil {
+		return fmt.Errorf("rpc: gzip error encoding body: %w", err)
+	}
+	h.Compressed = true
+	if c.codeType() == Type_Proto {
+		return c.Code.Write(h, wrapperspb.Bytes(buf.Bytes()))
+	}
+	return c.Code.Write(h, buf.Bytes())
+}
+
+// marshal 将 body 单独编码为一个完整的消息，gob 的类型信息随每个 body 发送
+func (c *CompressCode) marshal(body interface{}) ([]byte, error) {
+	if raw, ok := body.(RawBody); ok {
+		if t := c.codeType(); t != Type_Proto && t != Type_Json && t != Type_Framed {
+			return nil, ErrRawUnsupported
+		}
+		return raw, nil
+	}
+	switch c.codeType() {
+	case Type_Proto:
+		m, ok := body.(proto.Message)
+		if !ok {
+			return nil, fmt.Errorf("rpc: proto codec: %T does not implement proto.Message", body)
+		}
+		return proto.Marshal(m)
+	case Type_Json, Type_Framed:
+		return json.Marshal(body)
+	default:
+		var buf bytes.Buffer
+		err := gob.NewEncoder(&buf).Encode(body)
+		return buf.Bytes(), err
+	}
+}
+
+func (c *CompressCode) unmarshal(data []byte, body interface{}) error {
+	if raw, ok := body.(*RawBody); ok {
+		if t := c.codeType(); t != Type_Proto && t != Type_Json && t != Type_Framed {
+			return ErrRawUnsupported // 与 gob 一样不能单独解码
+		}
+		*raw = data
+		return nil
+	}
+	switch c.codeType() {
+	case Type_Proto:
+		m, ok := body.(proto.Message)
+		if !ok {
+			return fmt.Errorf("rpc: proto codec: %T does not implement proto.Message", body)
+		}
+		return proto.Unmarshal(data, m)
+	case Type_Json, Type_Framed:
+		return json.Unmarshal(data, body)
+	default:
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(body)
+	}
+}
@@ -0,0 +1,157 @@
+package xxcode
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var _ Code = (*FallbackCode)(nil)
+var _ Releaser = (*FallbackCode)(nil)
+var _ RawBytesWriter = (*FallbackCode)(nil)
+
+// FallbackCode 在连接的第一个请求上依次尝试多个编解码器，选定第一个能够解码该请求的编解码器，
+// 之后的所有消息都使用它。用于编解码器迁移期间同时兼容新旧客户端。
+//
+// 由于 body 没有长度前缀，每次尝试都需要从头重放已读取的字节，因此只能在第一个请求上回退；
+// 同时要求排在前面的编解码器在遇到无法识别的数据时能够立即报错，而不是等待更多数据。
+type FallbackCode struct {
+	Code   // 当前使用的编解码器
+	conn   io.ReadWriteCloser
+	r      *replayReader
+	types  []Type
+	funcs  map[Type]NewCodeFunc // 创建 types 中的编解码器
+	idx    int                  // 当前编解码器在 types 中的位置
+	chosen bool                 // 已经选定编解码器
+	limit  int64                // 见 SetReadLimit，切换编解码器时同样生效
+	raw    bool                 // 见 SetRawBytes，切换编解码器时同样生效
+}
+
+// NewFallbackCode 按 types 的顺序尝试编解码器，types 中的每个 Type 都必须已注册
+func NewFallbackCode(conn io.ReadWriteCloser, types []Type) (*FallbackCode, error) {
+	return NewFallbackCodeFuncs(conn, types, CodeFuncs())
+}
+
+// NewFallbackCodeFuncs 与 NewFallbackCode 相同，但从 funcs 而不是 NewCodeFuncMap 中创建编解码器
+func NewFallbackCodeFuncs(conn io.ReadWriteCloser, types []Type, funcs map[Type]NewCodeFunc) (*FallbackCode, error) {
+	for _, t := range types {
+		if funcs[t] == nil {
+			return nil, fmt.Errorf("rpc: invalid codec type %s", t)
+		}
+	}
+	if len(types) == 0 {
+		return nil, errors.New("rpc: no codec to fall back to")
+	}
+	c := &FallbackCode{conn: conn, r: &replayReader{r: conn, recording: true}, types: types, funcs: funcs}
+	c.use(0)
+	return c, nil
+}
+
+// Type 返回当前使用的编解码器类型
+func (c *FallbackCode) Type() Type {
+	return c.types[c.idx]
+}
+
+// use 切换到 types[i]，并从头重放已读取的字节
+func (c *FallbackCode) use(i int) {
+	c.idx = i
+	c.r.rewind()
+	c.Code = c.funcs[c.types[i]](&replayConn{r: c.r, ReadWriteCloser: c.conn})
+	if c.limit > 0 {
+		SetReadLimit(c.Code, c.limit)
+	}
+	if c.raw {
+		SetRawBytes(c.Code, true)
+	}
+}
+
+func (c *FallbackCode) SetRawBytes(on bool) {
+	c.raw = on
+	SetRawBytes(c.Code, on)
+}
+
+func (c *FallbackCode) SetReadLimit(n int64) {
+	c.limit = n
+	SetReadLimit(c.Code, n)
+}
+
+// Release 归还当前编解码器的缓冲，之前尝试过的编解码器已经被丢弃
+func (c *FallbackCode) Release() {
+	ReleaseCode(c.Code)
+}
+
+func (c *FallbackCode) ReadHeader(h *Header) error {
+	if c.chosen {
+		return c.Code.ReadHeader(h)
+	}
+	err := c.Code.ReadHeader(h)
+	for err != nil && c.idx+1 < len(c.types) {
+		c.use(c.idx + 1)
+		*h = Header{}
+		err = c.Code.ReadHeader(h)
+	}
+	return err
+}
+
+func (c *FallbackCode) ReadBody(body interface{}) error {
+	if c.chosen {
+		return c.Code.ReadBody(body)
+	}
+	err := c.Code.ReadBody(body)
+	for err != nil && c.idx+1 < len(c.types) {
+		c.use(c.idx + 1)
+		var h Header
+		if c.Code.ReadHeader(&h) != nil {
+			continue
+		}
+		if c.Code.ReadBody(body) == nil {
+			err = nil
+		}
+	}
+	if err == nil {
+		c.chosen = true
+		c.r.stopRecording()
+	}
+	return err
+}
+
+// replayReader 记录读取的字节，rewind 后先重放记录的字节再继续读取 r
+type replayReader struct {
+	r         io.Reader
+	buf       []byte
+	pos       int
+	recording bool
+}
+
+func (r *replayReader) rewind() {
+	r.pos = 0
+}
+
+func (r *replayReader) stopRecording() {
+	r.recording = false
+	r.buf = r.buf[r.pos:]
+	r.pos = 0
+}
+
+func (r *replayReader) Read(p []byte) (int, error) {
+	if r.pos < len(r.buf) {
+		n := copy(p, r.buf[r.pos:])
+		r.pos += n
+		return n, nil
+	}
+	n, err := r.r.Read(p)
+	if r.recording {
+		r.buf = append(r.buf, p[:n]...)
+		r.pos = len(r.buf)
+	}
+	return n, err
+}
+
+type replayConn struct {
+	r io.Reader
+	io.ReadWriteCloser
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,148 @@
+package xxcode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+var _ Code = (*FramedCode)(nil)
+var _ ReadLimiter = (*FramedCode)(nil)
+var _ Releaser = (*FramedCode)(nil)
+
+// FramedCode 与 JsonCode 一样使用 JSON 编码 header 和 body，但每个 header 和 body 各占一帧，
+// 帧以 4 字节大端长度开头：
+//
+//	header 帧: json(Header)
+//	body 帧:   json(body)
+//
+// 读取方不解码也能跳过整条消息，ReadBody(nil) 只丢弃 body 帧的字节；
+// 格式错误的 body 只会导致该消息解码失败，不会影响之后的消息。
+type FramedCode struct {
+	conn  io.ReadWriteCloser
+	buf   *bufio.Writer
+	r     *bufio.Reader
+	limit msgLimit
+}
+
+func NewFramedCode(conn io.ReadWriteCloser) Code {
+	return &FramedCode{
+		conn: conn,
+		buf:  getWriter(conn),
+		r:    getReader(conn),
+	}
+}
+
+func (c *FramedCode) Close() error {
+	return c.conn.Close()
+}
+
+func (c *FramedCode) Release() {
+	putWriter(c.buf)
+	putReader(c.r)
+	c.buf, c.r = nil, nil
+}
+
+func (c *FramedCode) SetReadLimit(n int64) {
+	c.limit.max = n
+}
+
+// frameSize 读取下一帧的长度，超出消息的大小限制时返回 ErrMessageTooLarge
+func (c *FramedCode) frameSize() (int, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return 0, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if err := c.limit.add(int64(len(size)) + int64(n)); err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+func (c *FramedCode) readFrame() ([]byte, error) {
+	n, err := c.frameSize()
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, n)
+	if _, err = io.ReadFull(c.r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (c *FramedCode) writeFrame(frame []byte) error {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(frame)))
+	if _, err := c.buf.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := c.buf.Write(frame)
+	return err
+}
+
+func (c *FramedCode) ReadHeader(h *Header) error {
+	c.limit.reset()
+	frame, err := c.readFrame()
+	if err != nil {
+		return err
+	}
+	*h = Header{}
+	if err = json.Unmarshal(frame, h); err != nil {
+		return fmt.Errorf("rpc: framed error decoding header: %w", err)
+	}
+	return nil
+}
+
+func (c *FramedCode) ReadBody(body interface{}) error {
+	if body == nil {
+		n, err := c.frameSize()
+		if err != nil {
+			return err
+		}
+		_, err = c.r.Discard(n)
+		return err
+	}
+	frame, err := c.readFrame()
+	if err != nil {
+		return err
+	}
+	if raw, ok := body.(*RawBody); ok {
+		*raw = frame
+		return nil
+	}
+	if err = json.Unmarshal(frame, body); err != nil {
+		return fmt.Errorf("rpc: framed error decoding body: %w", err)
+	}
+	return nil
+}
+
+func (c *FramedCode) Write(h *Header, body interface{}) (err error) {
+	head, err := json.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("rpc: framed error encoding header: %w", err)
+	}
+	frame, err := json.Marshal(body)
+	if err != nil {
+		// 还没有写入任何数据，连接仍然可用
+		return fmt.Errorf("rpc: framed error encoding body: %w", err)
+	}
+	defer func() {
+		_ = c.buf.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	if err = c.writeFrame(head); err != nil {
+		err = fmt.Errorf("rpc: framed error encoding header: %w", err)
+		return
+	}
+	if err = c.writeFrame(frame); err != nil {
+		err = fmt.Errorf("rpc: framed error encoding body: %w", err)
+		return
+	}
+	return
+}
@@ -2,26 +2,66 @@ package xxcode
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"fmt"
 	"io"
-	"log"
+	"math"
+	"reflect"
+	"sync/atomic"
 )
 
 var _ Code = (*GobCode)(nil)
+var _ Capturer = (*GobCode)(nil)
+var _ ReadLimiter = (*GobCode)(nil)
+var _ Releaser = (*GobCode)(nil)
+var _ RawBytesWriter = (*GobCode)(nil)
 
 type GobCode struct {
 	conn io.ReadWriteCloser //由构建函数传入，通常是通过 TCP 或者 Unix 建立 socket 时得到的链接实例
 	buf  *bufio.Writer      //防止阻塞而创建的带缓冲的 Writer，一般这么做能提升性能。
 	dec  *gob.Decoder       // decoder
 	enc  *gob.Encoder       // encoder
+	r    *recordReader      // 记录 decoder 读取的字节，用于 Capturer
+	w    *recordWriter      // 记录 encoder 写入的字节，用于 Capturer
+
+	rawBytes bool    // 原样写出 []byte body，见 SetRawBytes
+	rawBody  bool    // 最近一次 ReadHeader 读到的 RawBytes
+	rawSize  [4]byte // 写出 []byte body 的长度，放在这里避免每次分配
+}
+
+// RegisterGobTypes 通过 gob.Register 登记 values 的具体类型，使 gob 能够编解码存放在接口类型
+// （包括 interface{}）的参数和 reply 中的这些值。gob 的登记是全局的，对所有连接生效，
+// 编码方和解码方都需要登记。重复登记同一类型没有影响，与已登记的类型名冲突时返回错误。
+func RegisterGobTypes(values ...interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("rpc: register gob type: %v", r)
+		}
+	}()
+	for _, v := range values {
+		if v == nil {
+			return fmt.Errorf("rpc: register gob type: nil value")
+		}
+		gob.Register(v)
+	}
+	return nil
 }
 
 func NewGobCode(conn io.ReadWriteCloser) Code {
+	buf := getWriter(conn)
+	// 读取同样经过缓冲，header 和 body 通常一次系统调用读入。
+	// Option 握手在创建编解码器之前完成，服务端会把握手时多读的数据交给 conn，不会滞留在缓冲之外。
+	r := &recordReader{Reader: getReader(conn)}
+	w := &recordWriter{w: buf}
 	return &GobCode{
 		conn: conn,
-		buf:  bufio.NewWriter(conn),
-		dec:  gob.NewDecoder(conn),
-		enc:  gob.NewEncoder(conn),
+		buf:  buf,
+		dec:  gob.NewDecoder(r),
+		enc:  gob.NewEncoder(w),
+		r:    r,
+		w:    w,
 	}
 }
 
@@ -29,28 +69,267 @@ func (c *GobCode) Close() error {
 	return c.conn.Close()
 }
 
+func (c *GobCode) Release() {
+	putWriter(c.buf)
+	putReader(c.r.Reader)
+	c.buf, c.r.Reader = nil, nil
+}
+
 func (c *GobCode) ReadHeader(h *Header) error {
-	return c.dec.Decode(h)
+	c.r.reset()
+	h.RawBytes = false // gob 不写入零值，复用的 header 会保留上一条消息的 RawBytes
+	err := c.dec.Decode(h)
+	c.rawBody = err == nil && h.RawBytes
+	return err
+}
+
+// SetRawBytes 开启后 []byte 和 *[]byte body 以 4 字节大端长度加上字节本身写出，不经过 gob 编码，
+// 代理和传输大块数据时省去 gob 对字节的再次编码。读取这样的 body 不需要开启。
+func (c *GobCode) SetRawBytes(on bool) {
+	c.rawBytes = on
+}
+
+func (c *GobCode) SetReadLimit(n int64) {
+	c.r.limit.max = n
 }
 
 func (c *GobCode) ReadBody(body interface{}) error {
+	if c.rawBody {
+		return c.readRawBytes(body)
+	}
+	if _, ok := body.(*RawBody); ok {
+		// gob 只能按类型解码，这里丢弃消息体保持流对齐
+		if err := c.dec.DecodeValue(reflect.Value{}); err != nil {
+			return err
+		}
+		return ErrRawUnsupported
+	}
 	return c.dec.Decode(body)
 }
 
 func (c *GobCode) Write(h *Header, body interface{}) (err error) {
+	if _, ok := body.(RawBody); ok {
+		return ErrRawUnsupported // 还没有写入任何数据，连接仍然可用
+	}
 	defer func() {
 		_ = c.buf.Flush()
 		if err != nil {
 			_ = c.Close()
 		}
 	}()
+	data, raw := rawBytes(body)
+	h.RawBytes = raw && c.rawBytes
 	if err = c.enc.Encode(h); err != nil {
-		log.Println("rpc: gob error encoding header:", err)
+		err = fmt.Errorf("rpc: gob error encoding header: %w", err)
+		return
+	}
+	if h.RawBytes {
+		binary.BigEndian.PutUint32(c.rawSize[:], uint32(len(data)))
+		if _, err = c.w.Write(c.rawSize[:]); err == nil {
+			_, err = c.w.Write(data)
+		}
+		if err != nil {
+			err = fmt.Errorf("rpc: gob error encoding body: %w", err)
+		}
 		return
 	}
 	if err = c.enc.Encode(body); err != nil {
-		log.Println("rpc: gob error encoding body:", err)
+		err = fmt.Errorf("rpc: gob error encoding body: %w", err)
 		return
 	}
 	return
 }
+
+// rawBytes 返回可以原样写出的 []byte body
+func rawBytes(body interface{}) ([]byte, bool) {
+	var data []byte
+	switch b := body.(type) {
+	case []byte:
+		data = b
+	case *[]byte:
+		if b == nil {
+			return nil, false
+		}
+		data = *b
+	default:
+		return nil, false
+	}
+	return data, uint64(len(data)) <= math.MaxUint32
+}
+
+// readRawBytes 读取 header 标记为 RawBytes 的 body，body 为 nil 时丢弃。
+// 与 gob 解码 slice 一样，*[]byte 容量足够时复用它的底层数组。
+func (c *GobCode) readRawBytes(body interface{}) error {
+	c.rawBody = false
+	var dst []byte
+	if b, ok := body.(*[]byte); ok {
+		dst = *b
+	}
+	data, err := c.r.readRaw(dst)
+	if err != nil {
+		return err
+	}
+	switch b := body.(type) {
+	case nil:
+		return nil
+	case *[]byte:
+		*b = data
+		return nil
+	case *RawBody:
+		return ErrRawUnsupported
+	default:
+		return fmt.Errorf("rpc: gob: can't read a []byte body into %T", body)
+	}
+}
+
+func (c *GobCode) WriteCaptured(h *Header, body interface{}) ([]byte, error) {
+	c.w.rec = new(bytes.Buffer)
+	defer func() { c.w.rec = nil }()
+	err := c.Write(h, body)
+	return c.w.rec.Bytes(), err
+}
+
+func (c *GobCode) CaptureReads() {
+	c.r.on.Add(1)
+}
+
+func (c *GobCode) Captured() []byte {
+	// rec 在下一次 ReadHeader 时被重置，返回副本
+	b := append([]byte(nil), c.r.rec.Bytes()...)
+	if n := c.r.on.Add(-1); n <= 0 {
+		c.r.on.CompareAndSwap(n, 0)
+		c.r.rec = bytes.Buffer{} // 不再记录，释放可能很大的缓冲
+	}
+	return b
+}
+
+// recordReader 实现 io.ByteReader，使 gob.Decoder 不再额外包装缓冲，从而只读取每条消息所需的字节。
+// 设置了 limit 时跟踪 gob 消息的长度前缀，使超出限制的消息在 gob 按声明的长度分配缓冲之前就被拒绝。
+type recordReader struct {
+	*bufio.Reader
+	on  atomic.Int32 // 等待抓取响应的消息数，大于 0 时记录
+	rec bytes.Buffer
+
+	limit   msgLimit
+	payload int64   // 当前 gob 消息还没有读取的字节数
+	prefix  int     // 长度前缀还没有读取的字节数
+	count   uint64  // 正在读取的长度前缀
+	rawSize [4]byte // 原样写出的 []byte body 的长度，见 readRaw
+}
+
+func (r *recordReader) reset() {
+	r.rec.Reset()
+	r.limit.reset()
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if r.on.Load() > 0 {
+		r.rec.Write(p[:n])
+	}
+	if terr := r.track(p[:n]); terr != nil {
+		return n, terr
+	}
+	return n, err
+}
+
+func (r *recordReader) ReadByte() (byte, error) {
+	b, err := r.Reader.ReadByte()
+	if err == nil && r.on.Load() > 0 {
+		r.rec.WriteByte(b)
+	}
+	if err == nil {
+		err = r.track([]byte{b})
+	}
+	return b, err
+}
+
+// track 计入读取的字节 p，并解析其中 gob 消息的长度前缀：
+// 小于 0x80 的字节本身就是长度，否则其相反数为之后大端长度的字节数
+func (r *recordReader) track(p []byte) error {
+	if r.limit.max <= 0 {
+		return nil
+	}
+	if err := r.limit.add(int64(len(p))); err != nil {
+		return err
+	}
+	for len(p) > 0 {
+		switch {
+		case r.payload > 0:
+			k := int64(len(p))
+			if k > r.payload {
+				k = r.payload
+			}
+			r.payload -= k
+			p = p[k:]
+		case r.prefix > 0:
+			r.count = r.count<<8 | uint64(p[0])
+			r.prefix--
+			p = p[1:]
+			if r.prefix == 0 {
+				if err := r.begin(r.count, len(p)); err != nil {
+					return err
+				}
+			}
+		default:
+			b := p[0]
+			p = p[1:]
+			if b <= 0x7f {
+				if err := r.begin(uint64(b), len(p)); err != nil {
+					return err
+				}
+			} else {
+				r.prefix, r.count = -int(int8(b)), 0
+			}
+		}
+	}
+	return nil
+}
+
+// readRaw 读取 GobCode 原样写出的 []byte body：4 字节大端长度和随后的字节，dst 容量足够时读入 dst。
+// 它们不是 gob 消息，因此直接从缓冲读取，不经过 track 对长度前缀的解析，只计入大小限制。
+func (r *recordReader) readRaw(dst []byte) ([]byte, error) {
+	if _, err := io.ReadFull(r.Reader, r.rawSize[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(r.rawSize[:])
+	if err := r.limit.add(int64(len(r.rawSize)) + int64(n)); err != nil {
+		return nil, err
+	}
+	if uint64(cap(dst)) >= uint64(n) {
+		dst = dst[:n]
+	} else {
+		dst = make([]byte, n)
+	}
+	if _, err := io.ReadFull(r.Reader, dst); err != nil {
+		return nil, err
+	}
+	if r.on.Load() > 0 {
+		r.rec.Write(r.rawSize[:])
+		r.rec.Write(dst)
+	}
+	return dst, nil
+}
+
+// begin 开始一条长度为 count 的 gob 消息，buffered 为 p 中已经计入的属于这条消息的字节数
+func (r *recordReader) begin(count uint64, buffered int) error {
+	if count > uint64(r.limit.max) || r.limit.n-int64(buffered)+int64(count) > r.limit.max {
+		return ErrMessageTooLarge
+	}
+	r.payload = int64(count)
+	return nil
+}
+
+// recordWriter 在 rec 不为 nil 时记录写入的字节
+type recordWriter struct {
+	w   io.Writer
+	rec *bytes.Buffer
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if w.rec != nil {
+		w.rec.Write(p[:n])
+	}
+	return n, err
+}
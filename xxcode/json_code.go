@@ -0,0 +1,82 @@
+package xxcode
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+var _ Code = (*JsonCode)(nil)
+var _ ReadLimiter = (*JsonCode)(nil)
+var _ Releaser = (*JsonCode)(nil)
+
+type JsonCode struct {
+	conn io.ReadWriteCloser // 由构建函数传入的链接实例
+	buf  *bufio.Writer      // 带缓冲的 Writer
+	dec  *json.Decoder      // decoder
+	enc  *json.Encoder      // encoder
+	r    *limitReader       // 限制 decoder 读取的字节，见 SetReadLimit
+}
+
+func NewJsonCode(conn io.ReadWriteCloser) Code {
+	buf := getWriter(conn)
+	r := &limitReader{r: conn}
+	return &JsonCode{
+		conn: conn,
+		buf:  buf,
+		dec:  json.NewDecoder(r),
+		enc:  json.NewEncoder(buf),
+		r:    r,
+	}
+}
+
+func (c *JsonCode) Close() error {
+	return c.conn.Close()
+}
+
+// Release 只归还写缓冲，json.Decoder 自己管理读缓冲
+func (c *JsonCode) Release() {
+	putWriter(c.buf)
+	c.buf = nil
+}
+
+func (c *JsonCode) ReadHeader(h *Header) error {
+	c.r.limit.reset()
+	return c.dec.Decode(h)
+}
+
+// SetReadLimit 按 decoder 从连接读取的字节计算，decoder 的预读使限制是近似的
+func (c *JsonCode) SetReadLimit(n int64) {
+	c.r.limit.max = n
+}
+
+// ReadBody 在 body 为 nil 时与 gob 的 Decode(nil) 一致：读出并丢弃一个 body
+func (c *JsonCode) ReadBody(body interface{}) error {
+	if body == nil {
+		var discard json.RawMessage
+		return c.dec.Decode(&discard)
+	}
+	if raw, ok := body.(*RawBody); ok {
+		return c.dec.Decode((*json.RawMessage)(raw))
+	}
+	return c.dec.Decode(body)
+}
+
+func (c *JsonCode) Write(h *Header, body interface{}) (err error) {
+	defer func() {
+		_ = c.buf.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	if err = c.enc.Encode(h); err != nil {
+		err = fmt.Errorf("rpc: json error encoding header: %w", err)
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		err = fmt.Errorf("rpc: json error encoding body: %w", err)
+		return
+	}
+	return
+}
@@ -0,0 +1,71 @@
+package xxcode
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+type body struct {
+	Name  string
+	Items []int
+}
+
+func TestJsonCode_RoundTrip(t *testing.T) {
+	c1, c2 := net.Pipe()
+	w, r := NewJsonCode(c1), NewJsonCode(c2)
+	defer func() { _ = w.Close() }()
+
+	go func() {
+		_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 1}, &body{Name: "a", Items: []int{1, 2}})
+		_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 2, Error: "failed"}, nil)
+		_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 3}, &body{Name: "b"})
+	}()
+
+	var h Header
+	var b body
+	if err := r.ReadHeader(&h); err != nil || h.ServiceMethod != "Foo.Sum" || h.SeqId != 1 {
+		t.Fatalf("unexpected header %+v (%v)", h, err)
+	}
+	if err := r.ReadBody(&b); err != nil || b.Name != "a" || len(b.Items) != 2 {
+		t.Fatalf("unexpected body %+v (%v)", b, err)
+	}
+	// ReadBody(nil) 需要丢弃 body，保证下一个 header 能被正确读取
+	if err := r.ReadHeader(&h); err != nil || h.SeqId != 2 || h.Error != "failed" {
+		t.Fatalf("unexpected header %+v (%v)", h, err)
+	}
+	if err := r.ReadBody(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReadHeader(&h); err != nil || h.SeqId != 3 {
+		t.Fatalf("unexpected header %+v (%v)", h, err)
+	}
+	if err := r.ReadBody(&b); err != nil || b.Name != "b" {
+		t.Fatalf("unexpected body %+v (%v)", b, err)
+	}
+}
+
+// header 的 JSON 编码必须与 Header 文档中的格式完全一致
+func TestJsonCode_HeaderSchema(t *testing.T) {
+	c1, c2 := net.Pipe()
+	w := NewJsonCode(c1)
+	defer func() { _ = w.Close() }()
+
+	go func() {
+		_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 1}, nil)
+	}()
+	line := make([]byte, 0, 128)
+	buf := make([]byte, 128)
+	for !bytes.Contains(line, []byte("\n")) {
+		n, err := c2.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = append(line, buf[:n]...)
+	}
+	want := `{"service_method":"Foo.Sum","seq":1,"error":"","reply_hash":0}`
+	if got := string(line[:bytes.IndexByte(line, '\n')]); got != want {
+		t.Fatalf("expect header %s, got %s", want, got)
+	}
+	_ = c2.Close()
+}
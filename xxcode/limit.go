@@ -0,0 +1,74 @@
+package xxcode
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrMessageTooLarge 表示一条消息（header 和 body）超过了 SetReadLimit 设置的大小。
+// 超出限制后连接上剩余的数据无法再对齐到消息边界，调用方应关闭连接。
+var ErrMessageTooLarge = errors.New("rpc: message too large")
+
+// ReadLimiter 由能够限制读取的消息大小的编解码器实现，内置的编解码器都实现了它
+type ReadLimiter interface {
+	// SetReadLimit 限制之后读取的每条消息的字节数，超出时 ReadHeader 或 ReadBody 返回 ErrMessageTooLarge，
+	// n <= 0 表示不限制。带长度前缀的格式在分配缓冲之前检查声明的长度。
+	SetReadLimit(n int64)
+}
+
+// SetReadLimit 在 cc 实现了 ReadLimiter 时设置读取的消息大小限制，返回是否设置成功
+func SetReadLimit(cc Code, n int64) bool {
+	l, ok := cc.(ReadLimiter)
+	if ok {
+		l.SetReadLimit(n)
+	}
+	return ok
+}
+
+// msgLimit 记录当前消息已经读取的字节数，ReadHeader 开始读取新消息时调用 reset
+type msgLimit struct {
+	max int64 // <= 0 表示不限制
+	n   int64
+}
+
+func (l *msgLimit) reset() {
+	l.n = 0
+}
+
+// add 计入 k 个字节，超出限制时返回 ErrMessageTooLarge
+func (l *msgLimit) add(k int64) error {
+	l.n += k
+	if l.max > 0 && (k < 0 || l.n > l.max) {
+		return ErrMessageTooLarge
+	}
+	return nil
+}
+
+// remaining 返回当前消息还能读取的字节数，不限制时返回 -1
+func (l *msgLimit) remaining() int64 {
+	if l.max <= 0 {
+		return -1
+	}
+	return l.max - l.n
+}
+
+// limitReader 在读取超过当前消息的限制时返回 ErrMessageTooLarge，用于没有长度前缀的格式。
+// 解码器预读的字节计入读取时所在的消息，因此限制是近似的。
+type limitReader struct {
+	r     io.Reader
+	limit msgLimit
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	if rem := r.limit.remaining(); rem >= 0 {
+		if rem == 0 {
+			return 0, ErrMessageTooLarge
+		}
+		if int64(len(p)) > rem {
+			p = p[:rem]
+		}
+	}
+	n, err := r.r.Read(p)
+	r.limit.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,57 @@
+package xxcode
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// Releaser 由可以把读写缓冲归还给共享池的编解码器实现，内置的编解码器都实现了它。
+// 缓冲在所有连接之间复用，连接频繁建立和关闭时不必每次重新分配。
+type Releaser interface {
+	// Release 归还编解码器的缓冲，之后不能再使用这个编解码器。
+	// 调用方需要保证没有其他 goroutine 正在或将要读写它，通常在连接关闭、读写循环都退出之后调用。
+	Release()
+}
+
+// ReleaseCode 在 cc 实现了 Releaser 时归还它的缓冲，返回是否归还
+func ReleaseCode(cc Code) bool {
+	r, ok := cc.(Releaser)
+	if ok {
+		r.Release()
+	}
+	return ok
+}
+
+// 只复用缓冲，gob 的 Encoder 和 Decoder 记录了连接上已经发送过的类型描述，
+// 在另一个连接上复用会使对端无法解码，因此每个连接仍然创建新的实例
+var (
+	writerPool = sync.Pool{New: func() interface{} { return bufio.NewWriter(nil) }}
+	readerPool = sync.Pool{New: func() interface{} { return bufio.NewReader(nil) }}
+)
+
+func getWriter(w io.Writer) *bufio.Writer {
+	buf := writerPool.Get().(*bufio.Writer)
+	buf.Reset(w)
+	return buf
+}
+
+func putWriter(buf *bufio.Writer) {
+	if buf != nil {
+		buf.Reset(nil) // 不保留对连接的引用
+		writerPool.Put(buf)
+	}
+}
+
+func getReader(r io.Reader) *bufio.Reader {
+	buf := readerPool.Get().(*bufio.Reader)
+	buf.Reset(r)
+	return buf
+}
+
+func putReader(buf *bufio.Reader) {
+	if buf != nil {
+		buf.Reset(nil)
+		readerPool.Put(buf)
+	}
+}
@@ -0,0 +1,257 @@
+package xxcode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+)
+
+var _ Code = (*ProtoCode)(nil)
+var _ ReadLimiter = (*ProtoCode)(nil)
+var _ Releaser = (*ProtoCode)(nil)
+
+// ProtoCode 使用 protobuf 编码 body，便于非 Go 语言的服务端或客户端互通。
+//
+// 每条消息由两个帧组成，帧以 4 字节大端长度开头：
+//
+//	header 帧: uvarint len(ServiceMethod) | ServiceMethod | uvarint SeqId | uvarint len(Error) | Error | uvarint ReplyHash
+//	           [| uvarint len(Metadata) | (uvarint len(key) | key | uvarint len(value) | value)...
+//	           [| uvarint flags [| varint ErrorCode] [| varint Deadline]]]，key 按字典序；
+//	           flags 的最低位为 Compressed，第二位、第三位分别表示之后有 ErrorCode、Deadline。
+//	           flags 为 0 时省略，没有元数据且 flags 为 0 时元数据部分一并省略
+//	body 帧:   proto.Marshal(body)，错误响应和 nil body 的帧为空
+//
+// body 必须实现 proto.Message。
+type ProtoCode struct {
+	conn  io.ReadWriteCloser
+	buf   *bufio.Writer
+	r     *bufio.Reader
+	limit msgLimit
+}
+
+func NewProtoCode(conn io.ReadWriteCloser) Code {
+	return &ProtoCode{
+		conn: conn,
+		buf:  getWriter(conn),
+		r:    getReader(conn),
+	}
+}
+
+func (c *ProtoCode) Close() error {
+	return c.conn.Close()
+}
+
+func (c *ProtoCode) Release() {
+	putWriter(c.buf)
+	putReader(c.r)
+	c.buf, c.r = nil, nil
+}
+
+func (c *ProtoCode) SetReadLimit(n int64) {
+	c.limit.max = n
+}
+
+// readFrame 读取下一帧，超出消息的大小限制时在分配缓冲之前返回 ErrMessageTooLarge
+func (c *ProtoCode) readFrame() ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if err := c.limit.add(int64(len(size)) + int64(n)); err != nil {
+		return nil, err
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(c.r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (c *ProtoCode) writeFrame(frame []byte) error {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(frame)))
+	if _, err := c.buf.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := c.buf.Write(frame)
+	return err
+}
+
+func (c *ProtoCode) ReadHeader(h *Header) error {
+	c.limit.reset()
+	frame, err := c.readFrame()
+	if err != nil {
+		return err
+	}
+	readString := func() (string, error) {
+		n, k := binary.Uvarint(frame)
+		if k <= 0 || uint64(len(frame)-k) < n {
+			return "", io.ErrUnexpectedEOF
+		}
+		s := string(frame[k : k+int(n)])
+		frame = frame[k+int(n):]
+		return s, nil
+	}
+	readUint := func() (uint64, error) {
+		n, k := binary.Uvarint(frame)
+		if k <= 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		frame = frame[k:]
+		return n, nil
+	}
+	if h.ServiceMethod, err = readString(); err != nil {
+		return fmt.Errorf("rpc: proto header ServiceMethod: %w", err)
+	}
+	if h.SeqId, err = readUint(); err != nil {
+		return fmt.Errorf("rpc: proto header SeqId: %w", err)
+	}
+	if h.Error, err = readString(); err != nil {
+		return fmt.Errorf("rpc: proto header Error: %w", err)
+	}
+	if h.ReplyHash, err = readUint(); err != nil {
+		return fmt.Errorf("rpc: proto header ReplyHash: %w", err)
+	}
+	h.Metadata, h.Compressed, h.ErrorCode, h.Deadline = nil, false, 0, 0
+	if len(frame) == 0 {
+		return nil
+	}
+	n, err := readUint()
+	if err != nil || n > uint64(len(frame)) {
+		return fmt.Errorf("rpc: proto header Metadata: %w", io.ErrUnexpectedEOF)
+	}
+	if n > 0 {
+		h.Metadata = make(map[string]string, n)
+	}
+	for i := uint64(0); i < n; i++ {
+		k, err := readString()
+		if err != nil {
+			return fmt.Errorf("rpc: proto header Metadata: %w", err)
+		}
+		if h.Metadata[k], err = readString(); err != nil {
+			return fmt.Errorf("rpc: proto header Metadata: %w", err)
+		}
+	}
+	if len(frame) == 0 {
+		return nil
+	}
+	flags, err := readUint()
+	if err != nil {
+		return fmt.Errorf("rpc: proto header flags: %w", err)
+	}
+	h.Compressed = flags&1 != 0
+	readInt := func() (int64, error) {
+		n, k := binary.Varint(frame)
+		if k <= 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		frame = frame[k:]
+		return n, nil
+	}
+	if flags&2 != 0 {
+		code, err := readInt()
+		if err != nil {
+			return fmt.Errorf("rpc: proto header ErrorCode: %w", err)
+		}
+		h.ErrorCode = int(code)
+	}
+	if flags&4 != 0 {
+		if h.Deadline, err = readInt(); err != nil {
+			return fmt.Errorf("rpc: proto header Deadline: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *ProtoCode) ReadBody(body interface{}) error {
+	frame, err := c.readFrame()
+	if err != nil || body == nil {
+		return err
+	}
+	if raw, ok := body.(*RawBody); ok {
+		*raw = frame
+		return nil
+	}
+	m, ok := body.(proto.Message)
+	if !ok {
+		return fmt.Errorf("rpc: proto codec: %T does not implement proto.Message", body)
+	}
+	return proto.Unmarshal(frame, m)
+}
+
+func (c *ProtoCode) Write(h *Header, body interface{}) (err error) {
+	var frame []byte
+	// 控制帧和错误响应使用的 struct{}{} 与 nil 一样写为空帧
+	if _, empty := body.(struct{}); h.Error == "" && body != nil && !empty {
+		switch m := body.(type) {
+		case RawBody:
+			frame = m // 已经编码好的 body，原样写出
+		case proto.Message:
+			if frame, err = proto.Marshal(m); err != nil {
+				return fmt.Errorf("rpc: proto error encoding body: %w", err)
+			}
+		default:
+			// 还没有写入任何数据，连接仍然可用
+			return fmt.Errorf("rpc: proto codec: %T does not implement proto.Message", body)
+		}
+	}
+	defer func() {
+		_ = c.buf.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	head := binary.AppendUvarint(nil, uint64(len(h.ServiceMethod)))
+	head = append(head, h.ServiceMethod...)
+	head = binary.AppendUvarint(head, h.SeqId)
+	head = binary.AppendUvarint(head, uint64(len(h.Error)))
+	head = append(head, h.Error...)
+	head = binary.AppendUvarint(head, h.ReplyHash)
+	if len(h.Metadata) > 0 || h.Compressed || h.ErrorCode != 0 || h.Deadline != 0 {
+		keys := make([]string, 0, len(h.Metadata))
+		for k := range h.Metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		head = binary.AppendUvarint(head, uint64(len(keys)))
+		for _, k := range keys {
+			head = binary.AppendUvarint(head, uint64(len(k)))
+			head = append(head, k...)
+			head = binary.AppendUvarint(head, uint64(len(h.Metadata[k])))
+			head = append(head, h.Metadata[k]...)
+		}
+		var flags uint64
+		if h.Compressed {
+			flags |= 1
+		}
+		if h.ErrorCode != 0 {
+			flags |= 2
+		}
+		if h.Deadline != 0 {
+			flags |= 4
+		}
+		if flags != 0 {
+			head = binary.AppendUvarint(head, flags)
+		}
+		if h.ErrorCode != 0 {
+			head = binary.AppendVarint(head, int64(h.ErrorCode))
+		}
+		if h.Deadline != 0 {
+			head = binary.AppendVarint(head, h.Deadline)
+		}
+	}
+	if err = c.writeFrame(head); err != nil {
+		err = fmt.Errorf("rpc: proto error encoding header: %w", err)
+		return
+	}
+	if err = c.writeFrame(frame); err != nil {
+		err = fmt.Errorf("rpc: proto error encoding body: %w", err)
+		return
+	}
+	return
+}
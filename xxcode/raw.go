@@ -0,0 +1,43 @@
+package xxcode
+
+import "errors"
+
+// RawBody 作为 ReadBody 的参数时接收未经解码的 body，调用方之后可以自行解码：
+// JsonCode 和 FramedCode 得到 body 的 JSON 文本，ProtoCode 得到 protobuf 的二进制编码，
+// CompressCode 得到解压后的同样内容。gob 的 body 依赖同一连接上之前发送的类型定义，
+// 不能单独解码，GobCode 读取 RawBody 时跳过 body 并返回 ErrRawUnsupported。
+// 作为 Write 的 body 时，RawBody 被当作已经按这些格式编码好的 body 原样写出。
+type RawBody []byte
+
+// ErrRawUnsupported 表示编解码器不能以 RawBody 读取或写入 body
+var ErrRawUnsupported = errors.New("rpc: codec doesn't support raw bodies")
+
+// MarshalJSON 原样返回 r，空的 RawBody 编码为 null
+func (r RawBody) MarshalJSON() ([]byte, error) {
+	if len(r) == 0 {
+		return []byte("null"), nil
+	}
+	return r, nil
+}
+
+// UnmarshalJSON 将 data 复制到 r 中
+func (r *RawBody) UnmarshalJSON(data []byte) error {
+	*r = append((*r)[:0], data...)
+	return nil
+}
+
+// RawBytesWriter 由能够把 []byte 和 *[]byte body 不经编码原样写出的编解码器实现，目前只有 GobCode。
+// 读取时总是识别这样的 body，写出需要对端也能识别，因此默认关闭，由双方的协议版本决定是否开启。
+type RawBytesWriter interface {
+	// SetRawBytes 设置之后写出的 []byte body 是否原样写出，header 的 RawBytes 标记这样的 body
+	SetRawBytes(on bool)
+}
+
+// SetRawBytes 在 cc 实现了 RawBytesWriter 时设置是否原样写出 []byte body，返回是否设置成功
+func SetRawBytes(cc Code, on bool) bool {
+	w, ok := cc.(RawBytesWriter)
+	if ok {
+		w.SetRawBytes(on)
+	}
+	return ok
+}
@@ -0,0 +1,22 @@
+package xxcode
+
+import (
+	"encoding/gob"
+)
+
+// countingWriter 只统计写入的字节数，不保存数据
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// SizeOf 返回 v 经 gob 编码后的近似字节数（包含类型描述），无法编码时返回 0
+func SizeOf(v interface{}) int64 {
+	var w countingWriter
+	if err := gob.NewEncoder(&w).Encode(v); err != nil {
+		return 0
+	}
+	return int64(w)
+}
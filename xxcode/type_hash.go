@@ -0,0 +1,72 @@
+package xxcode
+
+import (
+	"go/ast"
+	"hash/fnv"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// TypeHash 返回类型在 gob 编码下的结构指纹。
+// 与 gob 的兼容规则一致：指针被展开，只有导出字段参与计算，同类数值（如 int32 与 int64）视为相同，
+// 因此两端各自定义但结构兼容的类型得到相同的指纹。
+func TypeHash(t reflect.Type) uint64 {
+	var b strings.Builder
+	writeType(&b, t, map[reflect.Type]bool{})
+	h := fnv.New64a()
+	_, _ = io.WriteString(h, b.String())
+	return h.Sum64()
+}
+
+func writeType(b *strings.Builder, t reflect.Type, seen map[reflect.Type]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		b.WriteString("bool")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		b.WriteString("int")
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		b.WriteString("uint")
+	case reflect.Float32, reflect.Float64:
+		b.WriteString("float")
+	case reflect.Complex64, reflect.Complex128:
+		b.WriteString("complex")
+	case reflect.String:
+		b.WriteString("string")
+	case reflect.Interface:
+		b.WriteString("interface")
+	case reflect.Array, reflect.Slice:
+		b.WriteString("[]")
+		writeType(b, t.Elem(), seen)
+	case reflect.Map:
+		b.WriteString("map[")
+		writeType(b, t.Key(), seen)
+		b.WriteString("]")
+		writeType(b, t.Elem(), seen)
+	case reflect.Struct:
+		if seen[t] {
+			// 递归类型只记录一次结构
+			b.WriteString("recursive")
+			return
+		}
+		seen[t] = true
+		b.WriteString("struct{")
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !ast.IsExported(f.Name) {
+				continue
+			}
+			b.WriteString(f.Name)
+			b.WriteString(" ")
+			writeType(b, f.Type, seen)
+			b.WriteString(";")
+		}
+		b.WriteString("}")
+		delete(seen, t)
+	default:
+		b.WriteString(t.Kind().String())
+	}
+}
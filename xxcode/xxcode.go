@@ -1,15 +1,68 @@
 package xxcode
 
 import (
+	"encoding/json"
+	"errors"
 	"io"
+	"reflect"
+	"sync"
 )
 
+// Header 是每个请求和响应的消息头。
+//
+// 非 Go 客户端实现协议时以 JSON 编码为准（Type_Json），header 是一个按以下固定顺序
+// 输出字段的对象，除 metadata 外总是输出全部字段，紧随其后的是 body：
+//
+//	{"service_method":"Foo.Sum","seq":1,"error":"","reply_hash":0,"metadata":{"trace-id":"abc"},"compressed":true,"error_code":1,"deadline":1700000000000000000}
+//
+//	service_method  string  "<service>.<method>"，或 CancelServiceMethod 等控制帧
+//	seq             uint64  请求序列号，响应与请求相同；OneWaySeqId 表示单向请求
+//	error           string  响应的错误信息，为空表示成功，请求中总为空
+//	reply_hash      uint64  响应中 reply 类型的指纹，见 TypeHash，gob 之外的客户端可以忽略
+//	metadata        object  请求携带的字符串键值对，例如追踪 ID，没有时省略，响应中总是省略
+//	compressed      bool    body 经过握手时协商的算法压缩，见 CompressCode，为 false 时省略
+//	error_code      int     响应中错误的分类，见 CodeNotFound 等，为 0 时省略
+//	deadline        int64   请求的截止时间，Unix 纳秒，来自客户端 context 的 deadline，没有时省略，响应中总是省略
+//
+// Type_Proto 使用相同的字段顺序，见 ProtoCode。
 type Header struct {
-	ServiceMethod string // 服务名和方法名
-	SeqId         uint64 // 请求序列号
-	Error         string
+	ServiceMethod string            `json:"service_method"` // 服务名和方法名
+	SeqId         uint64            `json:"seq"`            // 请求序列号
+	Error         string            `json:"error"`
+	ReplyHash     uint64            `json:"reply_hash"`           // 响应中 reply 类型的指纹，见 TypeHash
+	Metadata      map[string]string `json:"metadata,omitempty"`   // 请求携带的元数据
+	Compressed    bool              `json:"compressed,omitempty"` // body 经过压缩，见 CompressCode
+	ErrorCode     int               `json:"error_code,omitempty"` // 错误的分类，0 表示没有分类
+	Deadline      int64             `json:"deadline,omitempty"`   // 请求的截止时间（Unix 纳秒），0 表示没有
+	RawBytes      bool              `json:"-"`                    // body 是原样写出的 []byte，只有 GobCode 使用，见 SetRawBytes
 }
 
+// 框架使用的错误码，处理函数返回的错误实现 interface{ Code() int } 时使用它返回的错误码，
+// 自定义的错误码应避开这些值。
+const (
+	CodeNotFound        = 1 // 找不到服务或方法
+	CodeTimeout         = 2 // 处理超时
+	CodeInternal        = 3 // 处理函数 panic 等服务端内部错误
+	CodeUnauthenticated = 4 // 认证失败
+	CodeUnavailable     = 5 // 服务端繁忙或限流，稍后重试可能成功
+)
+
+// OneWaySeqId 标记单向请求：服务端照常调用方法，但不发送任何响应，包括错误。
+const OneWaySeqId uint64 = 0
+
+// 控制帧使用保留的 ServiceMethod，与普通请求一样由 header 和 body 组成，body 为空结构体。
+const (
+	// CancelServiceMethod 取消 SeqId 相同的进行中请求：服务端取消该请求处理函数的 context，
+	// 不发送响应。
+	CancelServiceMethod = "__cancel__"
+	// PingServiceMethod 检查连接是否可用：服务端丢弃 body，立即以相同的 SeqId 回复一个空 body，
+	// 这是唯一会得到响应的控制帧。
+	PingServiceMethod = "__ping__"
+	// RejectServiceMethod 由服务端在握手失败（如 MagicNumber 不匹配、认证失败）时发送，
+	// Error 和 ErrorCode 说明拒绝的原因，之后服务端关闭连接。
+	RejectServiceMethod = "__reject__"
+)
+
 type Code interface {
 	io.Closer
 	ReadHeader(*Header) error
@@ -17,6 +70,18 @@ type Code interface {
 	Write(*Header, interface{}) error
 }
 
+// Capturer is implemented by codecs that can expose the raw bytes of individual messages.
+type Capturer interface {
+	// WriteCaptured behaves like Write and also returns the bytes written for the message.
+	WriteCaptured(*Header, interface{}) ([]byte, error)
+	// CaptureReads turns on recording of the bytes read by ReadHeader and ReadBody
+	// for one more pending message.
+	CaptureReads()
+	// Captured returns a copy of the bytes read since the last ReadHeader began and
+	// hands back one CaptureReads; recording stops once every one has been handed back.
+	Captured() []byte
+}
+
 // TODO:
 
 type NewCodeFunc func(closer io.ReadWriteCloser) Code
@@ -24,13 +89,86 @@ type NewCodeFunc func(closer io.ReadWriteCloser) Code
 type Type string
 
 const (
-	Type_Gob  Type = "application/gob"
-	Type_Json Type = "application/json" // not implemented
+	Type_Gob   Type = "application/gob"
+	Type_Json  Type = "application/json"
+	Type_Proto Type = "application/protobuf"
+	// Type_Framed 为每个 header 和 body 加上长度前缀，见 FramedCode
+	Type_Framed Type = "application/x-xxrpc-framed+json"
 )
 
+// NewCodeFuncMap 是全局注册的编解码器。运行期间应通过 RegisterCodec 添加，通过 CodeFunc 或 CodeFuncs 读取；
+// 直接读取这个 map 仍然可行，但与并发的 RegisterCodec 之间没有同步。
 var NewCodeFuncMap map[Type]NewCodeFunc
 
+var codecMu sync.RWMutex // 保护 NewCodeFuncMap
+
 func init() {
 	NewCodeFuncMap = make(map[Type]NewCodeFunc)
 	NewCodeFuncMap[Type_Gob] = NewGobCode
+	NewCodeFuncMap[Type_Json] = NewJsonCode
+	NewCodeFuncMap[Type_Proto] = NewProtoCode
+	NewCodeFuncMap[Type_Framed] = NewFramedCode
+}
+
+// RegisterCodec 在全局注册类型为 t 的编解码器，或替换 t 已有的实现，可以与 CodeFunc 等并发调用。
+// 只想让一个 Server 支持 t 时使用 Server.RegisterCodec。
+func RegisterCodec(t Type, f NewCodeFunc) error {
+	if t == "" || f == nil {
+		return errors.New("rpc: register codec: empty type or nil func")
+	}
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	NewCodeFuncMap[t] = f
+	return nil
+}
+
+// CodeFunc 返回全局注册的类型为 t 的编解码器，没有注册时返回 nil
+func CodeFunc(t Type) NewCodeFunc {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	return NewCodeFuncMap[t]
+}
+
+// CodeFuncs 返回全局注册的编解码器的副本
+func CodeFuncs() map[Type]NewCodeFunc {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	funcs := make(map[Type]NewCodeFunc, len(NewCodeFuncMap))
+	for t, f := range NewCodeFuncMap {
+		funcs[t] = f
+	}
+	return funcs
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// PreferCodecs 根据 body 的类型返回编解码器的偏好列表，可以用作 Option.AcceptCodecs：
+// body 实现了 json.Marshaler 或者是带有 json 标签的结构体时优先使用 JSON，
+// 否则优先使用 gob，适合 Go 程序之间的调用。
+func PreferCodecs(body interface{}) []Type {
+	if prefersJSON(reflect.TypeOf(body)) {
+		return []Type{Type_Json, Type_Gob}
+	}
+	return []Type{Type_Gob, Type_Json}
+}
+
+func prefersJSON(t reflect.Type) bool {
+	if t == nil {
+		return false
+	}
+	if t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType) {
+		return true
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if _, ok := t.Field(i).Tag.Lookup("json"); ok {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,502 @@
+package xxcode
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCode_Metadata(t *testing.T) {
+	for _, typ := range []Type{Type_Gob, Type_Json, Type_Proto, Type_Framed} {
+		c1, c2 := net.Pipe()
+		w, r := NewCodeFuncMap[typ](c1), NewCodeFuncMap[typ](c2)
+
+		md := map[string]string{"trace-id": "abc", "tenant": "t1", "empty": ""}
+		go func() {
+			_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 1, Metadata: md}, struct{}{})
+			_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 2}, struct{}{})
+		}()
+
+		var h Header
+		if err := r.ReadHeader(&h); err != nil || !reflect.DeepEqual(h.Metadata, md) {
+			t.Fatalf("%s: expect metadata %v, got %v (%v)", typ, md, h.Metadata, err)
+		}
+		_ = r.ReadBody(nil)
+		var empty Header
+		if err := r.ReadHeader(&empty); err != nil || len(empty.Metadata) != 0 || empty.SeqId != 2 {
+			t.Fatalf("%s: expect no metadata, got %+v (%v)", typ, empty, err)
+		}
+		_ = r.ReadBody(nil)
+		_ = w.Close()
+		_ = r.Close()
+	}
+}
+
+// countConn 记录写入的字节数
+type countConn struct {
+	net.Conn
+	n int
+}
+
+func (c *countConn) Write(p []byte) (int, error) {
+	c.n += len(p)
+	return c.Conn.Write(p)
+}
+
+func TestCompressCode(t *testing.T) {
+	large := make([]string, 2000)
+	for i := range large {
+		large[i] = "repeated payload"
+	}
+	for _, algo := range []string{CompressNone, CompressGzip} {
+		for _, typ := range []Type{Type_Gob, Type_Json, Type_Proto} {
+			c1, c2 := net.Pipe()
+			counter := &countConn{Conn: c1}
+			w, _ := NewCompressCode(NewCodeFuncMap[typ](counter), typ, algo)
+			r, _ := NewCompressCode(NewCodeFuncMap[typ](c2), typ, algo)
+
+			var body, small interface{} = large, "small"
+			if typ == Type_Proto {
+				list, _ := structpb.NewList(nil)
+				for _, s := range large {
+					list.Values = append(list.Values, structpb.NewStringValue(s))
+				}
+				body, small = list, wrapperspb.String("small")
+			}
+			go func() {
+				_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 1}, body)
+				_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 2}, small)
+			}()
+
+			var h Header
+			if err := r.ReadHeader(&h); err != nil || h.Compressed != (algo == CompressGzip) {
+				t.Fatalf("%s/%s: expect compressed %v, got %+v (%v)", algo, typ, algo == CompressGzip, h, err)
+			}
+			if typ == Type_Proto {
+				var got structpb.ListValue
+				if err := r.ReadBody(&got); err != nil || len(got.Values) != len(large) || got.Values[0].GetStringValue() != large[0] {
+					t.Fatalf("%s/%s: expect the large list back, got %d values (%v)", algo, typ, len(got.Values), err)
+				}
+			} else {
+				var got []string
+				if err := r.ReadBody(&got); err != nil || !reflect.DeepEqual(got, large) {
+					t.Fatalf("%s/%s: expect the large slice back, got %d items (%v)", algo, typ, len(got), err)
+				}
+			}
+			// 小的 body 不压缩
+			if err := r.ReadHeader(&h); err != nil || h.Compressed || h.SeqId != 2 {
+				t.Fatalf("%s/%s: expect the small body uncompressed, got %+v (%v)", algo, typ, h, err)
+			}
+			_ = r.ReadBody(nil)
+			if algo == CompressGzip && counter.n > 2000 {
+				t.Fatalf("%s/%s: expect compressed bytes on the wire, wrote %d", algo, typ, counter.n)
+			}
+			if algo == CompressNone && counter.n < 2000*len(large[0]) {
+				t.Fatalf("%s/%s: expect uncompressed bytes on the wire, wrote %d", algo, typ, counter.n)
+			}
+			_ = w.Close()
+			_ = r.Close()
+		}
+	}
+	if _, err := NewCompressCode(nil, Type_Gob, "snappy"); err == nil {
+		t.Fatal("expect an error for an unsupported compression")
+	}
+}
+
+func TestFramedCode_GarbageBody(t *testing.T) {
+	c1, c2 := net.Pipe()
+	w, r := NewFramedCode(c1), NewFramedCode(c2)
+	defer func() { _ = w.Close(); _ = r.Close() }()
+	go func() {
+		_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 1}, 1)
+		// 第二条消息的 body 帧不是合法的 JSON
+		head, _ := json.Marshal(&Header{ServiceMethod: "Foo.Sum", SeqId: 2})
+		fc := w.(*FramedCode)
+		_ = fc.writeFrame(head)
+		_ = fc.writeFrame([]byte("{garbage"))
+		_ = fc.buf.Flush()
+		_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 3}, 3)
+	}()
+
+	var h Header
+	// ReadBody(nil) 直接跳过 body 帧
+	if err := r.ReadHeader(&h); err != nil || h.SeqId != 1 {
+		t.Fatalf("expect seq 1, got %+v (%v)", h, err)
+	}
+	if err := r.ReadBody(nil); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := r.ReadHeader(&h); err != nil || h.SeqId != 2 {
+		t.Fatalf("expect seq 2, got %+v (%v)", h, err)
+	}
+	if err := r.ReadBody(&n); err == nil {
+		t.Fatal("expect the garbage body to fail decoding")
+	}
+	if err := r.ReadHeader(&h); err != nil || h.SeqId != 3 {
+		t.Fatalf("expect the stream to stay in sync, got %+v (%v)", h, err)
+	}
+	if err := r.ReadBody(&n); err != nil || n != 3 {
+		t.Fatalf("expect body 3, got %d (%v)", n, err)
+	}
+}
+
+func TestCode_ErrorCode(t *testing.T) {
+	for _, typ := range []Type{Type_Gob, Type_Json, Type_Proto, Type_Framed} {
+		c1, c2 := net.Pipe()
+		w, r := NewCodeFuncMap[typ](c1), NewCodeFuncMap[typ](c2)
+		go func() {
+			_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 1, Error: "not found", ErrorCode: CodeNotFound}, struct{}{})
+			_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 2, Error: "custom", ErrorCode: -7, Metadata: map[string]string{"k": "v"}}, struct{}{})
+			_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 3}, struct{}{})
+		}()
+		for _, want := range []int{CodeNotFound, -7, 0} {
+			var h Header
+			if err := r.ReadHeader(&h); err != nil || h.ErrorCode != want {
+				t.Fatalf("%s: expect error code %d, got %+v (%v)", typ, want, h, err)
+			}
+			_ = r.ReadBody(nil)
+		}
+		_ = w.Close()
+		_ = r.Close()
+	}
+}
+
+func TestCode_Deadline(t *testing.T) {
+	deadline := time.Now().Add(time.Second).UnixNano()
+	for _, typ := range []Type{Type_Gob, Type_Json, Type_Proto, Type_Framed} {
+		c1, c2 := net.Pipe()
+		w, r := NewCodeFuncMap[typ](c1), NewCodeFuncMap[typ](c2)
+		go func() {
+			_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 1, Deadline: deadline}, struct{}{})
+			_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 2, Deadline: deadline, ErrorCode: CodeTimeout, Compressed: true}, struct{}{})
+			_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 3}, struct{}{})
+		}()
+		for _, want := range []int64{deadline, deadline, 0} {
+			var h Header
+			if err := r.ReadHeader(&h); err != nil || h.Deadline != want {
+				t.Fatalf("%s: expect deadline %d, got %+v (%v)", typ, want, h, err)
+			}
+			_ = r.ReadBody(nil)
+		}
+		_ = w.Close()
+		_ = r.Close()
+	}
+}
+
+func TestCode_ReadLimit(t *testing.T) {
+	large := strings.Repeat("x", 4096)
+	for _, algo := range []string{CompressNone, CompressGzip} {
+		for _, typ := range []Type{Type_Gob, Type_Json, Type_Proto, Type_Framed} {
+			c1, c2 := net.Pipe()
+			w, _ := NewCompressCode(NewCodeFuncMap[typ](c1), typ, algo)
+			r, _ := NewCompressCode(NewCodeFuncMap[typ](c2), typ, algo)
+			if !SetReadLimit(r, 1024) {
+				t.Fatalf("%s/%s: expect the codec to support read limits", algo, typ)
+			}
+
+			var body, small interface{} = large, "small"
+			if typ == Type_Proto {
+				body, small = wrapperspb.String(large), wrapperspb.String("small")
+			}
+			go func() {
+				_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 1}, small)
+				_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 2}, body)
+			}()
+
+			var h Header
+			if err := r.ReadHeader(&h); err != nil || h.SeqId != 1 {
+				t.Fatalf("%s/%s: expect the small message, got %+v (%v)", algo, typ, h, err)
+			}
+			if err := r.ReadBody(nil); err != nil {
+				t.Fatalf("%s/%s: expect the small body within the limit, got %v", algo, typ, err)
+			}
+			err := r.ReadHeader(&h)
+			if err == nil {
+				if typ == Type_Proto {
+					err = r.ReadBody(new(wrapperspb.StringValue))
+				} else {
+					err = r.ReadBody(new(string))
+				}
+			}
+			if !errors.Is(err, ErrMessageTooLarge) {
+				t.Fatalf("%s/%s: expect ErrMessageTooLarge, got %v", algo, typ, err)
+			}
+			_ = w.Close()
+			_ = r.Close()
+		}
+	}
+}
+
+type taggedBody struct {
+	Name string `json:"name"`
+}
+
+type marshalerBody int
+
+func (marshalerBody) MarshalJSON() ([]byte, error) { return []byte("0"), nil }
+
+func TestPreferCodecs(t *testing.T) {
+	tests := []struct {
+		body interface{}
+		want Type
+	}{
+		{1, Type_Gob},
+		{struct{ Name string }{}, Type_Gob},
+		{nil, Type_Gob},
+		{taggedBody{}, Type_Json},
+		{&taggedBody{}, Type_Json},
+		{marshalerBody(0), Type_Json},
+		{json.RawMessage("{}"), Type_Json},
+	}
+	for _, tt := range tests {
+		if got := PreferCodecs(tt.body); len(got) != 2 || got[0] != tt.want {
+			t.Fatalf("%T: expect %s first, got %v", tt.body, tt.want, got)
+		}
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+	if err := RegisterCodec("", NewGobCode); err == nil {
+		t.Fatal("expect an error for an empty codec type")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		typ := Type(fmt.Sprintf("application/x-test-%d", i))
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := RegisterCodec(typ, NewJsonCode); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_ = CodeFunc(typ)
+			_ = CodeFuncs()
+			if CodeFunc(Type_Gob) == nil {
+				t.Error("expect gob to stay registered")
+			}
+		}()
+	}
+	wg.Wait()
+	funcs := CodeFuncs()
+	for i := 0; i < 8; i++ {
+		typ := Type(fmt.Sprintf("application/x-test-%d", i))
+		if CodeFunc(typ) == nil || funcs[typ] == nil || NewCodeFuncMap[typ] == nil {
+			t.Fatalf("expect %s to be registered", typ)
+		}
+	}
+}
+
+func TestCode_RawBody(t *testing.T) {
+	for _, typ := range []Type{Type_Gob, Type_Json, Type_Framed} {
+		c1, c2 := net.Pipe()
+		w, r := NewCodeFuncMap[typ](c1), NewCodeFuncMap[typ](c2)
+		go func() {
+			_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 1}, []int{1, 2})
+			_ = w.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: 2}, struct{}{})
+		}()
+		var h Header
+		_ = r.ReadHeader(&h)
+		var raw RawBody
+		err := r.ReadBody(&raw)
+		if typ == Type_Gob {
+			if !errors.Is(err, ErrRawUnsupported) {
+				t.Fatalf("%s: expect ErrRawUnsupported, got %v", typ, err)
+			}
+		} else if err != nil || string(raw) != "[1,2]" {
+			t.Fatalf("%s: expect the raw JSON body, got %q (%v)", typ, raw, err)
+		}
+		// 读取 RawBody 之后连接仍然对齐到下一条消息
+		if err = r.ReadHeader(&h); err != nil || h.SeqId != 2 {
+			t.Fatalf("%s: expect the next message, got %+v (%v)", typ, h, err)
+		}
+		_ = r.ReadBody(nil)
+		_ = w.Close()
+		_ = r.Close()
+	}
+}
+
+func TestCode_Release(t *testing.T) {
+	for _, typ := range []Type{Type_Gob, Type_Json, Type_Framed} {
+		// 第一个连接上留下还没有读取的消息，它们不能出现在复用缓冲的下一个连接上
+		first := NewCodeFuncMap[typ](new(loopConn))
+		for seq := uint64(1); seq <= 2; seq++ {
+			if err := first.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: seq}, []int{1, 2}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var h Header
+		if err := first.ReadHeader(&h); err != nil || first.ReadBody(nil) != nil {
+			t.Fatalf("%s: %v", typ, err)
+		}
+		if !ReleaseCode(first) {
+			t.Fatalf("%s: expect the codec to release its buffers", typ)
+		}
+
+		second := NewCodeFuncMap[typ](new(loopConn))
+		if err := second.Write(&Header{ServiceMethod: "Bar.Echo", SeqId: 3}, []int{3}); err != nil {
+			t.Fatal(err)
+		}
+		var reply []int
+		if err := second.ReadHeader(&h); err != nil || second.ReadBody(&reply) != nil {
+			t.Fatalf("%s: %v", typ, err)
+		}
+		if h.ServiceMethod != "Bar.Echo" || h.SeqId != 3 || !reflect.DeepEqual(reply, []int{3}) {
+			t.Fatalf("%s: expect the new connection's message, got %+v %v", typ, h, reply)
+		}
+		ReleaseCode(second)
+	}
+}
+
+// loopConn 把写入的数据原样读回，编解码器读取的正是自己写入的消息
+type loopConn struct{ bytes.Buffer }
+
+func (c *loopConn) Close() error { return nil }
+
+type benchArgs struct{ Num1, Num2 int }
+
+// benchCall 写入一个请求并读回，相当于一次调用在连接上的编解码
+func benchCall(b *testing.B, cc Code, seq uint64) {
+	if err := cc.Write(&Header{ServiceMethod: "Foo.Sum", SeqId: seq}, &benchArgs{Num1: 1, Num2: 2}); err != nil {
+		b.Fatal(err)
+	}
+	var h Header
+	var args benchArgs
+	if err := cc.ReadHeader(&h); err != nil {
+		b.Fatal(err)
+	}
+	if err := cc.ReadBody(&args); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkGobCode_Call(b *testing.B) {
+	cc := NewGobCode(new(loopConn))
+	benchCall(b, cc, 0) // 类型描述只在连接上第一次出现时发送
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		benchCall(b, cc, uint64(i))
+	}
+}
+
+// BenchmarkGobCode_Conn 每次迭代在新连接上创建编解码器，完成一次调用后释放
+func BenchmarkGobCode_Conn(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cc := NewGobCode(new(loopConn))
+		benchCall(b, cc, 1)
+		ReleaseCode(cc)
+	}
+}
+
+func TestGobCode_RawBytes(t *testing.T) {
+	conn := new(loopConn)
+	cc := NewGobCode(conn)
+	SetRawBytes(cc, true)
+	blob := []byte("already serialized")
+	h := &Header{ServiceMethod: "Blob.Echo", SeqId: 1}
+	for _, body := range []interface{}{blob, &blob, blob, blob} {
+		if err := cc.Write(h, body); err != nil {
+			t.Fatal(err)
+		}
+		if !h.RawBytes {
+			t.Fatalf("expect %T to be written verbatim", body)
+		}
+	}
+	if !bytes.Contains(conn.Bytes(), blob) {
+		t.Fatal("expect the bytes to appear unencoded on the wire")
+	}
+	// 复用的 header 在下一条非 []byte 消息上清除 RawBytes
+	if err := cc.Write(h, 3); err != nil || h.RawBytes {
+		t.Fatalf("expect a gob body, got RawBytes %v (%v)", h.RawBytes, err)
+	}
+
+	var got []byte
+	var rh Header
+	for i, body := range []interface{}{&got, &got, nil} {
+		if err := cc.ReadHeader(&rh); err != nil || !rh.RawBytes {
+			t.Fatalf("message %d: expect a raw bytes header, got %+v (%v)", i, rh, err)
+		}
+		if err := cc.ReadBody(body); err != nil {
+			t.Fatal(err)
+		}
+		if body != nil && string(got) != string(blob) {
+			t.Fatalf("message %d: expect %q, got %q", i, blob, got)
+		}
+	}
+	// 读入其他类型时报告错误，但仍然读完这个 body
+	var n int
+	_ = cc.ReadHeader(&rh)
+	if err := cc.ReadBody(&n); err == nil {
+		t.Fatal("expect an error reading a []byte body into *int")
+	}
+	if err := cc.ReadHeader(&rh); err != nil || rh.RawBytes || cc.ReadBody(&n) != nil || n != 3 {
+		t.Fatalf("expect the next gob message, got %+v %d (%v)", rh, n, err)
+	}
+
+	// 没有开启时 []byte 照常经过 gob 编码，读取方同样可以读入 *[]byte
+	SetRawBytes(cc, false)
+	if err := cc.Write(h, blob); err != nil || h.RawBytes {
+		t.Fatalf("expect a gob body, got RawBytes %v (%v)", h.RawBytes, err)
+	}
+	got = nil
+	if err := cc.ReadHeader(&rh); err != nil || cc.ReadBody(&got) != nil || string(got) != string(blob) {
+		t.Fatalf("expect %q, got %q (%v)", blob, got, err)
+	}
+
+	// 原样写出的 body 同样受大小限制约束，在分配缓冲之前检查
+	SetRawBytes(cc, true)
+	SetReadLimit(cc, 64)
+	if err := cc.Write(h, make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.ReadHeader(&rh); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.ReadBody(&got); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("expect ErrMessageTooLarge, got %v", err)
+	}
+}
+
+// BenchmarkGobCode_Bytes 比较原样写出与经过 gob 编码的 64KB []byte body
+func BenchmarkGobCode_Bytes(b *testing.B) {
+	blob := bytes.Repeat([]byte("xxrpc"), 64<<10/5)
+	for _, raw := range []bool{true, false} {
+		name := "gob"
+		if raw {
+			name = "raw"
+		}
+		b.Run(name, func(b *testing.B) {
+			cc := NewGobCode(new(loopConn))
+			SetRawBytes(cc, raw)
+			h := &Header{ServiceMethod: "Blob.Echo"}
+			var got []byte
+			b.SetBytes(int64(len(blob)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := cc.Write(h, blob); err != nil {
+					b.Fatal(err)
+				}
+				if err := cc.ReadHeader(h); err != nil {
+					b.Fatal(err)
+				}
+				if err := cc.ReadBody(&got); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}